@@ -20,6 +20,10 @@
 
 package checked
 
+import (
+	"sync/atomic"
+)
+
 var (
 	defaultBytesOptions = NewBytesOptions()
 )
@@ -52,13 +56,24 @@ type Bytes interface {
 
 	// Reset will reset the reference referred to by the bytes.
 	Reset(v []byte)
+
+	// Slice returns checked bytes referring to the bytes in the range
+	// [start, end) that share the same underlying array. The slice holds a
+	// ref to the bytes until the slice is finalized, finalizing the bytes
+	// while slices are still live is deferred until the last slice is
+	// finalized. Reads and writes to the slice are also checked against the
+	// bytes themselves.
+	Slice(start, end int) Bytes
 }
 
 type bytesRef struct {
 	RefCount
 
-	opts  BytesOptions
-	value []byte
+	opts            BytesOptions
+	value           []byte
+	parent          *bytesRef
+	children        int32
+	finalizePending int32
 }
 
 // NewBytes returns a new checked byte slice.
@@ -124,12 +139,85 @@ func (b *bytesRef) Reset(v []byte) {
 	b.DecWrites()
 }
 
+func (b *bytesRef) Slice(start, end int) Bytes {
+	b.IncReads()
+	// Limit the capacity so appends to the slice never overwrite
+	// bytes beyond the end of the slice.
+	value := b.value[start:end:end]
+	b.DecReads()
+
+	b.IncRef()
+	atomic.AddInt32(&b.children, 1)
+
+	slice := &bytesRef{
+		opts:   defaultBytesOptions,
+		value:  value,
+		parent: b,
+	}
+	slice.SetFinalizer(slice)
+	if leakDetectionEnabled() {
+		slice.TrackObject(slice.value)
+	}
+	return slice
+}
+
+func (b *bytesRef) IncReads() {
+	b.RefCount.IncReads()
+	if b.parent != nil {
+		b.parent.IncReads()
+	}
+}
+
+func (b *bytesRef) DecReads() {
+	if b.parent != nil {
+		b.parent.DecReads()
+	}
+	b.RefCount.DecReads()
+}
+
+func (b *bytesRef) IncWrites() {
+	b.RefCount.IncWrites()
+	if b.parent != nil {
+		b.parent.IncWrites()
+	}
+}
+
+func (b *bytesRef) DecWrites() {
+	if b.parent != nil {
+		b.parent.DecWrites()
+	}
+	b.RefCount.DecWrites()
+}
+
 func (b *bytesRef) Finalize() {
+	atomic.StoreInt32(&b.finalizePending, 1)
+	if atomic.LoadInt32(&b.children) == 0 &&
+		atomic.CompareAndSwapInt32(&b.finalizePending, 1, 0) {
+		b.finalize()
+	}
+}
+
+func (b *bytesRef) finalize() {
+	if parent := b.parent; parent != nil {
+		b.parent = nil
+		b.value = nil
+		parent.releaseSlice()
+	}
+
 	if finalizer := b.opts.Finalizer(); finalizer != nil {
 		finalizer.FinalizeBytes(b)
 	}
 }
 
+func (b *bytesRef) releaseSlice() {
+	b.DecRef()
+	// Perform any finalize that was deferred while slices were live.
+	if atomic.AddInt32(&b.children, -1) == 0 &&
+		atomic.CompareAndSwapInt32(&b.finalizePending, 1, 0) {
+		b.finalize()
+	}
+}
+
 type bytesOptions struct {
 	finalizer BytesFinalizer
 }
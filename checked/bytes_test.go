@@ -71,3 +71,75 @@ func TestBytes(t *testing.T) {
 	b.Finalize()
 	assert.Equal(t, 1, finalizerCalls)
 }
+
+func TestBytesSlice(t *testing.T) {
+	finalizerCalls := 0
+	finalizer := BytesFinalizerFn(func(finalizing Bytes) {
+		finalizerCalls++
+	})
+
+	b := NewBytes([]byte("abcdef"), NewBytesOptions().SetFinalizer(finalizer))
+	b.IncRef()
+
+	s1 := b.Slice(1, 3)
+	s1.IncRef()
+	assert.Equal(t, 2, b.NumRef())
+	assert.Equal(t, []byte("bc"), s1.Bytes())
+	assert.Equal(t, 2, s1.Cap())
+
+	// Slices of slices hold a ref on their parent slice.
+	s2 := s1.Slice(1, 2)
+	s2.IncRef()
+	assert.Equal(t, 2, s1.NumRef())
+	assert.Equal(t, []byte("c"), s2.Bytes())
+
+	// Appends do not overwrite the bytes beyond the end of the slice.
+	s1.Append('x')
+	assert.Equal(t, []byte("bcx"), s1.Bytes())
+	assert.Equal(t, []byte("abcdef"), b.Bytes())
+
+	// Finalizing the bytes is deferred until all slices are finalized.
+	b.DecRef()
+	b.Finalize()
+	assert.Equal(t, 0, finalizerCalls)
+
+	s1.DecRef()
+	s1.Finalize()
+	assert.Equal(t, 0, finalizerCalls)
+	assert.Equal(t, 1, b.NumRef())
+
+	s2.DecRef()
+	s2.Finalize()
+	assert.Equal(t, 1, finalizerCalls)
+	assert.Equal(t, 0, b.NumRef())
+}
+
+func TestBytesSliceChecksParent(t *testing.T) {
+	var err error
+	SetPanicFn(func(e error) {
+		if err == nil {
+			err = e
+		}
+	})
+	defer ResetPanicFn()
+
+	b := NewBytes([]byte("abcdef"), nil)
+	b.IncRef()
+	s := b.Slice(0, 3)
+	s.IncRef()
+
+	// Writes to a slice are writes to the parent.
+	b.IncWrites()
+	s.Resize(1)
+	assert.Error(t, err)
+	assert.Equal(t, "double write: writes=2, ref=2", err.Error())
+	b.DecWrites()
+
+	// Reads after the parent is erroneously released are caught.
+	err = nil
+	b.DecRef()
+	b.DecRef()
+	s.Bytes()
+	assert.Error(t, err)
+	assert.Equal(t, "read after free: reads=1, ref=0", err.Error())
+}
@@ -269,6 +269,18 @@ func (mr *MockBytesMockRecorder) SetFinalizer(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFinalizer", reflect.TypeOf((*MockBytes)(nil).SetFinalizer), arg0)
 }
 
+// Slice mocks base method
+func (m *MockBytes) Slice(arg0, arg1 int) Bytes {
+	ret := m.ctrl.Call(m, "Slice", arg0, arg1)
+	ret0, _ := ret[0].(Bytes)
+	return ret0
+}
+
+// Slice indicates an expected call of Slice
+func (mr *MockBytesMockRecorder) Slice(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Slice", reflect.TypeOf((*MockBytes)(nil).Slice), arg0, arg1)
+}
+
 // TrackObject mocks base method
 func (m *MockBytes) TrackObject(arg0 interface{}) {
 	m.ctrl.Call(m, "TrackObject", arg0)
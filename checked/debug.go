@@ -61,7 +61,8 @@ var tracebackEntryPool = sync.Pool{New: func() interface{} {
 
 var leaks struct {
 	sync.RWMutex
-	m map[string]uint64
+	m          map[string]*LeakOrigin
+	generation uint64
 }
 
 // PanicFn is a panic function to call on invalid checked state
@@ -122,8 +123,12 @@ func DumpLeaks() []string {
 
 	leaks.RLock()
 
-	for k, v := range leaks.m {
-		r = append(r, fmt.Sprintf("leaked %d bytes, origin:\n%s", v, k))
+	for _, v := range leaks.m {
+		origin := v.Traceback
+		if origin == "" {
+			origin = v.Origin
+		}
+		r = append(r, fmt.Sprintf("leaked %d bytes, origin:\n%s", v.Bytes, origin))
 	}
 
 	leaks.RUnlock()
@@ -141,6 +146,12 @@ func panicRef(c *RefCount, err error) {
 		err = fmt.Errorf("%v, traceback:\n\n%s", err, trace)
 	}
 
+	if eventLogFlag {
+		verr := newRefViolationError(c, err)
+		recordViolation(verr)
+		err = verr
+	}
+
 	panicFn(err)
 }
 
@@ -233,6 +244,7 @@ func (d *debugger) String() string {
 
 type debuggerRef struct {
 	debugger
+	events    eventLog
 	finalizer resource.Finalizer
 }
 
@@ -296,6 +308,10 @@ func getDebuggerRef(c *RefCount) *debuggerRef {
 }
 
 func tracebackEvent(c *RefCount, ref int, e debuggerEvent) {
+	if eventLogFlag {
+		recordEvent(c, ref, e)
+	}
+
 	if !traceback {
 		return
 	}
@@ -315,5 +331,5 @@ func tracebackEvent(c *RefCount, ref int, e debuggerEvent) {
 }
 
 func init() {
-	leaks.m = make(map[string]uint64)
+	leaks.m = make(map[string]*LeakOrigin)
 }
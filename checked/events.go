@@ -0,0 +1,401 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package checked
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
+)
+
+const (
+	defaultEventLog           = false
+	defaultEventLogGoroutines = false
+	defaultEventLogSize       = 16
+	eventCallersMaxDepth      = 16
+)
+
+var (
+	eventLogFlag           = defaultEventLog
+	eventLogGoroutinesFlag = defaultEventLogGoroutines
+	eventLogSize           = defaultEventLogSize
+	pkgPrefix              = reflect.TypeOf(RefCount{}).PkgPath() + "."
+	poolPkgPrefix          = path.Join(path.Dir(pkgPrefix), "pool") + "."
+)
+
+var violations struct {
+	sync.RWMutex
+	m map[string]*ViolationReport
+}
+
+// EnableEventLog turns structured event logging for checked refs on, when
+// enabled invalid checked state is reported to the panic function as a
+// *RefViolationError and recorded for DumpViolations.
+func EnableEventLog() {
+	eventLogFlag = true
+}
+
+// DisableEventLog turns structured event logging for checked refs off.
+func DisableEventLog() {
+	eventLogFlag = false
+}
+
+// EnableEventLogGoroutines turns capturing the goroutine ID of every event
+// on, this is relatively expensive so by default the goroutine ID is only
+// captured for the event that caused a violation.
+func EnableEventLogGoroutines() {
+	eventLogGoroutinesFlag = true
+}
+
+// DisableEventLogGoroutines turns capturing the goroutine ID of every
+// event off.
+func DisableEventLogGoroutines() {
+	eventLogGoroutinesFlag = false
+}
+
+// SetEventLogSize sets the count of events to keep per ref if enabled.
+func SetEventLogSize(value int) {
+	eventLogSize = value
+}
+
+// Frame is a single call site.
+type Frame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s %s:%d", f.Function, f.File, f.Line)
+}
+
+// Event is a structured event recorded against a checked ref.
+type Event struct {
+	// Type is the type of the event, e.g. IncRef or Finalize.
+	Type string
+
+	// Ref is the ref count at the time of the event.
+	Ref int
+
+	// GoroutineID is the ID of the goroutine that caused the event, it is
+	// only captured for the event that caused a violation unless enabled
+	// with EnableEventLogGoroutines.
+	GoroutineID uint64
+
+	// Caller is the first call site outside of the checked package.
+	Caller Frame
+
+	// Time is the time of the event.
+	Time time.Time
+
+	event debuggerEvent
+}
+
+// RefViolationError is passed to the panic function on invalid checked
+// state when event logging is enabled.
+type RefViolationError struct {
+	// Err is the underlying violation.
+	Err error
+
+	// Site is the earliest acquire site without a matching release among
+	// the recorded events, or the site of the violation if there is none.
+	Site Frame
+
+	// Events are the events recorded against the ref, oldest first.
+	Events []Event
+}
+
+func (e *RefViolationError) Error() string {
+	return e.Err.Error()
+}
+
+// ViolationReport is a group of violations that share the same site.
+type ViolationReport struct {
+	// Site is the site shared by the violations.
+	Site Frame
+
+	// Count is the number of violations with the site.
+	Count int
+
+	// Sample is the most recent violation with the site.
+	Sample *RefViolationError
+}
+
+// DumpViolations returns all violations recorded so far grouped by site
+// with the most frequent first.
+func DumpViolations() []ViolationReport {
+	violations.RLock()
+	r := make([]ViolationReport, 0, len(violations.m))
+	for _, v := range violations.m {
+		r = append(r, *v)
+	}
+	violations.RUnlock()
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Count != r[j].Count {
+			return r[i].Count > r[j].Count
+		}
+		return r[i].Site.String() < r[j].Site.String()
+	})
+	return r
+}
+
+// ResetViolations resets all violations recorded so far.
+func ResetViolations() {
+	violations.Lock()
+	violations.m = make(map[string]*ViolationReport)
+	violations.Unlock()
+}
+
+// NewInstrumentedPanicFn returns a panic function that emits a metric and
+// logs the violation with its fields rather than panicking, suitable for
+// use in production with SetPanicFn.
+func NewInstrumentedPanicFn(opts instrument.Options) PanicFn {
+	scope := opts.MetricsScope().SubScope("checked")
+	logger := opts.Logger()
+	return func(e error) {
+		verr, ok := e.(*RefViolationError)
+		if !ok {
+			scope.Counter("violations").Inc(1)
+			logger.WithFields(log.NewErrField(e)).Error("checked ref violation")
+			return
+		}
+
+		scope.Tagged(map[string]string{
+			"site": originTag(verr.Site.Function),
+		}).Counter("violations").Inc(1)
+
+		fields := []log.Field{
+			log.NewErrField(verr.Err),
+			log.NewField("site", verr.Site.String()),
+			log.NewField("events", len(verr.Events)),
+		}
+		if n := len(verr.Events); n > 0 {
+			last := verr.Events[n-1]
+			fields = append(fields,
+				log.NewField("goroutine", last.GoroutineID),
+				log.NewField("caller", last.Caller.String()))
+		}
+		logger.WithFields(fields...).Error("checked ref violation")
+	}
+}
+
+type eventLog struct {
+	sync.Mutex
+	events    []Event
+	next      int
+	full      bool
+	finalized bool
+}
+
+func (l *eventLog) append(e Event) {
+	l.Lock()
+	if e.event == incRefEvent && e.Ref == 1 && l.finalized {
+		// The ref was finalized and is being reused, e.g. after being
+		// returned to a pool, so drop the events of the previous owner.
+		l.reset()
+	}
+	l.finalized = e.event == finalizeEvent && e.Ref == 0
+	if size := eventLogSize; len(l.events) != size {
+		// Defensive programming here in case someone changes
+		// the event log size during runtime
+		l.events = make([]Event, size)
+		l.next = 0
+		l.full = false
+	}
+	if len(l.events) > 0 {
+		l.events[l.next] = e
+		l.next = (l.next + 1) % len(l.events)
+		if l.next == 0 {
+			l.full = true
+		}
+	}
+	l.Unlock()
+}
+
+func (l *eventLog) reset() {
+	for i := range l.events {
+		l.events[i] = Event{}
+	}
+	l.next = 0
+	l.full = false
+}
+
+// snapshot returns the recorded events oldest first.
+func (l *eventLog) snapshot() []Event {
+	l.Lock()
+	var r []Event
+	if l.full {
+		r = append(r, l.events[l.next:]...)
+	}
+	r = append(r, l.events[:l.next]...)
+	l.Unlock()
+	return r
+}
+
+func recordEvent(c *RefCount, ref int, e debuggerEvent) {
+	var goroutine uint64
+	if eventLogGoroutinesFlag {
+		goroutine = goroutineID()
+	}
+
+	d := getDebuggerRef(c)
+	d.events.append(Event{
+		Type:        e.String(),
+		Ref:         ref,
+		GoroutineID: goroutine,
+		Caller:      callerFrame(),
+		Time:        time.Now(),
+		event:       e,
+	})
+}
+
+func newRefViolationError(c *RefCount, err error) *RefViolationError {
+	events := getDebuggerRef(c).events.snapshot()
+	if n := len(events); n > 0 {
+		// The violation is reported on the goroutine of the last event.
+		events[n-1].GoroutineID = goroutineID()
+	}
+	return &RefViolationError{
+		Err:    err,
+		Site:   violationSite(events),
+		Events: events,
+	}
+}
+
+func recordViolation(err *RefViolationError) {
+	key := err.Site.String()
+
+	violations.Lock()
+	v, ok := violations.m[key]
+	if !ok {
+		v = &ViolationReport{Site: err.Site}
+		violations.m[key] = v
+	}
+	v.Count++
+	v.Sample = err
+	violations.Unlock()
+}
+
+// violationSite returns the earliest acquire site without a matching
+// release, releases are matched to the most recent outstanding acquire
+// on the same goroutine if goroutines were captured, otherwise from the
+// same function, and failing that the most recent acquire.
+func violationSite(events []Event) Frame {
+	var acquired []int
+	for i, e := range events {
+		switch e.event {
+		case incRefEvent:
+			acquired = append(acquired, i)
+		case decRefEvent:
+			if len(acquired) == 0 {
+				continue
+			}
+			match := len(acquired) - 1
+			for j := len(acquired) - 1; j >= 0; j-- {
+				if sameAcquirer(events[acquired[j]], e) {
+					match = j
+					break
+				}
+			}
+			acquired = append(acquired[:match], acquired[match+1:]...)
+		}
+	}
+
+	if len(acquired) > 0 {
+		return events[acquired[0]].Caller
+	}
+	if len(events) > 0 {
+		return events[len(events)-1].Caller
+	}
+	return Frame{}
+}
+
+func sameAcquirer(acquire, release Event) bool {
+	if acquire.GoroutineID != 0 && release.GoroutineID != 0 {
+		return acquire.GoroutineID == release.GoroutineID
+	}
+	return acquire.Caller.Function == release.Caller.Function
+}
+
+// callerFrame returns the first call site outside of the checked package,
+// tests of the checked package itself are considered outside the package.
+func callerFrame() Frame {
+	return callerFrameOutside(pkgPrefix)
+}
+
+// trackerFrame returns the first call site outside of both the checked and
+// pool packages so that pooled objects are attributed to the pool's user.
+func trackerFrame() Frame {
+	return callerFrameOutside(pkgPrefix, poolPkgPrefix)
+}
+
+func callerFrameOutside(prefixes ...string) Frame {
+	var pc [eventCallersMaxDepth]uintptr
+	skipEntry := 4
+	n := runtime.Callers(skipEntry, pc[:])
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		if !hasAnyPrefix(frame.Function, prefixes) ||
+			strings.HasSuffix(frame.File, "_test.go") || !more {
+			return Frame{
+				Function: frame.Function,
+				File:     frame.File,
+				Line:     frame.Line,
+			}
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func goroutineID() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	b := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+func init() {
+	violations.m = make(map[string]*ViolationReport)
+}
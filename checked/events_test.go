@@ -0,0 +1,252 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package checked
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestEventLogRingBuffer(t *testing.T) {
+	SetEventLogSize(3)
+	defer SetEventLogSize(defaultEventLogSize)
+
+	var l eventLog
+	assert.Equal(t, 0, len(l.snapshot()))
+
+	for i := 0; i < 5; i++ {
+		l.append(Event{Ref: i})
+	}
+
+	events := l.snapshot()
+	require.Equal(t, 3, len(events))
+	for i, e := range events {
+		assert.Equal(t, i+2, e.Ref)
+	}
+}
+
+func TestEventLogReadAfterFree(t *testing.T) {
+	EnableEventLog()
+	defer DisableEventLog()
+	defer ResetViolations()
+
+	var err error
+	SetPanicFn(func(e error) {
+		err = e
+	})
+	defer ResetPanicFn()
+
+	elem := &struct {
+		RefCount
+	}{}
+
+	elem.IncRef()
+	elem.IncReads()
+	elem.DecReads()
+	elem.DecRef()
+	elem.Finalize()
+	elem.IncReads()
+
+	require.Error(t, err)
+	verr, ok := err.(*RefViolationError)
+	require.True(t, ok)
+	assert.Contains(t, verr.Error(), "read after free: reads=1, ref=0")
+
+	var types []string
+	for _, e := range verr.Events {
+		types = append(types, e.Type)
+		assert.Equal(t, "github.com/m3db/m3x/checked.TestEventLogReadAfterFree",
+			e.Caller.Function)
+	}
+	assert.Equal(t, []string{
+		"IncRef", "IncReads", "DecReads", "DecRef", "Finalize", "IncReads",
+	}, types)
+
+	// Only the violating event captures its goroutine.
+	last := verr.Events[len(verr.Events)-1]
+	assert.NotZero(t, last.GoroutineID)
+	assert.Zero(t, verr.Events[0].GoroutineID)
+
+	// Every acquire was released so the violation site is the read.
+	assert.Equal(t, last.Caller, verr.Site)
+
+	reports := DumpViolations()
+	require.Equal(t, 1, len(reports))
+	assert.Equal(t, 1, reports[0].Count)
+	assert.Equal(t, verr, reports[0].Sample)
+}
+
+func TestEventLogGroupsByUnmatchedAcquireSite(t *testing.T) {
+	EnableEventLog()
+	defer DisableEventLog()
+	defer ResetViolations()
+
+	SetPanicFn(func(e error) {})
+	defer ResetPanicFn()
+
+	var acquireSite Frame
+	for i := 0; i < 3; i++ {
+		elem := &struct {
+			RefCount
+		}{}
+
+		elem.IncRef()
+
+		// Acquire on another goroutine that is never released.
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			elem.IncRef()
+			wg.Done()
+		}()
+		wg.Wait()
+
+		elem.DecRef()
+		elem.Finalize()
+
+		events := getDebuggerRef(&elem.RefCount).events.snapshot()
+		acquireSite = events[1].Caller
+	}
+
+	reports := DumpViolations()
+	require.Equal(t, 1, len(reports))
+	assert.Equal(t, 3, reports[0].Count)
+	assert.Equal(t, acquireSite, reports[0].Site)
+	assert.Contains(t, reports[0].Sample.Error(), "finalize before zero ref count")
+
+	ResetViolations()
+	assert.Equal(t, 0, len(DumpViolations()))
+}
+
+func TestEventLogGoroutines(t *testing.T) {
+	EnableEventLog()
+	defer DisableEventLog()
+	EnableEventLogGoroutines()
+	defer DisableEventLogGoroutines()
+	defer ResetViolations()
+
+	var err error
+	SetPanicFn(func(e error) {
+		err = e
+	})
+	defer ResetPanicFn()
+
+	elem := &struct {
+		RefCount
+	}{}
+
+	elem.IncRef()
+
+	// Acquire on another goroutine that is never released.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		elem.IncRef()
+		wg.Done()
+	}()
+	wg.Wait()
+
+	// Release on this goroutine matches the acquire on this goroutine
+	// rather than the most recent acquire.
+	elem.DecRef()
+	elem.Finalize()
+
+	require.Error(t, err)
+	verr, ok := err.(*RefViolationError)
+	require.True(t, ok)
+	require.Equal(t, 4, len(verr.Events))
+	for _, e := range verr.Events {
+		assert.NotEqual(t, uint64(0), e.GoroutineID)
+	}
+	assert.Equal(t, verr.Events[0].GoroutineID, verr.Events[2].GoroutineID)
+	assert.NotEqual(t, verr.Events[0].GoroutineID, verr.Events[1].GoroutineID)
+	assert.Equal(t, verr.Events[1].Caller, verr.Site)
+}
+
+func TestEventLogClearedOnReuse(t *testing.T) {
+	EnableEventLog()
+	defer DisableEventLog()
+	defer ResetViolations()
+
+	var err error
+	SetPanicFn(func(e error) {
+		err = e
+	})
+	defer ResetPanicFn()
+
+	elem := &struct {
+		RefCount
+	}{}
+
+	// First owner.
+	elem.IncRef()
+	elem.IncRef()
+	elem.DecRef()
+	elem.DecRef()
+	elem.Finalize()
+
+	// Second owner reusing the ref after it was finalized.
+	elem.IncRef()
+	elem.DecRef()
+	elem.DecRef()
+
+	require.Error(t, err)
+	verr, ok := err.(*RefViolationError)
+	require.True(t, ok)
+
+	var types []string
+	for _, e := range verr.Events {
+		types = append(types, e.Type)
+	}
+	assert.Equal(t, []string{"IncRef", "DecRef", "DecRef"}, types)
+}
+
+func TestInstrumentedPanicFn(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	fn := NewInstrumentedPanicFn(instrument.NewOptions().
+		SetLogger(log.NullLogger).
+		SetMetricsScope(scope))
+
+	fn(errors.New("an error"))
+	fn(&RefViolationError{
+		Err:  errors.New("read after free"),
+		Site: Frame{Function: "github.com/m3db/m3x/foo.(*Foo).Bar"},
+		Events: []Event{
+			{Type: "IncReads", GoroutineID: 1},
+		},
+	})
+
+	counters := scope.Snapshot().Counters()
+	untagged, ok := counters["checked.violations+"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), untagged.Value())
+	tagged, ok := counters["checked.violations+site=foo.__Foo_.Bar"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), tagged.Value())
+}
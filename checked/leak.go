@@ -0,0 +1,203 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package checked
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	leaksPath = "/debug/checked/leaks"
+)
+
+// LeakOrigin is the leaks detected so far from a single origin.
+type LeakOrigin struct {
+	// Origin is the call site the leaked objects are attributed to, this is
+	// the unreleased acquire site if event logging is enabled and otherwise
+	// the first site outside of the pool package where the objects were
+	// tracked.
+	Origin string `json:"origin"`
+
+	// Function is the function of the call site the leaked objects are
+	// attributed to.
+	Function string `json:"function"`
+
+	// Bytes is the number of bytes leaked.
+	Bytes uint64 `json:"bytes"`
+
+	// Objects is the number of objects leaked.
+	Objects uint64 `json:"objects"`
+
+	// Traceback is the traceback of the most recent leaked object, only
+	// available if tracebacks are enabled.
+	Traceback string `json:"traceback,omitempty"`
+}
+
+// LeakOrigins returns all detected leaks so far grouped by origin with the
+// largest number of bytes leaked first.
+func LeakOrigins() []LeakOrigin {
+	r, _ := leakOrigins()
+	return r
+}
+
+// leakOrigins returns all detected leaks so far along with the number of
+// times the leaks have been reset.
+func leakOrigins() ([]LeakOrigin, uint64) {
+	leaks.RLock()
+	r := make([]LeakOrigin, 0, len(leaks.m))
+	for _, v := range leaks.m {
+		r = append(r, *v)
+	}
+	generation := leaks.generation
+	leaks.RUnlock()
+
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Bytes != r[j].Bytes {
+			return r[i].Bytes > r[j].Bytes
+		}
+		return r[i].Origin < r[j].Origin
+	})
+	return r, generation
+}
+
+// ResetLeaks resets all detected leaks so far.
+func ResetLeaks() {
+	leaks.Lock()
+	leaks.m = make(map[string]*LeakOrigin)
+	leaks.generation++
+	leaks.Unlock()
+}
+
+func recordLeak(site Frame, traceback string, size int) {
+	origin := site.String()
+	leaks.Lock()
+	v, ok := leaks.m[origin]
+	if !ok {
+		v = &LeakOrigin{Origin: origin, Function: site.Function}
+		leaks.m[origin] = v
+	}
+	// Keep track of bytes leaked as well as objects.
+	v.Bytes += uint64(size)
+	v.Objects++
+	if traceback != "" {
+		v.Traceback = traceback
+	}
+	leaks.Unlock()
+}
+
+type leakReporter struct {
+	sync.Mutex
+	instrument.Reporter
+
+	scope      tally.Scope
+	reported   map[string]LeakOrigin
+	generation uint64
+}
+
+// NewLeakReporter returns a new reporter that reports the leaks detected
+// so far as counters tagged by the function of their origin, the full
+// origins are available from the leaks handler.
+func NewLeakReporter(
+	scope tally.Scope,
+	reportInterval time.Duration,
+) instrument.Reporter {
+	r := &leakReporter{
+		scope:    scope.SubScope("checked"),
+		reported: make(map[string]LeakOrigin),
+	}
+	r.Reporter = instrument.NewReporter(reportInterval, r.report)
+	return r
+}
+
+func (r *leakReporter) report() {
+	r.Lock()
+	defer r.Unlock()
+
+	origins, generation := leakOrigins()
+	if generation != r.generation {
+		// Leaks were reset since the last report.
+		r.reported = make(map[string]LeakOrigin, len(origins))
+		r.generation = generation
+	}
+
+	for _, v := range origins {
+		prev := r.reported[v.Origin]
+		scope := r.scope.Tagged(map[string]string{"origin": originTag(v.Function)})
+		scope.Counter("leaked-bytes").Inc(int64(v.Bytes - prev.Bytes))
+		scope.Counter("leaked-objects").Inc(int64(v.Objects - prev.Objects))
+		r.reported[v.Origin] = v
+	}
+}
+
+// originTag returns a metric tag value for the function of an origin, it
+// drops the package path and replaces characters that metric backends
+// commonly reject.
+func originTag(function string) string {
+	if i := strings.LastIndexByte(function, '/'); i >= 0 {
+		function = function[i+1:]
+	}
+	if function == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9',
+			r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, function)
+}
+
+// RegisterLeaksHandler registers a handler with the given http mux that
+// responds with the leaks detected so far as JSON, a DELETE request resets
+// the leaks detected so far.
+func RegisterLeaksHandler(mux *http.ServeMux) {
+	mux.Handle(leaksPath, leaksHandler())
+}
+
+func leaksHandler() http.Handler {
+	h := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(LeakOrigins()); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+		case http.MethodDelete:
+			ResetLeaks()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+	return http.HandlerFunc(h)
+}
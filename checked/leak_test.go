@@ -0,0 +1,161 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package checked
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+var (
+	testFooSite = Frame{Function: "github.com/m3db/m3x/foo.Foo", File: "/src/foo/foo.go", Line: 10}
+	testBarSite = Frame{Function: "github.com/m3db/m3x/bar.(*Bar).Bar", File: "/src/bar/bar.go", Line: 20}
+)
+
+func TestLeakOriginsGroupedByOrigin(t *testing.T) {
+	ResetLeaks()
+	defer ResetLeaks()
+
+	recordLeak(testFooSite, "", 8)
+	recordLeak(testFooSite, "traceback", 8)
+	recordLeak(testBarSite, "", 32)
+
+	assert.Equal(t, []LeakOrigin{
+		{Origin: testBarSite.String(), Function: testBarSite.Function, Bytes: 32, Objects: 1},
+		{Origin: testFooSite.String(), Function: testFooSite.Function, Bytes: 16, Objects: 2, Traceback: "traceback"},
+	}, LeakOrigins())
+
+	ResetLeaks()
+	assert.Equal(t, 0, len(LeakOrigins()))
+}
+
+func TestLeakDetectionAttributedToAcquireSite(t *testing.T) {
+	EnableLeakDetection()
+	defer DisableLeakDetection()
+	EnableEventLog()
+	defer DisableEventLog()
+	ResetLeaks()
+	defer ResetLeaks()
+
+	leak := func() {
+		v := &RefCount{}
+		v.TrackObject(v)
+		v.IncRef()
+	}
+	leak()
+
+	runtime.GC()
+
+	var l []LeakOrigin
+	for ; len(l) == 0; l = LeakOrigins() {
+		// Finalizers are run in a separate goroutine, so we have to wait
+		// a little bit here.
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	require.Equal(t, 1, len(l))
+	assert.Contains(t, l[0].Origin,
+		"checked.TestLeakDetectionAttributedToAcquireSite.func1")
+	assert.Equal(t, uint64(1), l[0].Objects)
+}
+
+func TestTrackerFrameSkipsPoolPackage(t *testing.T) {
+	assert.Equal(t, "github.com/m3db/m3x/pool.", poolPkgPrefix)
+
+	prefixes := []string{pkgPrefix, poolPkgPrefix}
+	assert.True(t, hasAnyPrefix("github.com/m3db/m3x/checked.NewBytes", prefixes))
+	assert.True(t, hasAnyPrefix("github.com/m3db/m3x/pool.(*checkedBytesPool).Init.func1", prefixes))
+	assert.False(t, hasAnyPrefix("github.com/m3db/m3x/pooled.Foo", prefixes))
+	assert.False(t, hasAnyPrefix(testFooSite.Function, prefixes))
+}
+
+func TestLeakReporterReportsDeltas(t *testing.T) {
+	ResetLeaks()
+	defer ResetLeaks()
+
+	scope := tally.NewTestScope("", nil)
+	r := NewLeakReporter(scope, time.Second).(*leakReporter)
+
+	recordLeak(testFooSite, "", 8)
+	r.report()
+	recordLeak(testFooSite, "", 8)
+	r.report()
+
+	counters := scope.Snapshot().Counters()
+	leakedBytes, ok := counters["checked.leaked-bytes+origin=foo.Foo"]
+	require.True(t, ok)
+	assert.Equal(t, int64(16), leakedBytes.Value())
+	leakedObjects, ok := counters["checked.leaked-objects+origin=foo.Foo"]
+	require.True(t, ok)
+	assert.Equal(t, int64(2), leakedObjects.Value())
+
+	// Leaks reset and grown back to the same totals are still reported.
+	ResetLeaks()
+	recordLeak(testFooSite, "", 8)
+	recordLeak(testFooSite, "", 8)
+	r.report()
+
+	counters = scope.Snapshot().Counters()
+	assert.Equal(t, int64(32), counters["checked.leaked-bytes+origin=foo.Foo"].Value())
+	assert.Equal(t, int64(4), counters["checked.leaked-objects+origin=foo.Foo"].Value())
+}
+
+func TestOriginTag(t *testing.T) {
+	assert.Equal(t, "foo.Foo", originTag(testFooSite.Function))
+	assert.Equal(t, "bar.__Bar_.Bar", originTag(testBarSite.Function))
+	assert.Equal(t, "main.main.func1", originTag("main.main.func1"))
+	assert.Equal(t, "unknown", originTag(""))
+}
+
+func TestLeaksHandler(t *testing.T) {
+	ResetLeaks()
+	defer ResetLeaks()
+
+	recordLeak(testFooSite, "traceback", 8)
+
+	h := leaksHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, leaksPath, nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var origins []LeakOrigin
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &origins))
+	assert.Equal(t, []LeakOrigin{
+		{Origin: testFooSite.String(), Function: testFooSite.Function, Bytes: 8, Objects: 1, Traceback: "traceback"},
+	}, origins)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, leaksPath, nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, 0, len(LeakOrigins()))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, leaksPath, nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -174,16 +174,19 @@ func (c *RefCount) TrackObject(v interface{}) {
 		size = int(v.Type().Size())
 	}
 
+	site := trackerFrame()
 	runtime.SetFinalizer(c, func(c *RefCount) {
 		if c.NumRef() == 0 {
 			return
 		}
 
-		origin := getDebuggerRef(c).String()
+		d := getDebuggerRef(c)
+		if eventLogFlag {
+			// Attribute the leak to the acquire that was never released
+			// rather than where the object was tracked.
+			site = violationSite(d.events.snapshot())
+		}
 
-		leaks.Lock()
-		// Keep track of bytes leaked, not objects.
-		leaks.m[origin] += uint64(size)
-		leaks.Unlock()
+		recordLeak(site, d.String(), size)
 	})
 }
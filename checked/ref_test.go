@@ -212,6 +212,7 @@ func TestRefCountWriteFinishAfterFree(t *testing.T) {
 func TestLeakDetection(t *testing.T) {
 	EnableLeakDetection()
 	defer DisableLeakDetection()
+	defer ResetLeaks()
 
 	{
 		v := &RefCount{}
@@ -0,0 +1,241 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package context
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/m3db/m3x/log"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	// defaultBaggageCap is the number of baggage items stored inline with
+	// a context before setting further items allocates.
+	defaultBaggageCap = 4
+
+	// baggageScopeMaxScopes is the maximum number of tagged scopes cached
+	// by a baggage scope, scopes for further baggage values are not cached.
+	baggageScopeMaxScopes = 1024
+
+	// baggageScopeKeyCap is the length of the cache key for tagged scopes
+	// built without allocating.
+	baggageScopeKeyCap = 256
+
+	// unknownBaggageValue is the tag value for baggage keys not set on a
+	// context when deriving a tagged scope.
+	unknownBaggageValue = "unknown"
+)
+
+// BaggageKey is the key of a request scoped baggage item.
+type BaggageKey string
+
+const (
+	// BaggageKeyTenant is the baggage key for the tenant of a request.
+	BaggageKeyTenant BaggageKey = "tenant"
+
+	// BaggageKeyNamespace is the baggage key for the namespace of a request.
+	BaggageKeyNamespace BaggageKey = "namespace"
+
+	// BaggageKeyRequestID is the baggage key for the ID of a request.
+	BaggageKeyRequestID BaggageKey = "requestID"
+)
+
+// baggageItem is a baggage key and value, it implements log.Field so that
+// baggage can be passed to a logger without wrapping each item.
+type baggageItem struct {
+	key   BaggageKey
+	value string
+}
+
+func (i *baggageItem) Key() string        { return string(i.key) }
+func (i *baggageItem) Value() interface{} { return i.value }
+func (i *baggageItem) String() string     { return fmt.Sprintf("%v", *i) }
+
+func (c *ctx) SetBaggage(key BaggageKey, value string) {
+	c.Lock()
+	for i := range c.baggage {
+		if c.baggage[i].key == key {
+			c.baggage[i].value = value
+			c.baggageFieldsCache = nil
+			c.Unlock()
+			return
+		}
+	}
+	if c.baggage == nil {
+		c.baggage = c.baggageInline[:0]
+	}
+	c.baggage = append(c.baggage, baggageItem{key: key, value: value})
+	c.baggageFieldsCache = nil
+	c.Unlock()
+}
+
+func (c *ctx) Baggage(key BaggageKey) (string, bool) {
+	c.RLock()
+	for i := range c.baggage {
+		if c.baggage[i].key == key {
+			value := c.baggage[i].value
+			c.RUnlock()
+			return value, true
+		}
+	}
+	c.RUnlock()
+	return "", false
+}
+
+func (c *ctx) BaggageLogger(logger log.Logger) log.Logger {
+	fields := c.baggageFields()
+	if len(fields) == 0 {
+		return logger
+	}
+	return logger.WithFields(fields...)
+}
+
+// inheritBaggage copies the baggage of a parent to a new child context.
+func (c *ctx) inheritBaggage(parent *ctx) {
+	parent.RLock()
+	c.Lock()
+	c.resetBaggage()
+	if len(parent.baggage) > 0 {
+		c.baggage = append(c.baggageInline[:0], parent.baggage...)
+	}
+	c.Unlock()
+	parent.RUnlock()
+}
+
+// resetBaggage clears the baggage, the caller must hold the lock.
+func (c *ctx) resetBaggage() {
+	for i := range c.baggage {
+		c.baggage[i] = baggageItem{}
+	}
+	if c.baggage != nil {
+		c.baggage = c.baggage[:0]
+	}
+	c.baggageFieldsCache = nil
+}
+
+// baggageFieldsInline holds a copy of a small number of baggage items and
+// the log fields referring to them in a single allocation.
+type baggageFieldsInline struct {
+	items  [defaultBaggageCap]baggageItem
+	fields [defaultBaggageCap]log.Field
+}
+
+// baggageFields returns the baggage as log fields, copying the baggage so
+// that the fields remain valid after the context is reset. The fields are
+// cached until the baggage is next set.
+func (c *ctx) baggageFields() []log.Field {
+	c.Lock()
+	defer c.Unlock()
+
+	n := len(c.baggage)
+	if c.baggageFieldsCache != nil || n == 0 {
+		return c.baggageFieldsCache
+	}
+
+	var (
+		items  []baggageItem
+		fields []log.Field
+	)
+	if n <= defaultBaggageCap {
+		inline := &baggageFieldsInline{}
+		items, fields = inline.items[:n:n], inline.fields[:n:n]
+	} else {
+		items, fields = make([]baggageItem, n), make([]log.Field, n)
+	}
+	copy(items, c.baggage)
+	for i := range items {
+		fields[i] = &items[i]
+	}
+	c.baggageFieldsCache = fields
+	return fields
+}
+
+type baggageScope struct {
+	sync.RWMutex
+
+	scope  tally.Scope
+	keys   []BaggageKey
+	scopes map[string]tally.Scope
+}
+
+// NewBaggageScope returns a new BaggageScope that tags scopes derived
+// from the scope with the values of the baggage keys.
+func NewBaggageScope(scope tally.Scope, keys ...BaggageKey) BaggageScope {
+	return &baggageScope{
+		scope:  scope,
+		keys:   append([]BaggageKey(nil), keys...),
+		scopes: make(map[string]tally.Scope),
+	}
+}
+
+func (s *baggageScope) Scope(ctx Context) tally.Scope {
+	// Build the cache key on the stack for the common case of a few short
+	// values so that a cached scope is returned without allocating, each
+	// value is prefixed with its length so that values may contain any byte.
+	var (
+		keyBytes [baggageScopeKeyCap]byte
+		lenBytes [binary.MaxVarintLen64]byte
+		key      = keyBytes[:0]
+	)
+	for _, k := range s.keys {
+		value, ok := ctx.Baggage(k)
+		if !ok {
+			value = unknownBaggageValue
+		}
+		n := binary.PutUvarint(lenBytes[:], uint64(len(value)))
+		key = append(key, lenBytes[:n]...)
+		key = append(key, value...)
+	}
+
+	s.RLock()
+	scope, ok := s.scopes[string(key)]
+	s.RUnlock()
+	if ok {
+		return scope
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	cacheKey := string(key)
+	if scope, ok := s.scopes[cacheKey]; ok {
+		return scope
+	}
+
+	// Take the values from the key rather than the context in case the
+	// baggage has changed since the key was built.
+	tags := make(map[string]string, len(s.keys))
+	for _, k := range s.keys {
+		length, n := binary.Uvarint(key)
+		key = key[n:]
+		tags[string(k)] = string(key[:length])
+		key = key[length:]
+	}
+	scope = s.scope.Tagged(tags)
+	if len(s.scopes) < baggageScopeMaxScopes {
+		s.scopes[cacheKey] = scope
+	}
+	return scope
+}
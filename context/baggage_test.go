@@ -0,0 +1,297 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package context
+
+import (
+	"bytes"
+	stdctx "context"
+	"strconv"
+	"testing"
+
+	"github.com/m3db/m3x/log"
+	"github.com/m3db/m3x/pool"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestBaggage(t *testing.T) {
+	ctx := NewContext()
+
+	_, ok := ctx.Baggage(BaggageKeyTenant)
+	assert.False(t, ok)
+
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	ctx.SetBaggage(BaggageKeyNamespace, "bar")
+	ctx.SetBaggage(BaggageKeyTenant, "baz")
+
+	value, ok := ctx.Baggage(BaggageKeyTenant)
+	assert.True(t, ok)
+	assert.Equal(t, "baz", value)
+	value, ok = ctx.Baggage(BaggageKeyNamespace)
+	assert.True(t, ok)
+	assert.Equal(t, "bar", value)
+}
+
+func TestBaggageExceedsInlineCapacity(t *testing.T) {
+	var (
+		ctx  = NewContext()
+		keys = []BaggageKey{"a", "b", "c", "d", "e", "f"}
+	)
+
+	for _, k := range keys {
+		ctx.SetBaggage(k, string(k)+"-value")
+	}
+	for _, k := range keys {
+		value, ok := ctx.Baggage(k)
+		assert.True(t, ok)
+		assert.Equal(t, string(k)+"-value", value)
+	}
+}
+
+func TestBaggageReset(t *testing.T) {
+	ctx := NewContext()
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	ctx.BlockingClose()
+	ctx.Reset()
+
+	_, ok := ctx.Baggage(BaggageKeyTenant)
+	assert.False(t, ok)
+}
+
+func TestBaggageResetPooled(t *testing.T) {
+	var (
+		pool = NewPool(NewOptions().
+			SetContextPoolOptions(pool.NewObjectPoolOptions().SetSize(1)))
+		ctx = pool.Get()
+	)
+
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	ctx.BlockingClose()
+
+	ctx = pool.Get()
+	_, ok := ctx.Baggage(BaggageKeyTenant)
+	assert.False(t, ok)
+}
+
+func TestBaggageInheritedByChild(t *testing.T) {
+	xCtx := NewContext().(*ctx)
+	xCtx.SetBaggage(BaggageKeyTenant, "foo")
+
+	childCtx := xCtx.newChildContext()
+	value, ok := childCtx.Baggage(BaggageKeyTenant)
+	assert.True(t, ok)
+	assert.Equal(t, "foo", value)
+
+	childCtx.SetBaggage(BaggageKeyNamespace, "bar")
+	_, ok = xCtx.Baggage(BaggageKeyNamespace)
+	assert.False(t, ok)
+}
+
+func TestBaggageInheritedByTraceSpan(t *testing.T) {
+	var (
+		xCtx = NewContext()
+		mktr = mocktracer.New()
+		sp   = mktr.StartSpan("test_op")
+	)
+	defer sp.Finish()
+
+	xCtx.SetGoContext(opentracing.ContextWithSpan(stdctx.Background(), sp))
+	xCtx.SetBaggage(BaggageKeyRequestID, "1234")
+
+	spCtx, childSp, ok := xCtx.StartTraceSpan("test_op_2")
+	require.True(t, ok)
+	defer childSp.Finish()
+
+	value, ok := spCtx.Baggage(BaggageKeyRequestID)
+	assert.True(t, ok)
+	assert.Equal(t, "1234", value)
+}
+
+func TestBaggageLogger(t *testing.T) {
+	var (
+		buf bytes.Buffer
+		ctx = NewContext()
+	)
+
+	logger := ctx.BaggageLogger(log.NewLogger(&buf))
+	logger.Info("no baggage")
+	assert.Contains(t, buf.String(), "no baggage")
+	assert.NotContains(t, buf.String(), "tenant")
+
+	buf.Reset()
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	logger = ctx.BaggageLogger(log.NewLogger(&buf))
+	logger.WithFields(log.NewField("shard", 1)).Info("with baggage")
+	assert.Contains(t, buf.String(), "with baggage")
+	assert.Contains(t, buf.String(), "tenant")
+	assert.Contains(t, buf.String(), "foo")
+	assert.Contains(t, buf.String(), "shard")
+
+	fields := logger.Fields()
+	require.Equal(t, 1, fields.Len())
+	assert.Equal(t, "tenant", fields.ValueAt(0).Key())
+	assert.Equal(t, "foo", fields.ValueAt(0).Value())
+}
+
+func TestBaggageLoggerValidAfterClose(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		pool = NewPool(NewOptions())
+		ctx  = pool.Get()
+	)
+
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	logger := ctx.BaggageLogger(log.NewLogger(&buf))
+	ctx.BlockingClose()
+
+	// Reuse the pooled context with different baggage.
+	ctx = pool.Get()
+	ctx.SetBaggage(BaggageKeyTenant, "bar")
+	defer ctx.BlockingClose()
+
+	logger.Info("after close")
+	assert.Contains(t, buf.String(), "foo")
+	assert.NotContains(t, buf.String(), "bar")
+}
+
+func TestBaggageLoggerNoAllocationWithoutBaggage(t *testing.T) {
+	var (
+		pool   = NewPool(NewOptions())
+		logger = log.NullLogger
+	)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ctx := pool.Get()
+		ctx.BaggageLogger(logger)
+		ctx.BlockingClose()
+	})
+	assert.Equal(t, 0.0, allocs)
+}
+
+func TestBaggageLoggerNoAllocationWithBaggage(t *testing.T) {
+	keys := []BaggageKey{BaggageKeyTenant, BaggageKeyNamespace, BaggageKeyRequestID}
+	for n := 1; n <= len(keys); n++ {
+		var (
+			pool   = NewPool(NewOptions())
+			logger = log.NullLogger
+			ctx    = pool.Get()
+		)
+
+		for _, k := range keys[:n] {
+			ctx.SetBaggage(k, "foo")
+		}
+
+		// The fields are copied once when the baggage is set and then
+		// reused until the baggage next changes.
+		allocs := testing.AllocsPerRun(100, func() {
+			ctx.BaggageLogger(logger)
+		})
+		assert.Equal(t, 0.0, allocs, "keys=%d", n)
+
+		allocs = testing.AllocsPerRun(100, func() {
+			ctx.SetBaggage(keys[0], "bar")
+			ctx.BaggageLogger(logger)
+		})
+		assert.Equal(t, 1.0, allocs, "keys=%d", n)
+
+		ctx.BlockingClose()
+	}
+}
+
+func TestBaggageScope(t *testing.T) {
+	var (
+		scope        = tally.NewTestScope("", nil)
+		baggageScope = NewBaggageScope(scope, BaggageKeyTenant, BaggageKeyNamespace)
+		ctx          = NewContext()
+	)
+
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	ctx.SetBaggage(BaggageKeyRequestID, "1234")
+	baggageScope.Scope(ctx).Counter("requests").Inc(1)
+	baggageScope.Scope(ctx).Counter("requests").Inc(1)
+
+	ctx.SetBaggage(BaggageKeyNamespace, "bar")
+	baggageScope.Scope(ctx).Counter("requests").Inc(1)
+
+	counters := scope.Snapshot().Counters()
+	unknown, ok := counters["requests+namespace=unknown,tenant=foo"]
+	require.True(t, ok)
+	assert.Equal(t, int64(2), unknown.Value())
+	known, ok := counters["requests+namespace=bar,tenant=foo"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), known.Value())
+}
+
+func TestBaggageScopeValuesWithZeroBytes(t *testing.T) {
+	var (
+		scope        = tally.NewTestScope("", nil)
+		baggageScope = NewBaggageScope(scope, BaggageKeyTenant, BaggageKeyNamespace)
+		ctx          = NewContext()
+	)
+
+	ctx.SetBaggage(BaggageKeyTenant, "foo\x00bar")
+	baggageScope.Scope(ctx).Counter("requests").Inc(1)
+
+	// Values that would collide if joined by a zero byte.
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	ctx.SetBaggage(BaggageKeyNamespace, "bar\x00unknown")
+	baggageScope.Scope(ctx).Counter("requests").Inc(1)
+
+	counters := scope.Snapshot().Counters()
+	first, ok := counters["requests+namespace=unknown,tenant=foo\x00bar"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), first.Value())
+	second, ok := counters["requests+namespace=bar\x00unknown,tenant=foo"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), second.Value())
+}
+
+func TestBaggageScopeCacheBounded(t *testing.T) {
+	var (
+		baggageScope = NewBaggageScope(tally.NoopScope, BaggageKeyRequestID).(*baggageScope)
+		ctx          = NewContext()
+	)
+
+	for i := 0; i < 2*baggageScopeMaxScopes; i++ {
+		ctx.SetBaggage(BaggageKeyRequestID, strconv.Itoa(i))
+		require.NotNil(t, baggageScope.Scope(ctx))
+	}
+	assert.Equal(t, baggageScopeMaxScopes, len(baggageScope.scopes))
+}
+
+func TestBaggageScopeNoAllocation(t *testing.T) {
+	var (
+		baggageScope = NewBaggageScope(tally.NoopScope, BaggageKeyTenant)
+		ctx          = NewContext()
+	)
+
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	baggageScope.Scope(ctx)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		baggageScope.Scope(ctx)
+	})
+	assert.Equal(t, 0.0, allocs)
+}
@@ -23,7 +23,10 @@ package context
 import (
 	stdctx "context"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/m3db/m3x/log"
 	xopentracing "github.com/m3db/m3x/opentracing"
 	"github.com/m3db/m3x/resource"
 
@@ -37,10 +40,22 @@ type ctx struct {
 	sync.RWMutex
 
 	goCtx                stdctx.Context
+	cancellableGoCtx     stdctx.Context
+	cancel               stdctx.CancelFunc
 	pool                 contextPool
+	diag                 *diagnostics
 	done                 bool
 	wg                   sync.WaitGroup
+	pendingDependencies  int32
+	dependencies         []*dependency
 	finalizeables        []finalizeable
+	lastFinalizerID      uint64
+	generation           uint64
+	registered           int
+	dependedOn           bool
+	baggage              []baggageItem
+	baggageInline        [defaultBaggageCap]baggageItem
+	baggageFieldsCache   []log.Field
 	parent               Context
 	checkedAndNotSampled bool
 }
@@ -48,6 +63,10 @@ type ctx struct {
 type finalizeable struct {
 	finalizer resource.Finalizer
 	closer    resource.Closer
+	cancel    stdctx.CancelFunc
+	site      string
+	id        uint64
+	priority  FinalizerPriority
 }
 
 // NewContext creates a new context.
@@ -56,25 +75,136 @@ func NewContext() Context {
 }
 
 // NewPooledContext returns a new context that is returned to a pool when closed.
-func newPooledContext(pool contextPool) Context {
-	return &ctx{pool: pool}
+func newPooledContext(pool contextPool, diag *diagnostics) Context {
+	return &ctx{pool: pool, diag: diag}
 }
 
 // newContext returns an empty ctx
 func newContext() *ctx {
-	return &ctx{}
+	return &ctx{diag: defaultDiagnostics}
 }
 
 func (c *ctx) GoContext() (stdctx.Context, bool) {
-	if c.goCtx == nil {
+	c.RLock()
+	goCtx := c.goCtx
+	c.RUnlock()
+
+	if goCtx == nil {
 		return nil, false
 	}
 
-	return c.goCtx, true
+	return goCtx, true
 }
 
 func (c *ctx) SetGoContext(v stdctx.Context) {
+	c.Lock()
+	if c.cancel != nil {
+		// Release the Go std context derived from the previous one.
+		c.cancel()
+		c.cancellableGoCtx, c.cancel = nil, nil
+	}
 	c.goCtx = v
+	c.Unlock()
+}
+
+func (c *ctx) Done() <-chan struct{} {
+	return c.cancellableGoContext().Done()
+}
+
+func (c *ctx) Err() error {
+	return c.cancellableGoContext().Err()
+}
+
+func (c *ctx) WithCancel() (Context, stdctx.CancelFunc) {
+	goCtx, cancel := stdctx.WithCancel(c.cancellableGoContext())
+	return c.newCancellableChildContext(goCtx, cancel), cancel
+}
+
+func (c *ctx) WithDeadline(deadline time.Time) (Context, stdctx.CancelFunc) {
+	goCtx, cancel := stdctx.WithDeadline(c.cancellableGoContext(), deadline)
+	return c.newCancellableChildContext(goCtx, cancel), cancel
+}
+
+// cancellableGoContext returns the Go std context derived from the
+// context's Go std context that is cancelled when the context is closed,
+// deriving it on first use.
+func (c *ctx) cancellableGoContext() stdctx.Context {
+	c.Lock()
+	if c.cancel != nil {
+		goCtx := c.cancellableGoCtx
+		c.Unlock()
+		return goCtx
+	}
+
+	base, parent, done := c.goCtx, c.parent, c.done
+	if parent != nil && base == nil {
+		c.Unlock()
+		// Child contexts without a Go std context of their own share the
+		// one of their parent rather than registering a cancel with it.
+		return parent.(*ctx).cancellableGoContext()
+	}
+	if base == nil {
+		base = stdctx.Background()
+	}
+	// Keep the derived Go std context apart from the one set by the user
+	// so that GoContext only reports a Go std context if one was set.
+	goCtx, cancel := stdctx.WithCancel(base)
+	c.cancellableGoCtx, c.cancel = goCtx, cancel
+	c.Unlock()
+
+	switch {
+	case parent != nil:
+		// Child contexts are closed when their parent is closed.
+		root, id, ok := parent.(*ctx).registerCancel(cancel)
+		if !ok {
+			cancel()
+			break
+		}
+		c.Lock()
+		if c.cancellableGoCtx == goCtx {
+			// Deregister the cancel once the Go std context is replaced so
+			// that the parent does not accumulate stale cancels.
+			c.cancel = func() {
+				cancel()
+				root.deregisterCancel(id)
+			}
+			c.Unlock()
+			break
+		}
+		c.Unlock()
+		root.deregisterCancel(id)
+	case done:
+		cancel()
+	}
+
+	return goCtx
+}
+
+// registerCancel registers a cancel with the root context, returning the
+// root context and the identifier of the cancel.
+func (c *ctx) registerCancel(cancel stdctx.CancelFunc) (*ctx, uint64, bool) {
+	parent := c.parentCtx()
+	if parent != nil {
+		return parent.(*ctx).registerCancel(cancel)
+	}
+
+	id, ok := c.registerFinalizeable(finalizeable{cancel: cancel})
+	return c, id, ok
+}
+
+func (c *ctx) deregisterCancel(id uint64) {
+	c.DeregisterFinalizer(FinalizerHandle{owner: c, id: id})
+}
+
+func (c *ctx) newCancellableChildContext(
+	goCtx stdctx.Context,
+	cancel stdctx.CancelFunc,
+) Context {
+	child := c.newChildContext().(*ctx)
+	child.Lock()
+	child.goCtx, child.cancellableGoCtx, child.cancel = goCtx, goCtx, cancel
+	child.Unlock()
+	return child
 }
 
 func (c *ctx) IsClosed() bool {
@@ -97,7 +227,58 @@ func (c *ctx) RegisterFinalizer(f resource.Finalizer) {
 		return
 	}
 
-	c.registerFinalizeable(finalizeable{finalizer: f})
+	c.registerFinalizeable(finalizeable{finalizer: f, site: c.diag.callSite()})
+}
+
+func (c *ctx) RegisterFinalizerWithPriority(
+	f resource.Finalizer,
+	priority FinalizerPriority,
+) FinalizerHandle {
+	parent := c.parentCtx()
+	if parent != nil {
+		return parent.RegisterFinalizerWithPriority(f, priority)
+	}
+
+	id, ok := c.registerFinalizeable(finalizeable{
+		finalizer: f,
+		priority:  priority,
+		site:      c.diag.callSite(),
+	})
+	if !ok {
+		return FinalizerHandle{}
+	}
+	return FinalizerHandle{owner: c, id: id}
+}
+
+func (c *ctx) DeregisterFinalizer(h FinalizerHandle) bool {
+	parent := c.parentCtx()
+	if parent != nil {
+		return parent.DeregisterFinalizer(h)
+	}
+
+	if h.owner != c || h.id == 0 {
+		return false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.done {
+		return false
+	}
+
+	for i := range c.finalizeables {
+		if c.finalizeables[i].id != h.id {
+			continue
+		}
+		// Preserve the order of the remaining finalizers.
+		last := len(c.finalizeables) - 1
+		copy(c.finalizeables[i:], c.finalizeables[i+1:])
+		c.finalizeables[last] = finalizeable{}
+		c.finalizeables = c.finalizeables[:last]
+		return true
+	}
+	return false
 }
 
 func (c *ctx) RegisterCloser(f resource.Closer) {
@@ -107,28 +288,43 @@ func (c *ctx) RegisterCloser(f resource.Closer) {
 		return
 	}
 
-	c.registerFinalizeable(finalizeable{closer: f})
+	c.registerFinalizeable(finalizeable{closer: f, site: c.diag.callSite()})
 }
 
-func (c *ctx) registerFinalizeable(f finalizeable) {
+func (c *ctx) registerFinalizeable(f finalizeable) (uint64, bool) {
 	if c.Lock(); c.done {
 		c.Unlock()
-		return
+		return 0, false
 	}
 
-	if c.finalizeables != nil {
-		c.finalizeables = append(c.finalizeables, f)
-		c.Unlock()
-		return
+	// Identifiers are never reused across resets so that a stale handle
+	// cannot deregister a finalizer registered after the context is reused.
+	c.lastFinalizerID++
+	f.id = c.lastFinalizerID
+	if f.cancel == nil {
+		c.registered++
 	}
 
-	if c.pool != nil {
-		c.finalizeables = append(c.pool.getFinalizeables(), f)
-	} else {
-		c.finalizeables = append(allocateFinalizeables(), f)
+	if c.finalizeables == nil {
+		if c.pool != nil {
+			c.finalizeables = c.pool.getFinalizeables()
+		} else {
+			c.finalizeables = allocateFinalizeables()
+		}
+	}
+
+	// Keep finalizers ordered by priority, finalizers of equal priority
+	// run in registration order so appending is the common case.
+	c.finalizeables = append(c.finalizeables, f)
+	for i := len(c.finalizeables) - 1; i > 0; i-- {
+		if c.finalizeables[i-1].priority <= f.priority {
+			break
+		}
+		c.finalizeables[i], c.finalizeables[i-1] = c.finalizeables[i-1], c.finalizeables[i]
 	}
 
 	c.Unlock()
+	return f.id, true
 }
 
 func allocateFinalizeables() []finalizeable {
@@ -146,7 +342,15 @@ func (c *ctx) DependsOn(blocker Context) {
 
 	if !c.done {
 		c.wg.Add(1)
-		blocker.RegisterFinalizer(c)
+		c.dependedOn = true
+		atomic.AddInt32(&c.pendingDependencies, 1)
+		if c.diag.debugCallSites {
+			d := &dependency{ctx: c, site: c.diag.callSite()}
+			c.dependencies = append(c.dependencies, d)
+			blocker.RegisterFinalizer(d)
+		} else {
+			blocker.RegisterFinalizer(c)
+		}
 	}
 
 	c.Unlock()
@@ -154,6 +358,7 @@ func (c *ctx) DependsOn(blocker Context) {
 
 // Finalize handles a call from another context that was depended upon closing.
 func (c *ctx) Finalize() {
+	atomic.AddInt32(&c.pendingDependencies, -1)
 	c.wg.Done()
 }
 
@@ -197,8 +402,26 @@ func (c *ctx) close(mode closeMode) {
 	}
 
 	c.done = true
+	cancel := c.cancel
+	registered, unused := c.registered, c.registered == 0 && !c.dependedOn
 	c.Unlock()
 
+	if c.pool != nil {
+		c.pool.recordClose(registered, unused)
+	}
+
+	// Cancel derived Go std contexts immediately rather than after
+	// waiting for dependencies so that in flight work can stop early.
+	if cancel != nil {
+		cancel()
+	}
+	for i := range c.finalizeables {
+		if c.finalizeables[i].cancel != nil {
+			c.finalizeables[i].cancel()
+			c.finalizeables[i].cancel = nil
+		}
+	}
+
 	if c.finalizeables == nil {
 		c.returnToPool()
 		return
@@ -209,30 +432,29 @@ func (c *ctx) close(mode closeMode) {
 	f := c.finalizeables
 	c.finalizeables = nil
 
+	start := time.Now()
 	switch mode {
 	case closeAsync:
-		go c.finalize(f)
+		go c.finalize(f, start)
 	case closeBlock:
-		c.finalize(f)
+		c.finalize(f, start)
 	}
 }
 
-func (c *ctx) finalize(f []finalizeable) {
+func (c *ctx) finalize(f []finalizeable, start time.Time) {
 	// Wait for dependencies.
-	c.wg.Wait()
+	c.waitForDependencies()
 
 	// Now call finalizers.
 	for i := range f {
-		if f[i].finalizer != nil {
-			f[i].finalizer.Finalize()
-			f[i].finalizer = nil
-		}
-		if f[i].closer != nil {
-			f[i].closer.Close()
-			f[i].closer = nil
-		}
+		c.runFinalizeable(f[i])
+		f[i].finalizer = nil
+		f[i].closer = nil
 	}
 
+	c.diag.metrics.finalizers.Inc(int64(len(f)))
+	c.diag.metrics.finalizeLatency.Record(time.Since(start))
+
 	if c.pool != nil {
 		c.pool.putFinalizeables(f)
 	}
@@ -248,7 +470,13 @@ func (c *ctx) Reset() {
 	}
 
 	c.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
 	c.done, c.finalizeables, c.goCtx, c.checkedAndNotSampled = false, nil, nil, false
+	c.cancellableGoCtx, c.cancel, c.dependencies = nil, nil, nil
+	c.registered, c.dependedOn = 0, false
+	c.resetBaggage()
 	c.Unlock()
 }
 
@@ -264,10 +492,7 @@ func (c *ctx) returnToPool() {
 func (c *ctx) newChildContext() Context {
 	var childCtx *ctx
 	if c.pool != nil {
-		pooled, ok := c.pool.Get().(*ctx)
-		if ok {
-			childCtx = pooled
-		}
+		childCtx = c.pool.getContext()
 	}
 
 	if childCtx == nil {
@@ -275,12 +500,16 @@ func (c *ctx) newChildContext() Context {
 	}
 
 	childCtx.setParentCtx(c)
+	childCtx.inheritBaggage(c)
 	return childCtx
 }
 
 func (c *ctx) setParentCtx(parentCtx Context) {
 	c.Lock()
 	c.parent = parentCtx
+	// Pooled child contexts are not reset when returned to the pool
+	// since reset applies to the parent, clear any Go std context here.
+	c.goCtx, c.cancellableGoCtx, c.cancel = nil, nil, nil
 	c.Unlock()
 }
 
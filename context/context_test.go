@@ -21,17 +21,22 @@
 package context
 
 import (
+	"bytes"
 	stdctx "context"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
 	"github.com/m3db/m3x/resource"
 
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func TestRegisterFinalizerWithChild(t *testing.T) {
@@ -294,3 +299,401 @@ func TestGoContext(t *testing.T) {
 	assert.False(t, exists)
 	assert.Nil(t, returnCtx)
 }
+
+func TestDoneOnClose(t *testing.T) {
+	xCtx := NewContext()
+	assert.NoError(t, xCtx.Err())
+
+	select {
+	case <-xCtx.Done():
+		assert.FailNow(t, "context done before close")
+	default:
+	}
+
+	xCtx.Close()
+	<-xCtx.Done()
+	assert.Equal(t, stdctx.Canceled, xCtx.Err())
+}
+
+func TestDoneAfterClose(t *testing.T) {
+	xCtx := NewContext()
+	xCtx.Close()
+	<-xCtx.Done()
+	assert.Equal(t, stdctx.Canceled, xCtx.Err())
+}
+
+func TestDoneDoesNotSetGoContext(t *testing.T) {
+	xCtx := NewContext()
+	xCtx.Done()
+	assert.NoError(t, xCtx.Err())
+
+	goCtx, ok := xCtx.GoContext()
+	assert.False(t, ok)
+	assert.Nil(t, goCtx)
+
+	// Spans are only started on contexts with a Go std context set.
+	_, sp, ok := xCtx.StartTraceSpan("span")
+	assert.False(t, ok)
+	assert.Nil(t, sp)
+
+	xCtx.Close()
+	<-xCtx.Done()
+	goCtx, ok = xCtx.GoContext()
+	assert.False(t, ok)
+	assert.Nil(t, goCtx)
+}
+
+func TestDoneOnGoContextCancel(t *testing.T) {
+	goCtx, cancel := stdctx.WithCancel(stdctx.Background())
+	xCtx := NewContext()
+	xCtx.SetGoContext(goCtx)
+
+	cancel()
+	<-xCtx.Done()
+	assert.Equal(t, stdctx.Canceled, xCtx.Err())
+	assert.False(t, xCtx.IsClosed())
+}
+
+func TestWithCancel(t *testing.T) {
+	xCtx := NewContext()
+	child, cancel := xCtx.WithCancel()
+	assert.NotNil(t, child.(*ctx).parentCtx())
+
+	goCtx, ok := child.GoContext()
+	assert.True(t, ok)
+
+	cancel()
+	<-child.Done()
+	<-goCtx.Done()
+	assert.Equal(t, stdctx.Canceled, child.Err())
+
+	// Cancelling the child does not close the parent.
+	assert.False(t, xCtx.IsClosed())
+	assert.NoError(t, xCtx.Err())
+}
+
+func TestWithCancelCancelledOnParentClose(t *testing.T) {
+	xCtx := NewContext()
+	child, cancel := xCtx.WithCancel()
+	defer cancel()
+
+	// Derive a child from a span child to ensure nested children are
+	// cancelled too.
+	spanChild := xCtx.(*ctx).newChildContext()
+	spanChild.SetGoContext(stdctx.Background())
+	done := spanChild.Done()
+
+	xCtx.Close()
+	<-child.Done()
+	<-done
+	assert.Equal(t, stdctx.Canceled, child.Err())
+	assert.Equal(t, stdctx.Canceled, spanChild.Err())
+}
+
+func TestWithCancelDoesNotAccumulateFinalizeables(t *testing.T) {
+	xCtx := NewContext()
+	root := xCtx.(*ctx)
+	spanChild := root.newChildContext()
+
+	for i := 0; i < 100; i++ {
+		child, cancel := xCtx.WithCancel()
+		cancel()
+		<-child.Done()
+
+		spanCancelChild, spanCancel := spanChild.WithCancel()
+		spanCancelChild.Done()
+		spanCancel()
+
+		// Replacing a child's own Go std context releases its cancel.
+		spanChild.SetGoContext(stdctx.Background())
+		spanChild.Done()
+	}
+
+	root.RLock()
+	numFinalizeables := len(root.finalizeables)
+	root.RUnlock()
+	assert.True(t, numFinalizeables <= 1)
+
+	xCtx.Close()
+	<-spanChild.Done()
+	assert.Equal(t, stdctx.Canceled, spanChild.Err())
+}
+
+func TestWithDeadline(t *testing.T) {
+	xCtx := NewContext()
+	child, cancel := xCtx.WithDeadline(time.Now().Add(10 * time.Millisecond))
+	defer cancel()
+
+	goCtx, ok := child.GoContext()
+	assert.True(t, ok)
+	_, ok = goCtx.Deadline()
+	assert.True(t, ok)
+
+	<-child.Done()
+	assert.Equal(t, stdctx.DeadlineExceeded, child.Err())
+	assert.False(t, xCtx.IsClosed())
+}
+
+func TestWithCancelPooled(t *testing.T) {
+	pool := NewPool(NewOptions())
+	xCtx := pool.Get()
+	child, cancel := xCtx.WithCancel()
+	defer cancel()
+
+	goCtx, ok := child.GoContext()
+	assert.True(t, ok)
+
+	// Closing the child closes the parent which returns both to the pool.
+	child.Close()
+	<-goCtx.Done()
+	assert.Equal(t, stdctx.Canceled, goCtx.Err())
+}
+
+func TestFinalizerPanicRecovered(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		scope = tally.NewTestScope("", nil)
+		iopts = instrument.NewOptions().
+			SetLogger(log.NewLogger(&buf)).
+			SetMetricsScope(scope)
+		opts = NewOptions().
+			SetInstrumentOptions(iopts).
+			SetDebugCallSites(true)
+		ctx       = NewPool(opts).Get()
+		finalized = false
+	)
+
+	ctx.RegisterFinalizer(resource.FinalizerFn(func() {
+		panic("finalizer failed")
+	}))
+	ctx.RegisterFinalizer(resource.FinalizerFn(func() {
+		finalized = true
+	}))
+	ctx.BlockingClose()
+
+	assert.True(t, finalized)
+	assert.Contains(t, buf.String(), "context finalizer panic")
+	assert.Contains(t, buf.String(), "finalizer failed")
+	assert.Contains(t, buf.String(), "context_test.go")
+
+	counters := scope.Snapshot().Counters()
+	require.NotNil(t, counters["finalizer-panics+"])
+	assert.Equal(t, int64(1), counters["finalizer-panics+"].Value())
+	require.NotNil(t, counters["finalizers+"])
+	assert.Equal(t, int64(2), counters["finalizers+"].Value())
+	assert.NotNil(t, scope.Snapshot().Timers()["finalize-latency+"])
+}
+
+func TestFinalizeTimeoutLogsPendingDependencies(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		scope = tally.NewTestScope("", nil)
+		iopts = instrument.NewOptions().
+			SetLogger(log.NewLogger(&buf)).
+			SetMetricsScope(scope)
+		opts = NewOptions().
+			SetInstrumentOptions(iopts).
+			SetFinalizeTimeout(10 * time.Millisecond).
+			SetDebugCallSites(true)
+		ctx     = NewPool(opts).Get()
+		blocker = NewContext()
+		closed  = make(chan struct{})
+	)
+
+	ctx.DependsOn(blocker)
+	ctx.RegisterFinalizer(resource.FinalizerFn(func() {}))
+	go func() {
+		ctx.BlockingClose()
+		close(closed)
+	}()
+
+	blocked := func() bool {
+		c, ok := scope.Snapshot().Counters()["finalize-blocked+"]
+		return ok && c.Value() == 1
+	}
+	for !blocked() {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Contains(t, buf.String(), "context close blocked on dependencies")
+	assert.Contains(t, buf.String(), "context_test.go")
+
+	select {
+	case <-closed:
+		require.FailNow(t, "close completed before dependency closed")
+	default:
+	}
+
+	blocker.BlockingClose()
+	<-closed
+}
+
+func TestFinalizeTimeoutNotExceeded(t *testing.T) {
+	var (
+		scope = tally.NewTestScope("", nil)
+		opts  = NewOptions().
+			SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+			SetFinalizeTimeout(time.Minute)
+		ctx     = NewPool(opts).Get()
+		blocker = NewContext()
+	)
+
+	ctx.DependsOn(blocker)
+	ctx.RegisterFinalizer(resource.FinalizerFn(func() {}))
+	blocker.BlockingClose()
+	ctx.BlockingClose()
+
+	blocked, ok := scope.Snapshot().Counters()["finalize-blocked+"]
+	require.True(t, ok)
+	assert.Equal(t, int64(0), blocked.Value())
+}
+
+func TestRegisterFinalizerWithPriority(t *testing.T) {
+	var (
+		ctx   = NewContext()
+		order []string
+	)
+
+	appendFn := func(name string) resource.FinalizerFn {
+		return func() { order = append(order, name) }
+	}
+
+	ctx.RegisterFinalizerWithPriority(appendFn("buffers"), FinalizerPriorityBuffers)
+	ctx.RegisterFinalizer(appendFn("default-1"))
+	ctx.RegisterFinalizerWithPriority(appendFn("iterators-1"), FinalizerPriorityIterators)
+	ctx.RegisterCloser(resource.CloserFn(appendFn("default-2")))
+	ctx.RegisterFinalizerWithPriority(appendFn("iterators-2"), FinalizerPriorityIterators)
+	ctx.BlockingClose()
+
+	assert.Equal(t, []string{
+		"iterators-1", "iterators-2", "default-1", "default-2", "buffers",
+	}, order)
+}
+
+func TestRegisterFinalizerWithPriorityWithChild(t *testing.T) {
+	var (
+		xCtx     = NewContext().(*ctx)
+		childCtx = xCtx.newChildContext().(*ctx)
+		order    []string
+	)
+
+	childCtx.RegisterFinalizerWithPriority(resource.FinalizerFn(func() {
+		order = append(order, "buffers")
+	}), FinalizerPriorityBuffers)
+	h := childCtx.RegisterFinalizerWithPriority(resource.FinalizerFn(func() {
+		order = append(order, "iterators")
+	}), FinalizerPriorityIterators)
+
+	assert.Equal(t, 0, len(childCtx.finalizeables))
+	assert.Equal(t, 2, len(xCtx.finalizeables))
+	assert.True(t, h.owner == xCtx)
+
+	xCtx.BlockingClose()
+	assert.Equal(t, []string{"iterators", "buffers"}, order)
+}
+
+func TestDeregisterFinalizer(t *testing.T) {
+	var (
+		ctx   = NewContext().(*ctx)
+		order []string
+	)
+
+	appendFn := func(name string) resource.FinalizerFn {
+		return func() { order = append(order, name) }
+	}
+
+	ctx.RegisterFinalizer(appendFn("first"))
+	h := ctx.RegisterFinalizerWithPriority(appendFn("handed-off"), FinalizerPriorityDefault)
+	ctx.RegisterFinalizer(appendFn("last"))
+
+	assert.False(t, ctx.DeregisterFinalizer(FinalizerHandle{}))
+	assert.False(t, NewContext().DeregisterFinalizer(h))
+	assert.True(t, ctx.DeregisterFinalizer(h))
+	assert.False(t, ctx.DeregisterFinalizer(h))
+	assert.Equal(t, 2, len(ctx.finalizeables))
+
+	ctx.BlockingClose()
+	assert.Equal(t, []string{"first", "last"}, order)
+}
+
+func TestDeregisterFinalizerAfterReset(t *testing.T) {
+	ctx := NewContext()
+	h := ctx.RegisterFinalizerWithPriority(resource.FinalizerFn(func() {}),
+		FinalizerPriorityDefault)
+	ctx.BlockingClose()
+	assert.False(t, ctx.DeregisterFinalizer(h))
+
+	ctx.Reset()
+	finalized := false
+	ctx.RegisterFinalizer(resource.FinalizerFn(func() {
+		finalized = true
+	}))
+	assert.False(t, ctx.DeregisterFinalizer(h))
+
+	ctx.BlockingClose()
+	assert.True(t, finalized)
+}
+
+func TestRegisterFinalizerWithPriorityNoAdditionalAllocation(t *testing.T) {
+	var (
+		pool = NewPool(NewOptions())
+		f    = resource.FinalizerFn(func() {})
+	)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ctx := pool.Get()
+		ctx.RegisterFinalizer(f)
+		ctx.RegisterFinalizer(f)
+		ctx.BlockingClose()
+	})
+
+	prioritizedAllocs := testing.AllocsPerRun(100, func() {
+		ctx := pool.Get()
+		ctx.RegisterFinalizerWithPriority(f, FinalizerPriorityBuffers)
+		ctx.DeregisterFinalizer(
+			ctx.RegisterFinalizerWithPriority(f, FinalizerPriorityDefault))
+		ctx.RegisterFinalizerWithPriority(f, FinalizerPriorityIterators)
+		ctx.BlockingClose()
+	})
+	assert.Equal(t, allocs, prioritizedAllocs)
+}
+
+func BenchmarkRegisterFinalizer(b *testing.B) {
+	var (
+		pool = NewPool(NewOptions())
+		f    = resource.FinalizerFn(func() {})
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := pool.Get()
+		for j := 0; j < 4; j++ {
+			ctx.RegisterFinalizer(f)
+		}
+		ctx.BlockingClose()
+	}
+}
+
+func BenchmarkRegisterFinalizerWithPriority(b *testing.B) {
+	var (
+		pool       = NewPool(NewOptions())
+		f          = resource.FinalizerFn(func() {})
+		priorities = []FinalizerPriority{
+			FinalizerPriorityBuffers,
+			FinalizerPriorityDefault,
+			FinalizerPriorityIterators,
+			FinalizerPriorityDefault,
+		}
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := pool.Get()
+		for _, p := range priorities {
+			ctx.RegisterFinalizerWithPriority(f, p)
+		}
+		ctx.BlockingClose()
+	}
+}
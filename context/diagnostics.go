@@ -0,0 +1,180 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package context
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/m3db/m3x/log"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	callSiteMaxDepth = 16
+)
+
+var (
+	pkgPrefix          = reflect.TypeOf(ctx{}).PkgPath() + "."
+	defaultDiagnostics = newDiagnostics(NewOptions())
+)
+
+// diagnostics is shared by all contexts created from the same options.
+type diagnostics struct {
+	logger          log.Logger
+	finalizeTimeout time.Duration
+	debugCallSites  bool
+	metrics         diagnosticsMetrics
+}
+
+type diagnosticsMetrics struct {
+	finalizers      tally.Counter
+	finalizeLatency tally.Timer
+	finalizeBlocked tally.Counter
+	finalizerPanics tally.Counter
+}
+
+func newDiagnostics(opts Options) *diagnostics {
+	iopts := opts.InstrumentOptions()
+	scope := iopts.MetricsScope()
+	return &diagnostics{
+		logger:          iopts.Logger(),
+		finalizeTimeout: opts.FinalizeTimeout(),
+		debugCallSites:  opts.DebugCallSites(),
+		metrics: diagnosticsMetrics{
+			finalizers:      scope.Counter("finalizers"),
+			finalizeLatency: scope.Timer("finalize-latency"),
+			finalizeBlocked: scope.Counter("finalize-blocked"),
+			finalizerPanics: scope.Counter("finalizer-panics"),
+		},
+	}
+}
+
+// callSite returns the call site outside of the context package if call
+// sites are being recorded, tests of the context package itself are
+// considered outside the package.
+func (d *diagnostics) callSite() string {
+	if !d.debugCallSites {
+		return ""
+	}
+
+	var pc [callSiteMaxDepth]uintptr
+	skipEntry := 2
+	n := runtime.Callers(skipEntry, pc[:])
+	frames := runtime.CallersFrames(pc[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, pkgPrefix) ||
+			strings.HasSuffix(frame.File, "_test.go") || !more {
+			return fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+		}
+	}
+}
+
+// dependency is registered as a finalizer on a context depended upon when
+// call sites are being recorded so that blocked closes can be attributed.
+type dependency struct {
+	ctx  *ctx
+	site string
+	done int32
+}
+
+func (d *dependency) Finalize() {
+	atomic.StoreInt32(&d.done, 1)
+	d.ctx.Finalize()
+}
+
+// waitForDependencies waits for all dependencies to close, logging the
+// dependencies still pending if they do not close within the timeout.
+func (c *ctx) waitForDependencies() {
+	timeout := c.diag.finalizeTimeout
+	if timeout <= 0 || atomic.LoadInt32(&c.pendingDependencies) == 0 {
+		c.wg.Wait()
+		return
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(doneCh)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-doneCh:
+		return
+	case <-timer.C:
+	}
+
+	fields := []log.Field{
+		log.NewField("timeout", timeout.String()),
+		log.NewField("pendingDependencies", atomic.LoadInt32(&c.pendingDependencies)),
+	}
+	if c.diag.debugCallSites {
+		var origins []string
+		c.RLock()
+		for _, d := range c.dependencies {
+			if atomic.LoadInt32(&d.done) == 0 {
+				origins = append(origins, d.site)
+			}
+		}
+		c.RUnlock()
+		fields = append(fields, log.NewField("origins", origins))
+	}
+	c.diag.logger.WithFields(fields...).Warn("context close blocked on dependencies")
+	c.diag.metrics.finalizeBlocked.Inc(1)
+
+	<-doneCh
+}
+
+// runFinalizeable runs a finalizer or closer recovering from any panic so
+// that the remaining finalizers can still run.
+func (c *ctx) runFinalizeable(f finalizeable) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			buf = buf[:runtime.Stack(buf, false)]
+			fields := []log.Field{
+				log.NewField("panic", fmt.Sprintf("%v", r)),
+				log.NewField("stack", string(buf)),
+			}
+			if f.site != "" {
+				fields = append(fields, log.NewField("origin", f.site))
+			}
+			c.diag.logger.WithFields(fields...).Error("context finalizer panic")
+			c.diag.metrics.finalizerPanics.Inc(1)
+		}
+	}()
+
+	if f.finalizer != nil {
+		f.finalizer.Finalize()
+	}
+	if f.closer != nil {
+		f.closer.Close()
+	}
+}
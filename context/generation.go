@@ -0,0 +1,220 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package context
+
+import (
+	stdctx "context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/m3db/m3x/log"
+	"github.com/m3db/m3x/resource"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// staleDoneCh is returned from Done on a stale handle, a context that was
+// returned to the pool is done.
+var staleDoneCh = make(chan struct{})
+
+// generationCtx is a handle to a pooled context that detects use of the
+// context after it has been returned to the pool, the generation of a
+// pooled context is incremented each time it is returned to the pool.
+type generationCtx struct {
+	ctx        *ctx
+	pool       *poolOfContexts
+	generation uint64
+}
+
+func newGenerationCtx(c *ctx, pool *poolOfContexts) *generationCtx {
+	return &generationCtx{
+		ctx:        c,
+		pool:       pool,
+		generation: atomic.LoadUint64(&c.generation),
+	}
+}
+
+// check reports a use of the handle after the pooled context was returned
+// to the pool and returns whether the use is valid, stale uses must not be
+// forwarded to the context since it may be in use by another owner.
+func (h *generationCtx) check(op string) bool {
+	generation := atomic.LoadUint64(&h.ctx.generation)
+	if generation == h.generation {
+		return true
+	}
+
+	h.pool.metrics.staleUses.Inc(1)
+	h.pool.onStaleFn(fmt.Errorf(
+		"context used after returned to pool: op=%s, handle generation=%d, context generation=%d",
+		op, h.generation, generation))
+	return false
+}
+
+// unwrap returns the handle for the pooled context if the context
+// returned from an operation is the pooled context itself.
+func (h *generationCtx) unwrap(c Context) Context {
+	if c == h.ctx {
+		return h
+	}
+	return c
+}
+
+func (h *generationCtx) IsClosed() bool {
+	if !h.check("IsClosed") {
+		return true
+	}
+	return h.ctx.IsClosed()
+}
+
+func (h *generationCtx) RegisterFinalizer(f resource.Finalizer) {
+	if !h.check("RegisterFinalizer") {
+		return
+	}
+	h.ctx.RegisterFinalizer(f)
+}
+
+func (h *generationCtx) RegisterFinalizerWithPriority(
+	f resource.Finalizer,
+	priority FinalizerPriority,
+) FinalizerHandle {
+	if !h.check("RegisterFinalizerWithPriority") {
+		return FinalizerHandle{}
+	}
+	return h.ctx.RegisterFinalizerWithPriority(f, priority)
+}
+
+func (h *generationCtx) DeregisterFinalizer(fh FinalizerHandle) bool {
+	if !h.check("DeregisterFinalizer") {
+		return false
+	}
+	return h.ctx.DeregisterFinalizer(fh)
+}
+
+func (h *generationCtx) RegisterCloser(f resource.Closer) {
+	if !h.check("RegisterCloser") {
+		return
+	}
+	h.ctx.RegisterCloser(f)
+}
+
+func (h *generationCtx) DependsOn(blocker Context) {
+	if !h.check("DependsOn") {
+		return
+	}
+	h.ctx.DependsOn(blocker)
+}
+
+func (h *generationCtx) Close() {
+	if !h.check("Close") {
+		return
+	}
+	h.ctx.Close()
+}
+
+func (h *generationCtx) BlockingClose() {
+	if !h.check("BlockingClose") {
+		return
+	}
+	h.ctx.BlockingClose()
+}
+
+func (h *generationCtx) Reset() {
+	if !h.check("Reset") {
+		return
+	}
+	h.ctx.Reset()
+}
+
+func (h *generationCtx) GoContext() (stdctx.Context, bool) {
+	if !h.check("GoContext") {
+		return nil, false
+	}
+	return h.ctx.GoContext()
+}
+
+func (h *generationCtx) SetGoContext(v stdctx.Context) {
+	if !h.check("SetGoContext") {
+		return
+	}
+	h.ctx.SetGoContext(v)
+}
+
+func (h *generationCtx) Done() <-chan struct{} {
+	if !h.check("Done") {
+		return staleDoneCh
+	}
+	return h.ctx.Done()
+}
+
+func (h *generationCtx) Err() error {
+	if !h.check("Err") {
+		return stdctx.Canceled
+	}
+	return h.ctx.Err()
+}
+
+func (h *generationCtx) WithCancel() (Context, stdctx.CancelFunc) {
+	if !h.check("WithCancel") {
+		return h, func() {}
+	}
+	return h.ctx.WithCancel()
+}
+
+func (h *generationCtx) WithDeadline(deadline time.Time) (Context, stdctx.CancelFunc) {
+	if !h.check("WithDeadline") {
+		return h, func() {}
+	}
+	return h.ctx.WithDeadline(deadline)
+}
+
+func (h *generationCtx) StartTraceSpan(name string) (Context, opentracing.Span, bool) {
+	if !h.check("StartTraceSpan") {
+		return h, nil, false
+	}
+	c, sp, ok := h.ctx.StartTraceSpan(name)
+	return h.unwrap(c), sp, ok
+}
+
+func (h *generationCtx) SetBaggage(key BaggageKey, value string) {
+	if !h.check("SetBaggage") {
+		return
+	}
+	h.ctx.SetBaggage(key, value)
+}
+
+func (h *generationCtx) Baggage(key BaggageKey) (string, bool) {
+	if !h.check("Baggage") {
+		return "", false
+	}
+	return h.ctx.Baggage(key)
+}
+
+func (h *generationCtx) BaggageLogger(logger log.Logger) log.Logger {
+	if !h.check("BaggageLogger") {
+		return logger
+	}
+	return h.ctx.BaggageLogger(logger)
+}
+
+func init() {
+	close(staleDoneCh)
+}
@@ -20,7 +20,12 @@
 
 package context
 
-import "github.com/m3db/m3x/pool"
+import (
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/pool"
+)
 
 const (
 	defaultInitFinalizersCap = 4
@@ -32,6 +37,15 @@ type opts struct {
 	finalizerPoolOpts      pool.ObjectPoolOptions
 	maxPooledFinalizerCap  int
 	initPooledFinalizerCap int
+	instrumentOpts         instrument.Options
+	finalizeTimeout        time.Duration
+	debugCallSites         bool
+	detectStale            bool
+	onStaleFn              OnStaleContextFn
+}
+
+func defaultOnStaleContextFn(err error) {
+	panic(err)
 }
 
 // NewOptions returns a new Options object.
@@ -41,6 +55,8 @@ func NewOptions() Options {
 		finalizerPoolOpts:      pool.NewObjectPoolOptions(),
 		maxPooledFinalizerCap:  defaultMaxFinalizersCap,
 		initPooledFinalizerCap: defaultInitFinalizersCap,
+		instrumentOpts:         instrument.NewOptions(),
+		onStaleFn:              defaultOnStaleContextFn,
 	}
 }
 
@@ -83,3 +99,53 @@ func (o *opts) SetInitPooledFinalizerCapacity(init int) Options {
 func (o *opts) InitPooledFinalizerCapacity() int {
 	return o.initPooledFinalizerCap
 }
+
+func (o *opts) SetInstrumentOptions(value instrument.Options) Options {
+	opts := *o
+	opts.instrumentOpts = value
+	return &opts
+}
+
+func (o *opts) InstrumentOptions() instrument.Options {
+	return o.instrumentOpts
+}
+
+func (o *opts) SetFinalizeTimeout(value time.Duration) Options {
+	opts := *o
+	opts.finalizeTimeout = value
+	return &opts
+}
+
+func (o *opts) FinalizeTimeout() time.Duration {
+	return o.finalizeTimeout
+}
+
+func (o *opts) SetDebugCallSites(value bool) Options {
+	opts := *o
+	opts.debugCallSites = value
+	return &opts
+}
+
+func (o *opts) DebugCallSites() bool {
+	return o.debugCallSites
+}
+
+func (o *opts) SetStaleContextDetection(value bool) Options {
+	opts := *o
+	opts.detectStale = value
+	return &opts
+}
+
+func (o *opts) StaleContextDetection() bool {
+	return o.detectStale
+}
+
+func (o *opts) SetOnStaleContextFn(value OnStaleContextFn) Options {
+	opts := *o
+	opts.onStaleFn = value
+	return &opts
+}
+
+func (o *opts) OnStaleContextFn() OnStaleContextFn {
+	return o.onStaleFn
+}
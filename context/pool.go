@@ -21,12 +21,40 @@
 package context
 
 import (
+	"sync/atomic"
+
 	"github.com/m3db/m3x/pool"
+
+	"github.com/uber-go/tally"
 )
 
 type poolOfContexts struct {
 	ctxPool        pool.ObjectPool
 	finalizersPool finalizeablesArrayPool
+	detectStale    bool
+	onStaleFn      OnStaleContextFn
+	outstanding    int64
+	closed         int64
+	finalizers     int64
+	metrics        poolMetrics
+}
+
+type poolMetrics struct {
+	outstanding          tally.Gauge
+	closed               tally.Counter
+	closedUnused         tally.Counter
+	finalizersPerContext tally.Gauge
+	staleUses            tally.Counter
+}
+
+func newPoolMetrics(scope tally.Scope) poolMetrics {
+	return poolMetrics{
+		outstanding:          scope.Gauge("outstanding"),
+		closed:               scope.Counter("closed"),
+		closedUnused:         scope.Counter("closed-unused"),
+		finalizersPerContext: scope.Gauge("finalizers-per-context"),
+		staleUses:            scope.Counter("stale-uses"),
+	}
 }
 
 // NewPool creates a new context pool.
@@ -38,24 +66,53 @@ func NewPool(opts Options) Pool {
 			MaxCapacity: opts.MaxPooledFinalizerCapacity(),
 			Options:     opts.FinalizerPoolOptions(),
 		}),
+		detectStale: opts.StaleContextDetection(),
+		onStaleFn:   opts.OnStaleContextFn(),
+		metrics:     newPoolMetrics(opts.InstrumentOptions().MetricsScope()),
 	}
 
 	p.finalizersPool.Init()
+	diag := newDiagnostics(opts)
 	p.ctxPool.Init(func() interface{} {
-		return newPooledContext(p)
+		return newPooledContext(p, diag)
 	})
 
 	return p
 }
 
 func (p *poolOfContexts) Get() Context {
-	return p.ctxPool.Get().(Context)
+	c := p.getContext()
+	outstanding := atomic.AddInt64(&p.outstanding, 1)
+	p.metrics.outstanding.Update(float64(outstanding))
+	if !p.detectStale {
+		return c
+	}
+	return newGenerationCtx(c, p)
 }
 
 func (p *poolOfContexts) Put(context Context) {
+	if h, ok := context.(*generationCtx); ok {
+		context = h.ctx
+	}
+	if c, ok := context.(*ctx); ok {
+		// Invalidate any handles to the context before it can be reused.
+		atomic.AddUint64(&c.generation, 1)
+
+		// Only contexts obtained from Get are outstanding, child contexts
+		// are returned to the pool too but were never counted.
+		if c.parentCtx() == nil {
+			outstanding := atomic.AddInt64(&p.outstanding, -1)
+			p.metrics.outstanding.Update(float64(outstanding))
+		}
+	}
+
 	p.ctxPool.Put(context)
 }
 
+func (p *poolOfContexts) getContext() *ctx {
+	return p.ctxPool.Get().(*ctx)
+}
+
 func (p *poolOfContexts) getFinalizeables() []finalizeable {
 	return p.finalizersPool.Get()
 }
@@ -63,3 +120,13 @@ func (p *poolOfContexts) getFinalizeables() []finalizeable {
 func (p *poolOfContexts) putFinalizeables(finalizeables []finalizeable) {
 	p.finalizersPool.Put(finalizeables)
 }
+
+func (p *poolOfContexts) recordClose(finalizers int, unused bool) {
+	closed := atomic.AddInt64(&p.closed, 1)
+	total := atomic.AddInt64(&p.finalizers, int64(finalizers))
+	p.metrics.closed.Inc(1)
+	p.metrics.finalizersPerContext.Update(float64(total) / float64(closed))
+	if unused {
+		p.metrics.closedUnused.Inc(1)
+	}
+}
@@ -21,11 +21,16 @@
 package context
 
 import (
+	stdctx "context"
 	"testing"
 
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/pool"
 	"github.com/m3db/m3x/resource"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func TestContextPool(t *testing.T) {
@@ -40,3 +45,105 @@ func TestContextPool(t *testing.T) {
 	ctx.BlockingClose()
 	assert.True(t, finalizeCalled)
 }
+
+func TestContextPoolStaleContextPanics(t *testing.T) {
+	opts := NewOptions().
+		SetContextPoolOptions(pool.NewObjectPoolOptions().SetSize(1)).
+		SetStaleContextDetection(true)
+	pool := NewPool(opts)
+
+	ctx := pool.Get()
+	ctx.BlockingClose()
+
+	assert.Panics(t, func() {
+		ctx.RegisterFinalizer(resource.FinalizerFn(func() {}))
+	})
+}
+
+func TestContextPoolStaleContextReported(t *testing.T) {
+	var (
+		errs  []error
+		scope = tally.NewTestScope("", nil)
+		opts  = NewOptions().
+			SetContextPoolOptions(pool.NewObjectPoolOptions().SetSize(1)).
+			SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+			SetStaleContextDetection(true).
+			SetOnStaleContextFn(func(err error) {
+				errs = append(errs, err)
+			})
+		pool = NewPool(opts)
+	)
+
+	stale := pool.Get()
+	stale.BlockingClose()
+
+	ctx := pool.Get()
+	ctx.SetBaggage(BaggageKeyTenant, "foo")
+	assert.Equal(t, 0, len(errs))
+
+	stale.SetBaggage(BaggageKeyTenant, "bar")
+	require.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "op=SetBaggage")
+
+	// Stale uses are not forwarded to the reused context.
+	value, ok := ctx.Baggage(BaggageKeyTenant)
+	assert.True(t, ok)
+	assert.Equal(t, "foo", value)
+	_, ok = stale.Baggage(BaggageKeyTenant)
+	assert.False(t, ok)
+	assert.True(t, stale.IsClosed())
+	<-stale.Done()
+	assert.Equal(t, stdctx.Canceled, stale.Err())
+	stale.Close()
+	assert.False(t, ctx.IsClosed())
+
+	staleUses, ok := scope.Snapshot().Counters()["stale-uses+"]
+	require.True(t, ok)
+	assert.Equal(t, int64(6), staleUses.Value())
+}
+
+func TestContextPoolMetrics(t *testing.T) {
+	var (
+		scope = tally.NewTestScope("", nil)
+		opts  = NewOptions().
+			SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+		pool = NewPool(opts)
+		f    = resource.FinalizerFn(func() {})
+	)
+
+	ctxs := []Context{pool.Get(), pool.Get(), pool.Get()}
+	ctxs[0].RegisterFinalizer(f)
+	ctxs[0].RegisterFinalizer(f)
+	ctxs[1].RegisterCloser(resource.CloserFn(func() {}))
+
+	snapshot := scope.Snapshot()
+	assert.Equal(t, 3.0, snapshot.Gauges()["outstanding+"].Value())
+
+	for _, ctx := range ctxs {
+		ctx.BlockingClose()
+	}
+
+	snapshot = scope.Snapshot()
+	assert.Equal(t, 0.0, snapshot.Gauges()["outstanding+"].Value())
+	assert.Equal(t, 1.0, snapshot.Gauges()["finalizers-per-context+"].Value())
+	assert.Equal(t, int64(3), snapshot.Counters()["closed+"].Value())
+	assert.Equal(t, int64(1), snapshot.Counters()["closed-unused+"].Value())
+}
+
+func TestContextPoolOutstandingExcludesChildren(t *testing.T) {
+	var (
+		scope = tally.NewTestScope("", nil)
+		opts  = NewOptions().
+			SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+		pool = NewPool(opts)
+	)
+
+	ctx := pool.Get()
+	child, cancel := ctx.WithCancel()
+	defer cancel()
+	assert.Equal(t, 1.0, scope.Snapshot().Gauges()["outstanding+"].Value())
+
+	// Closing the child closes the parent and returns both to the pool.
+	child.BlockingClose()
+	assert.Equal(t, 0.0, scope.Snapshot().Gauges()["outstanding+"].Value())
+}
@@ -22,11 +22,15 @@ package context
 
 import (
 	stdctx "context"
+	"time"
 
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
 	"github.com/m3db/m3x/pool"
 	"github.com/m3db/m3x/resource"
 
 	"github.com/opentracing/opentracing-go"
+	"github.com/uber-go/tally"
 )
 
 // Cancellable is an object that can be cancelled
@@ -41,6 +45,31 @@ type Cancellable interface {
 	Reset()
 }
 
+// FinalizerPriority is the priority of a finalizer, finalizers with a
+// lower priority are called before those with a higher priority.
+type FinalizerPriority int
+
+const (
+	// FinalizerPriorityIterators is the priority for finalizers of
+	// resources that reference pooled buffers, such as iterators.
+	FinalizerPriorityIterators FinalizerPriority = -100
+
+	// FinalizerPriorityDefault is the priority for finalizers and closers
+	// registered without a priority.
+	FinalizerPriorityDefault FinalizerPriority = 0
+
+	// FinalizerPriorityBuffers is the priority for finalizers of pooled
+	// buffers that may be referenced by other resources.
+	FinalizerPriorityBuffers FinalizerPriority = 100
+)
+
+// FinalizerHandle is a handle to a registered finalizer used to
+// deregister it, the zero value is not registered with any context.
+type FinalizerHandle struct {
+	owner *ctx
+	id    uint64
+}
+
 // Context provides context to an operation.
 type Context interface {
 	// IsClosed returns whether the context is closed.
@@ -49,6 +78,19 @@ type Context interface {
 	// RegisterFinalizer will register a resource finalizer.
 	RegisterFinalizer(resource.Finalizer)
 
+	// RegisterFinalizerWithPriority will register a resource finalizer
+	// that is called in order of priority, lowest first, with finalizers
+	// of equal priority called in registration order. Finalizers and
+	// closers registered without a priority have the default priority.
+	RegisterFinalizerWithPriority(
+		f resource.Finalizer,
+		priority FinalizerPriority,
+	) FinalizerHandle
+
+	// DeregisterFinalizer will deregister a resource finalizer that has
+	// been handed off to another owner, returning whether it was found.
+	DeregisterFinalizer(FinalizerHandle) bool
+
 	// RegisterCloser will register a resource closer.
 	RegisterCloser(resource.Closer)
 
@@ -74,9 +116,48 @@ type Context interface {
 	// SetGoContext sets the Go std context
 	SetGoContext(stdctx.Context)
 
+	// Done returns a channel that is closed when either the context is
+	// closed or the Go std context is done.
+	Done() <-chan struct{}
+
+	// Err returns nil until Done is closed, after which it returns the
+	// reason the Go std context is done or stdctx.Canceled if the context
+	// was closed.
+	Err() error
+
+	// WithCancel returns a child ctx with a Go std context derived from
+	// this context that is cancelled when either the cancel func is called
+	// or this context is closed.
+	WithCancel() (Context, stdctx.CancelFunc)
+
+	// WithDeadline returns a child ctx with a Go std context derived from
+	// this context that is cancelled when either the deadline expires, the
+	// cancel func is called or this context is closed.
+	WithDeadline(deadline time.Time) (Context, stdctx.CancelFunc)
+
 	// StartTraceSpan starts a new span and returns a child ctx
 	// if the span is being sampled.
 	StartTraceSpan(name string) (Context, opentracing.Span, bool)
+
+	// SetBaggage sets a request scoped baggage item, baggage is cleared
+	// when the context is reset and inherited by child contexts. Baggage
+	// set on a child context is not visible to its parent.
+	SetBaggage(key BaggageKey, value string)
+
+	// Baggage returns the value of a baggage item and whether it is set.
+	Baggage(key BaggageKey) (string, bool)
+
+	// BaggageLogger returns a logger that logs the baggage of the context
+	// at the time of the call as fields, the logger remains valid once the
+	// context is closed.
+	BaggageLogger(logger log.Logger) log.Logger
+}
+
+// BaggageScope derives scopes tagged with the baggage of a context.
+type BaggageScope interface {
+	// Scope returns a scope tagged with the baggage of the context, keys
+	// not set on the context are tagged with the value "unknown".
+	Scope(ctx Context) tally.Scope
 }
 
 // Pool provides a pool for contexts.
@@ -88,6 +169,10 @@ type Pool interface {
 	Put(Context)
 }
 
+// OnStaleContextFn is a function to call when a context is used after it
+// has been returned to the pool, the operation is applied after it returns.
+type OnStaleContextFn func(err error)
+
 // Options controls knobs for context pooling.
 type Options interface {
 	// SetContextPoolOptions sets the context pool options.
@@ -117,6 +202,47 @@ type Options interface {
 	// InitPooledFinalizerCapacity return the capacity finalizers are
 	// initialized to.
 	InitPooledFinalizerCapacity() int
+
+	// SetInstrumentOptions sets the instrument options used to log
+	// and report metrics on finalization.
+	SetInstrumentOptions(value instrument.Options) Options
+
+	// InstrumentOptions returns the instrument options used to log
+	// and report metrics on finalization.
+	InstrumentOptions() instrument.Options
+
+	// SetFinalizeTimeout sets the duration a close waits on dependencies
+	// before logging the dependencies still pending, zero disables it.
+	SetFinalizeTimeout(value time.Duration) Options
+
+	// FinalizeTimeout returns the duration a close waits on dependencies
+	// before logging the dependencies still pending, zero disables it.
+	FinalizeTimeout() time.Duration
+
+	// SetDebugCallSites sets whether the call sites registering finalizers
+	// and dependencies are recorded to attribute panics and blocked closes.
+	SetDebugCallSites(value bool) Options
+
+	// DebugCallSites returns whether the call sites registering finalizers
+	// and dependencies are recorded to attribute panics and blocked closes.
+	DebugCallSites() bool
+
+	// SetStaleContextDetection sets whether pooled contexts are returned as
+	// handles that detect use after the context is returned to the pool,
+	// this allocates a handle for each context taken from the pool.
+	SetStaleContextDetection(value bool) Options
+
+	// StaleContextDetection returns whether pooled contexts are returned as
+	// handles that detect use after the context is returned to the pool.
+	StaleContextDetection() bool
+
+	// SetOnStaleContextFn sets the callback for use of a stale context, by
+	// default this will panic.
+	SetOnStaleContextFn(value OnStaleContextFn) Options
+
+	// OnStaleContextFn returns the callback for use of a stale context, by
+	// default this will panic.
+	OnStaleContextFn() OnStaleContextFn
 }
 
 // contextPool is the internal pool interface for contexts.
@@ -128,4 +254,12 @@ type contextPool interface {
 
 	// putFinalizeables returns the finalizers to pool.
 	putFinalizeables([]finalizeable)
+
+	// getContext provides a context from the pool without a handle
+	// to detect use after it is returned to the pool.
+	getContext() *ctx
+
+	// recordClose records a pooled context closing with the number of
+	// finalizers and closers registered and whether it was used at all.
+	recordClose(finalizers int, unused bool)
 }
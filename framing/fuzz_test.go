@@ -0,0 +1,168 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+const fuzzMaxFrameSize = 1024
+
+func TestReaderFuzzCorruptAndOversizedFrames(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 2000
+	props := gopter.NewProperties(parameters)
+
+	genHeaderType := gen.OneConstOf(VarintHeader, FixedHeader)
+
+	props.Property("reading arbitrary bytes never panics or exceeds the max frame size", prop.ForAll(
+		func(data []byte, headerType HeaderType, checksum bool) bool {
+			opts := fuzzOptions(headerType, checksum)
+			_, ok := readAllWithoutPanic(data, opts)
+			return ok
+		},
+		gen.SliceOf(gen.UInt8()),
+		genHeaderType,
+		gen.Bool(),
+	))
+
+	props.Property("written frames round trip", prop.ForAll(
+		func(frames [][]byte, headerType HeaderType, checksum bool) bool {
+			opts := fuzzOptions(headerType, checksum)
+			data := writeFuzzFrames(frames, opts)
+			read, ok := readAllWithoutPanic(data, opts)
+			if !ok || len(read) != len(frames) {
+				return false
+			}
+			for i := range frames {
+				if !bytes.Equal(frames[i], read[i]) {
+					return false
+				}
+			}
+			return true
+		},
+		gen.SliceOfN(4, gen.SliceOf(gen.UInt8())),
+		genHeaderType,
+		gen.Bool(),
+	))
+
+	props.Property("corrupted payloads fail the checksum", prop.ForAll(
+		func(frame []byte, headerType HeaderType, idx int, bit uint) bool {
+			if len(frame) == 0 {
+				return true
+			}
+			opts := fuzzOptions(headerType, true)
+			data := writeFuzzFrames([][]byte{frame}, opts)
+			// Flip a single bit of the payload, which CRC32 always detects.
+			payloadStart := len(data) - checksumLen - len(frame)
+			data[payloadStart+idx%len(frame)] ^= 1 << (bit % 8)
+			_, err := NewReader(bytes.NewReader(data), opts).Read()
+			return err == ErrChecksumMismatch
+		},
+		gen.SliceOf(gen.UInt8()),
+		genHeaderType,
+		gen.IntRange(0, 1<<16),
+		gen.UIntRange(0, 7),
+	))
+
+	props.Property("oversized frames are rejected", prop.ForAll(
+		func(size int, headerType HeaderType, checksum bool) bool {
+			writeOpts := fuzzOptions(headerType, checksum).SetMaxFrameSize(size)
+			data := writeFuzzFrames([][]byte{make([]byte, size)}, writeOpts)
+			_, err := NewReader(bytes.NewReader(data), fuzzOptions(headerType, checksum)).Read()
+			return err == ErrFrameTooLarge
+		},
+		gen.IntRange(fuzzMaxFrameSize+1, 4*fuzzMaxFrameSize),
+		genHeaderType,
+		gen.Bool(),
+	))
+
+	props.Property("truncated frames fail to read", prop.ForAll(
+		func(frame []byte, headerType HeaderType, checksum bool, truncate int) bool {
+			opts := fuzzOptions(headerType, checksum)
+			data := writeFuzzFrames([][]byte{frame}, opts)
+			data = data[:truncate%len(data)]
+			_, err := NewReader(bytes.NewReader(data), opts).Read()
+			if len(data) == 0 {
+				return err == io.EOF
+			}
+			return err == io.ErrUnexpectedEOF
+		},
+		gen.SliceOf(gen.UInt8()),
+		genHeaderType,
+		gen.Bool(),
+		gen.IntRange(0, 1<<16),
+	))
+
+	reporter := gopter.NewFormatedReporter(true, 160, os.Stdout)
+	if !props.Run(reporter) {
+		t.Errorf("failed with initial seed: %d", parameters.Seed())
+	}
+}
+
+func fuzzOptions(headerType HeaderType, checksum bool) Options {
+	return NewOptions().
+		SetHeaderType(headerType).
+		SetChecksumEnabled(checksum).
+		SetMaxFrameSize(fuzzMaxFrameSize)
+}
+
+func writeFuzzFrames(frames [][]byte, opts Options) []byte {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, opts.SetFlushSize(fuzzMaxFrameSize))
+	for _, frame := range frames {
+		if err := w.Write(frame); err != nil {
+			return nil
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func readAllWithoutPanic(data []byte, opts Options) (frames [][]byte, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	r := NewReader(bytes.NewReader(data), opts)
+	defer r.Close()
+	for {
+		frame, err := r.Read()
+		if err != nil {
+			return frames, true
+		}
+		if len(frame) > opts.MaxFrameSize() {
+			return frames, false
+		}
+		frames = append(frames, append([]byte(nil), frame...))
+	}
+}
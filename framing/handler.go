@@ -0,0 +1,138 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"io"
+	"net"
+
+	"github.com/m3db/m3x/log"
+	"github.com/m3db/m3x/server"
+
+	"github.com/uber-go/tally"
+)
+
+type handlerMetrics struct {
+	framesRead       tally.Counter
+	bytesRead        tally.Counter
+	frameTooLarge    tally.Counter
+	checksumMismatch tally.Counter
+	invalidHeader    tally.Counter
+	unexpectedEOF    tally.Counter
+	handleErrors     tally.Counter
+	writeErrors      tally.Counter
+}
+
+func newHandlerMetrics(scope tally.Scope) handlerMetrics {
+	readErrorCounter := func(errType string) tally.Counter {
+		return scope.Tagged(map[string]string{"error-type": errType}).Counter("frame-read-errors")
+	}
+	return handlerMetrics{
+		framesRead:       scope.Counter("frames-read"),
+		bytesRead:        scope.Counter("frame-bytes-read"),
+		frameTooLarge:    readErrorCounter("frame-too-large"),
+		checksumMismatch: readErrorCounter("checksum-mismatch"),
+		invalidHeader:    readErrorCounter("invalid-header"),
+		unexpectedEOF:    readErrorCounter("unexpected-eof"),
+		handleErrors:     scope.Counter("frame-handle-errors"),
+		writeErrors:      scope.Counter("frame-write-errors"),
+	}
+}
+
+type framedHandler struct {
+	handler FrameHandler
+	opts    Options
+	log     log.Logger
+	metrics handlerMetrics
+}
+
+// NewFramedHandler creates a server handler that reads frames from each
+// connection and passes them to the frame handler, the connection is
+// closed once the stream ends or a frame is invalid.
+func NewFramedHandler(handler FrameHandler, opts Options) server.Handler {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	instrumentOpts := opts.InstrumentOptions()
+	return &framedHandler{
+		handler: handler,
+		opts:    opts,
+		log:     instrumentOpts.Logger(),
+		metrics: newHandlerMetrics(instrumentOpts.MetricsScope()),
+	}
+}
+
+func (h *framedHandler) Handle(conn net.Conn) {
+	reader := NewReader(conn, h.opts)
+	writer := NewWriter(conn, h.opts)
+	defer func() {
+		if err := writer.Close(); err != nil {
+			h.metrics.writeErrors.Inc(1)
+		}
+		reader.Close()
+	}()
+
+	for {
+		frame, err := reader.Read()
+		if err != nil {
+			h.readError(conn, err)
+			return
+		}
+		h.metrics.framesRead.Inc(1)
+		h.metrics.bytesRead.Inc(int64(len(frame)))
+
+		if err := h.handler.HandleFrame(frame, writer); err != nil {
+			h.metrics.handleErrors.Inc(1)
+			h.log.WithFields(
+				log.NewField("remoteAddress", conn.RemoteAddr().String()),
+				log.NewErrField(err),
+			).Error("could not handle frame")
+			return
+		}
+	}
+}
+
+func (h *framedHandler) readError(conn net.Conn, err error) {
+	var counter tally.Counter
+	switch err {
+	case ErrFrameTooLarge:
+		counter = h.metrics.frameTooLarge
+	case ErrChecksumMismatch:
+		counter = h.metrics.checksumMismatch
+	case ErrInvalidHeader:
+		counter = h.metrics.invalidHeader
+	case io.ErrUnexpectedEOF:
+		counter = h.metrics.unexpectedEOF
+	default:
+		// NB: the connection was closed or timed out between frames, which
+		// is how connections normally end.
+		return
+	}
+	counter.Inc(1)
+	h.log.WithFields(
+		log.NewField("remoteAddress", conn.RemoteAddr().String()),
+		log.NewErrField(err),
+	).Error("could not read frame")
+}
+
+func (h *framedHandler) Close() {
+	h.handler.Close()
+}
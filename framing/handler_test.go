@@ -0,0 +1,153 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/server"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+const testListenAddress = "127.0.0.1:0"
+
+var errTestHandleFrame = errors.New("handle frame failed")
+
+type echoFrameHandler struct {
+	sync.Mutex
+
+	closed bool
+}
+
+func (h *echoFrameHandler) HandleFrame(frame []byte, w Writer) error {
+	if string(frame) == "fail" {
+		return errTestHandleFrame
+	}
+	return w.Write(frame)
+}
+
+func (h *echoFrameHandler) Close() {
+	h.Lock()
+	h.closed = true
+	h.Unlock()
+}
+
+func (h *echoFrameHandler) isClosed() bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.closed
+}
+
+func testFramedServer(t *testing.T, opts Options) (server.Server, *echoFrameHandler, string) {
+	h := &echoFrameHandler{}
+	s := server.NewServer(testListenAddress, NewFramedHandler(h, opts), server.NewOptions())
+	require.NoError(t, s.ListenAndServe())
+	return s, h, s.Listeners()[0].Addr().String()
+}
+
+func waitForCounter(scope tally.TestScope, key string, value int64) bool {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		counter, ok := scope.Snapshot().Counters()[key]
+		if ok && counter.Value() == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFramedHandlerEcho(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetChecksumEnabled(true).
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	s, h, addr := testFramedServer(t, opts)
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w := NewWriter(conn, opts)
+	r := NewReader(conn, opts)
+	defer r.Close()
+	frames := [][]byte{[]byte("foo"), bytes.Repeat([]byte("a"), 10000)}
+	for _, frame := range frames {
+		require.NoError(t, w.Write(frame))
+		read, err := r.Read()
+		require.NoError(t, err)
+		require.Equal(t, frame, read)
+	}
+	require.NoError(t, w.Close())
+
+	require.True(t, waitForCounter(scope, "frames-read+", 2))
+	require.True(t, waitForCounter(scope, "frame-bytes-read+", 10003))
+
+	s.Close()
+	require.True(t, h.isClosed())
+}
+
+func TestFramedHandlerClosesConnectionOnInvalidFrame(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetMaxFrameSize(16).
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	s, _, addr := testFramedServer(t, opts)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w := NewWriter(conn, opts.SetMaxFrameSize(32))
+	require.NoError(t, w.Write(bytes.Repeat([]byte("a"), 17)))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = NewReader(conn, opts).Read()
+	require.Error(t, err)
+	require.True(t, waitForCounter(scope, "frame-read-errors+error-type=frame-too-large", 1))
+}
+
+func TestFramedHandlerClosesConnectionOnHandleError(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	s, _, addr := testFramedServer(t, opts)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	w := NewWriter(conn, opts)
+	require.NoError(t, w.Write([]byte("fail")))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = NewReader(conn, opts).Read()
+	require.Error(t, err)
+	require.True(t, waitForCounter(scope, "frame-handle-errors+", 1))
+}
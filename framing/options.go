@@ -0,0 +1,174 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"math"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/pool"
+)
+
+const (
+	defaultHeaderType      = VarintHeader
+	defaultMaxFrameSize    = 4 * 1024 * 1024
+	defaultChecksumEnabled = false
+	defaultReadBufferSize  = 16 * 1024
+	defaultFlushSize       = 0
+	defaultFlushInterval   = 0
+)
+
+// maxFixedHeaderFrameSize is a variable rather than a constant so that
+// converting it to an int compiles on 32 bit platforms.
+var maxFixedHeaderFrameSize int64 = math.MaxUint32
+
+type options struct {
+	instrumentOpts  instrument.Options
+	headerType      HeaderType
+	maxFrameSize    int
+	checksumEnabled bool
+	bytesPool       pool.BytesPool
+	readBufferSize  int
+	flushSize       int
+	flushInterval   time.Duration
+}
+
+// NewOptions creates a new set of framing options.
+func NewOptions() Options {
+	return &options{
+		instrumentOpts:  instrument.NewOptions(),
+		headerType:      defaultHeaderType,
+		maxFrameSize:    defaultMaxFrameSize,
+		checksumEnabled: defaultChecksumEnabled,
+		readBufferSize:  defaultReadBufferSize,
+		flushSize:       defaultFlushSize,
+		flushInterval:   defaultFlushInterval,
+	}
+}
+
+func (o *options) SetInstrumentOptions(value instrument.Options) Options {
+	opts := *o
+	opts.instrumentOpts = value
+	return &opts
+}
+
+func (o *options) InstrumentOptions() instrument.Options {
+	return o.instrumentOpts
+}
+
+func (o *options) SetHeaderType(value HeaderType) Options {
+	opts := *o
+	opts.headerType = value
+	return &opts
+}
+
+func (o *options) HeaderType() HeaderType {
+	return o.headerType
+}
+
+func (o *options) SetMaxFrameSize(value int) Options {
+	opts := *o
+	opts.maxFrameSize = value
+	return &opts
+}
+
+func (o *options) MaxFrameSize() int {
+	return o.maxFrameSize
+}
+
+func (o *options) SetChecksumEnabled(value bool) Options {
+	opts := *o
+	opts.checksumEnabled = value
+	return &opts
+}
+
+func (o *options) ChecksumEnabled() bool {
+	return o.checksumEnabled
+}
+
+func (o *options) SetBytesPool(value pool.BytesPool) Options {
+	opts := *o
+	opts.bytesPool = value
+	return &opts
+}
+
+func (o *options) BytesPool() pool.BytesPool {
+	return o.bytesPool
+}
+
+func (o *options) SetReadBufferSize(value int) Options {
+	opts := *o
+	opts.readBufferSize = value
+	return &opts
+}
+
+func (o *options) ReadBufferSize() int {
+	return o.readBufferSize
+}
+
+func (o *options) SetFlushSize(value int) Options {
+	opts := *o
+	opts.flushSize = value
+	return &opts
+}
+
+func (o *options) FlushSize() int {
+	return o.flushSize
+}
+
+func (o *options) SetFlushInterval(value time.Duration) Options {
+	opts := *o
+	opts.flushInterval = value
+	return &opts
+}
+
+func (o *options) FlushInterval() time.Duration {
+	return o.flushInterval
+}
+
+// maxFrameSize returns the maximum frame size of the options, clamped to
+// the largest length that fits a fixed header.
+func maxFrameSize(opts Options) int {
+	max := opts.MaxFrameSize()
+	if opts.HeaderType() == FixedHeader && int64(max) > maxFixedHeaderFrameSize {
+		return int(maxFixedHeaderFrameSize)
+	}
+	return max
+}
+
+// buffers gets and puts buffers from an optional bytes pool.
+type buffers struct {
+	pool pool.BytesPool
+}
+
+func (b buffers) get(capacity int) []byte {
+	if b.pool == nil {
+		return make([]byte, 0, capacity)
+	}
+	return b.pool.Get(capacity)[:0]
+}
+
+func (b buffers) put(buf []byte) {
+	if b.pool != nil && buf != nil {
+		b.pool.Put(buf)
+	}
+}
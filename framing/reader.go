@@ -0,0 +1,132 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	fixedHeaderLen = 4
+	checksumLen    = 4
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type reader struct {
+	r            *bufio.Reader
+	headerType   HeaderType
+	maxFrameSize int
+	checksum     bool
+	buffers      buffers
+	buf          []byte
+	header       [fixedHeaderLen]byte
+}
+
+// NewReader creates a new frame reader.
+func NewReader(r io.Reader, opts Options) Reader {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	return &reader{
+		r:            bufio.NewReaderSize(r, opts.ReadBufferSize()),
+		headerType:   opts.HeaderType(),
+		maxFrameSize: maxFrameSize(opts),
+		checksum:     opts.ChecksumEnabled(),
+		buffers:      buffers{pool: opts.BytesPool()},
+	}
+}
+
+func (r *reader) Read() ([]byte, error) {
+	size, err := r.readHeader()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(r.maxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	n := int(size)
+	total := n
+	if r.checksum {
+		total += checksumLen
+	}
+	if cap(r.buf) < total {
+		r.buffers.put(r.buf)
+		r.buf = r.buffers.get(total)
+	}
+	r.buf = r.buf[:total]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	frame := r.buf[:n]
+	if r.checksum {
+		expected := binary.BigEndian.Uint32(r.buf[n:])
+		if crc32.Checksum(frame, crcTable) != expected {
+			return nil, ErrChecksumMismatch
+		}
+	}
+	return frame, nil
+}
+
+func (r *reader) readHeader() (uint64, error) {
+	if r.headerType == FixedHeader {
+		if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(r.header[:])), nil
+	}
+
+	var size uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := r.r.ReadByte()
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if b < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b > 1 {
+				return 0, ErrInvalidHeader
+			}
+			return size | uint64(b)<<(7*uint(i)), nil
+		}
+		size |= uint64(b&0x7f) << (7 * uint(i))
+	}
+	return 0, ErrInvalidHeader
+}
+
+func (r *reader) Reset(rd io.Reader) {
+	r.r.Reset(rd)
+}
+
+func (r *reader) Close() {
+	r.buffers.put(r.buf)
+	r.buf = nil
+}
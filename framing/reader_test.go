@@ -0,0 +1,168 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/m3db/m3x/pool"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBytesPool() pool.BytesPool {
+	p := pool.NewBytesPool([]pool.Bucket{
+		{Capacity: 16, Count: 4},
+		{Capacity: 4096, Count: 4},
+	}, nil)
+	p.Init()
+	return p
+}
+
+func testOptionsMatrix() []Options {
+	var optsList []Options
+	for _, headerType := range []HeaderType{VarintHeader, FixedHeader} {
+		for _, checksum := range []bool{false, true} {
+			optsList = append(optsList, NewOptions().
+				SetHeaderType(headerType).
+				SetChecksumEnabled(checksum).
+				SetBytesPool(testBytesPool()))
+		}
+	}
+	return optsList
+}
+
+func TestReadWriteRoundTrip(t *testing.T) {
+	frames := [][]byte{
+		[]byte("foo"),
+		nil,
+		bytes.Repeat([]byte("a"), 300),
+		bytes.Repeat([]byte("b"), 10000),
+	}
+	for _, opts := range testOptionsMatrix() {
+		t.Run(fmt.Sprintf("header=%d,checksum=%v", opts.HeaderType(), opts.ChecksumEnabled()), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, opts)
+			for _, frame := range frames {
+				require.NoError(t, w.Write(frame))
+			}
+			require.NoError(t, w.Close())
+
+			r := NewReader(&buf, opts)
+			defer r.Close()
+			for _, frame := range frames {
+				read, err := r.Read()
+				require.NoError(t, err)
+				require.Equal(t, len(frame), len(read))
+				require.True(t, bytes.Equal(frame, read))
+			}
+			_, err := r.Read()
+			require.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestReaderFrameTooLarge(t *testing.T) {
+	for _, opts := range testOptionsMatrix() {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, opts)
+		require.NoError(t, w.Write([]byte("foobar")))
+		require.NoError(t, w.Close())
+
+		r := NewReader(&buf, opts.SetMaxFrameSize(5))
+		_, err := r.Read()
+		require.Equal(t, ErrFrameTooLarge, err)
+	}
+}
+
+func TestReaderFrameTooLargeDoesNotAllocate(t *testing.T) {
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], 1<<62)
+
+	r := NewReader(bytes.NewReader(header[:n]), NewOptions()).(*reader)
+	_, err := r.Read()
+	require.Equal(t, ErrFrameTooLarge, err)
+	require.Nil(t, r.buf)
+}
+
+func TestReaderChecksumMismatch(t *testing.T) {
+	opts := NewOptions().SetChecksumEnabled(true)
+	var buf bytes.Buffer
+	w := NewWriter(&buf, opts)
+	require.NoError(t, w.Write([]byte("foobar")))
+	require.NoError(t, w.Close())
+
+	data := buf.Bytes()
+	data[2] ^= 0x1
+	_, err := NewReader(bytes.NewReader(data), opts).Read()
+	require.Equal(t, ErrChecksumMismatch, err)
+}
+
+func TestReaderInvalidVarintHeader(t *testing.T) {
+	data := bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64+1)
+	_, err := NewReader(bytes.NewReader(data), NewOptions()).Read()
+	require.Equal(t, ErrInvalidHeader, err)
+
+	data = append(bytes.Repeat([]byte{0xff}, binary.MaxVarintLen64-1), 0x2)
+	_, err = NewReader(bytes.NewReader(data), NewOptions()).Read()
+	require.Equal(t, ErrInvalidHeader, err)
+}
+
+func TestReaderUnexpectedEOF(t *testing.T) {
+	for _, opts := range testOptionsMatrix() {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, opts)
+		require.NoError(t, w.Write(bytes.Repeat([]byte("a"), 200)))
+		require.NoError(t, w.Close())
+
+		data := buf.Bytes()
+		for _, n := range []int{1, len(data) - 1} {
+			_, err := NewReader(bytes.NewReader(data[:n]), opts).Read()
+			require.Equal(t, io.ErrUnexpectedEOF, err)
+		}
+	}
+}
+
+func TestReaderReset(t *testing.T) {
+	opts := NewOptions()
+	var first, second bytes.Buffer
+	for _, b := range []*bytes.Buffer{&first, &second} {
+		w := NewWriter(b, opts)
+		require.NoError(t, w.Write([]byte("foo")))
+		require.NoError(t, w.Close())
+	}
+
+	r := NewReader(&first, opts)
+	frame, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(frame))
+	_, err = r.Read()
+	require.Equal(t, io.EOF, err)
+
+	r.Reset(&second)
+	frame, err = r.Read()
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(frame))
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package framing implements length-prefixed framing of messages over a
+// stream such as a network connection.
+package framing
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/pool"
+)
+
+// HeaderType is the type of the length header preceding each frame.
+type HeaderType int
+
+const (
+	// VarintHeader prefixes each frame with its length as an unsigned varint.
+	VarintHeader HeaderType = iota
+
+	// FixedHeader prefixes each frame with its length as a 4 byte big endian
+	// unsigned integer.
+	FixedHeader
+)
+
+var (
+	// ErrFrameTooLarge is returned when a frame is larger than the maximum
+	// frame size.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrChecksumMismatch is returned when the checksum of a frame does not
+	// match its payload.
+	ErrChecksumMismatch = errors.New("frame checksum mismatch")
+
+	// ErrInvalidHeader is returned when the length header of a frame is invalid.
+	ErrInvalidHeader = errors.New("invalid frame header")
+
+	// ErrWriterClosed is returned when writing to a closed writer.
+	ErrWriterClosed = errors.New("frame writer closed")
+)
+
+// Reader reads frames from a stream.
+type Reader interface {
+	// Read reads the next frame, the frame is only valid until the next call
+	// to Read or Close. It returns io.EOF if the stream ends between frames
+	// and io.ErrUnexpectedEOF if it ends within a frame.
+	Read() ([]byte, error)
+
+	// Reset resets the reader to read from a new stream.
+	Reset(r io.Reader)
+
+	// Close returns the buffers of the reader to the pool.
+	Close()
+}
+
+// Writer writes frames to a stream, frames are buffered and flushed once
+// the buffered frames reach the flush size or the flush interval elapses.
+// Writers are safe for concurrent use.
+type Writer interface {
+	// Write writes a frame.
+	Write(frame []byte) error
+
+	// Flush writes the buffered frames to the stream.
+	Flush() error
+
+	// Close flushes the buffered frames and returns the buffers of the
+	// writer to the pool, the stream itself is not closed.
+	Close() error
+}
+
+// FrameHandler handles the frames read from a connection.
+type FrameHandler interface {
+	// HandleFrame handles a frame read from a connection, responses can be
+	// written to the connection with the writer. The frame is only valid
+	// until the call returns, returning an error closes the connection.
+	HandleFrame(frame []byte, w Writer) error
+
+	// Close closes the handler.
+	Close()
+}
+
+// Options provide a set of framing options.
+type Options interface {
+	// SetInstrumentOptions sets the instrument options.
+	SetInstrumentOptions(value instrument.Options) Options
+
+	// InstrumentOptions returns the instrument options.
+	InstrumentOptions() instrument.Options
+
+	// SetHeaderType sets the type of the length header.
+	SetHeaderType(value HeaderType) Options
+
+	// HeaderType returns the type of the length header.
+	HeaderType() HeaderType
+
+	// SetMaxFrameSize sets the maximum size of the payload of a frame, it is
+	// limited to math.MaxUint32 with a fixed header.
+	SetMaxFrameSize(value int) Options
+
+	// MaxFrameSize returns the maximum size of the payload of a frame.
+	MaxFrameSize() int
+
+	// SetChecksumEnabled sets whether each frame is followed by the CRC32
+	// checksum of its payload.
+	SetChecksumEnabled(value bool) Options
+
+	// ChecksumEnabled returns whether each frame is followed by the CRC32
+	// checksum of its payload.
+	ChecksumEnabled() bool
+
+	// SetBytesPool sets the pool of frame buffers, buffers are allocated if
+	// it's not set.
+	SetBytesPool(value pool.BytesPool) Options
+
+	// BytesPool returns the pool of frame buffers.
+	BytesPool() pool.BytesPool
+
+	// SetReadBufferSize sets the size of the buffer used to read the stream.
+	SetReadBufferSize(value int) Options
+
+	// ReadBufferSize returns the size of the buffer used to read the stream.
+	ReadBufferSize() int
+
+	// SetFlushSize sets the size of the buffered frames at which writers
+	// flush, zero flushes every frame.
+	SetFlushSize(value int) Options
+
+	// FlushSize returns the size of the buffered frames at which writers
+	// flush.
+	FlushSize() int
+
+	// SetFlushInterval sets the maximum duration frames are buffered by
+	// writers before they are flushed, zero means frames are only flushed
+	// on size or explicitly.
+	SetFlushInterval(value time.Duration) Options
+
+	// FlushInterval returns the maximum duration frames are buffered by
+	// writers before they are flushed.
+	FlushInterval() time.Duration
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sync"
+	"time"
+)
+
+const defaultWriteBufferSize = 4096
+
+type writer struct {
+	sync.Mutex
+
+	w             io.Writer
+	headerType    HeaderType
+	maxFrameSize  int
+	checksum      bool
+	buffers       buffers
+	flushSize     int
+	flushInterval time.Duration
+
+	buf    []byte
+	timer  *time.Timer
+	err    error
+	closed bool
+}
+
+// NewWriter creates a new frame writer.
+func NewWriter(w io.Writer, opts Options) Writer {
+	if opts == nil {
+		opts = NewOptions()
+	}
+	return &writer{
+		w:             w,
+		headerType:    opts.HeaderType(),
+		maxFrameSize:  maxFrameSize(opts),
+		checksum:      opts.ChecksumEnabled(),
+		buffers:       buffers{pool: opts.BytesPool()},
+		flushSize:     opts.FlushSize(),
+		flushInterval: opts.FlushInterval(),
+	}
+}
+
+func (w *writer) Write(frame []byte) error {
+	if len(frame) > w.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	w.append(frame)
+	if len(w.buf) >= w.flushSize {
+		return w.flushWithLock()
+	}
+	if w.flushInterval > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(w.flushInterval, w.flushOnTimer)
+	}
+	return nil
+}
+
+func (w *writer) append(frame []byte) {
+	size := len(frame) + binary.MaxVarintLen64
+	if w.checksum {
+		size += checksumLen
+	}
+	w.grow(size)
+
+	if w.headerType == FixedHeader {
+		var header [fixedHeaderLen]byte
+		binary.BigEndian.PutUint32(header[:], uint32(len(frame)))
+		w.buf = append(w.buf, header[:]...)
+	} else {
+		var header [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(header[:], uint64(len(frame)))
+		w.buf = append(w.buf, header[:n]...)
+	}
+	w.buf = append(w.buf, frame...)
+	if w.checksum {
+		var sum [checksumLen]byte
+		binary.BigEndian.PutUint32(sum[:], crc32.Checksum(frame, crcTable))
+		w.buf = append(w.buf, sum[:]...)
+	}
+}
+
+// grow ensures the buffer has room for n more bytes, replacing it with a
+// larger buffer from the pool if necessary.
+func (w *writer) grow(n int) {
+	if cap(w.buf)-len(w.buf) >= n {
+		return
+	}
+	capacity := 2 * cap(w.buf)
+	if min := len(w.buf) + n; capacity < min {
+		capacity = min
+	}
+	if capacity < defaultWriteBufferSize {
+		capacity = defaultWriteBufferSize
+	}
+	if capacity < w.flushSize {
+		capacity = w.flushSize
+	}
+	buf := append(w.buffers.get(capacity), w.buf...)
+	w.buffers.put(w.buf)
+	w.buf = buf
+}
+
+func (w *writer) Flush() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+	return w.flushWithLock()
+}
+
+func (w *writer) flushOnTimer() {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.flushWithLock()
+}
+
+func (w *writer) flushWithLock() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf)
+	w.buf = w.buf[:0]
+	if err != nil {
+		// NB: a failed write may have partially written a frame so the
+		// stream can no longer be written to.
+		w.err = err
+	}
+	return err
+}
+
+func (w *writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+	err := w.flushWithLock()
+	w.closed = true
+	w.buffers.put(w.buf)
+	w.buf = nil
+	return err
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	sync.Mutex
+
+	buf    bytes.Buffer
+	writes int
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.writes++
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) numWrites() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.writes
+}
+
+func (b *syncBuffer) frames(t *testing.T, opts Options) []string {
+	b.Lock()
+	defer b.Unlock()
+	var frames []string
+	r := NewReader(bytes.NewReader(b.buf.Bytes()), opts)
+	for {
+		frame, err := r.Read()
+		if err == io.EOF {
+			return frames
+		}
+		require.NoError(t, err)
+		frames = append(frames, string(frame))
+	}
+}
+
+type errWriter struct {
+	writes int
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return 0, errors.New("write failed")
+}
+
+func TestWriterFlushesEveryFrameByDefault(t *testing.T) {
+	var buf syncBuffer
+	w := NewWriter(&buf, NewOptions())
+	require.NoError(t, w.Write([]byte("foo")))
+	require.NoError(t, w.Write([]byte("bar")))
+	require.Equal(t, 2, buf.numWrites())
+	require.Equal(t, []string{"foo", "bar"}, buf.frames(t, NewOptions()))
+}
+
+func TestWriterFlushOnSize(t *testing.T) {
+	var buf syncBuffer
+	opts := NewOptions().SetFlushSize(8)
+	w := NewWriter(&buf, opts)
+
+	require.NoError(t, w.Write([]byte("foo")))
+	require.Equal(t, 0, buf.numWrites())
+	require.NoError(t, w.Write([]byte("bar")))
+	require.Equal(t, 1, buf.numWrites())
+	require.Equal(t, []string{"foo", "bar"}, buf.frames(t, opts))
+
+	require.NoError(t, w.Write([]byte("baz")))
+	require.NoError(t, w.Flush())
+	require.Equal(t, 2, buf.numWrites())
+	require.NoError(t, w.Flush())
+	require.Equal(t, 2, buf.numWrites())
+	require.NoError(t, w.Close())
+	require.Equal(t, []string{"foo", "bar", "baz"}, buf.frames(t, opts))
+}
+
+func TestWriterFlushOnInterval(t *testing.T) {
+	var buf syncBuffer
+	opts := NewOptions().
+		SetFlushSize(1024).
+		SetFlushInterval(20 * time.Millisecond)
+	w := NewWriter(&buf, opts)
+	defer w.Close()
+
+	require.NoError(t, w.Write([]byte("foo")))
+	require.NoError(t, w.Write([]byte("bar")))
+	for buf.numWrites() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	require.Equal(t, 1, buf.numWrites())
+	require.Equal(t, []string{"foo", "bar"}, buf.frames(t, opts))
+}
+
+func TestWriterFlushesOnClose(t *testing.T) {
+	var buf syncBuffer
+	opts := NewOptions().SetFlushSize(1024)
+	w := NewWriter(&buf, opts)
+	require.NoError(t, w.Write([]byte("foo")))
+	require.NoError(t, w.Close())
+	require.Equal(t, []string{"foo"}, buf.frames(t, opts))
+
+	require.Equal(t, ErrWriterClosed, w.Write([]byte("bar")))
+	require.Equal(t, ErrWriterClosed, w.Flush())
+	require.Equal(t, ErrWriterClosed, w.Close())
+}
+
+func TestWriterFrameTooLarge(t *testing.T) {
+	var buf syncBuffer
+	w := NewWriter(&buf, NewOptions().SetMaxFrameSize(2))
+	require.Equal(t, ErrFrameTooLarge, w.Write([]byte("foo")))
+	require.NoError(t, w.Close())
+	require.Equal(t, 0, buf.numWrites())
+}
+
+func TestWriterFixedHeaderMaxFrameSizeClamped(t *testing.T) {
+	if strconv.IntSize == 32 {
+		t.Skip("max frame size cannot exceed math.MaxUint32")
+	}
+
+	var (
+		max  = int(maxFixedHeaderFrameSize)
+		opts = NewOptions().
+			SetHeaderType(FixedHeader).
+			SetMaxFrameSize(max + 1)
+	)
+	w := NewWriter(ioutil.Discard, opts).(*writer)
+	require.Equal(t, max, w.maxFrameSize)
+	r := NewReader(bytes.NewReader(nil), opts).(*reader)
+	require.Equal(t, max, r.maxFrameSize)
+
+	opts = opts.SetHeaderType(VarintHeader)
+	w = NewWriter(ioutil.Discard, opts).(*writer)
+	require.Equal(t, max+1, w.maxFrameSize)
+}
+
+func TestWriterErrorIsSticky(t *testing.T) {
+	var ew errWriter
+	w := NewWriter(&ew, NewOptions())
+	require.Error(t, w.Write([]byte("foo")))
+	require.Error(t, w.Write([]byte("bar")))
+	require.Error(t, w.Close())
+	require.Equal(t, 1, ew.writes)
+}
+
+func TestWriterConcurrentWrites(t *testing.T) {
+	var buf syncBuffer
+	opts := NewOptions().
+		SetFlushSize(64).
+		SetFlushInterval(time.Millisecond).
+		SetBytesPool(testBytesPool())
+	w := NewWriter(&buf, opts)
+
+	var (
+		wg         sync.WaitGroup
+		numWriters = 8
+		numFrames  = 100
+	)
+	for i := 0; i < numWriters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < numFrames; j++ {
+				require.NoError(t, w.Write([]byte("foobar")))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, w.Close())
+	require.Len(t, buf.frames(t, opts), numWriters*numFrames)
+}
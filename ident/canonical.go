@@ -0,0 +1,143 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/m3db/m3x/pool"
+)
+
+const (
+	canonicalLengthPrefixLength = 2
+)
+
+// NewCanonicalID returns the canonical ID for a name and set of tags of
+// the form name{k1=v1,k2=v2} with tags in ascending order of name. The
+// tags are sorted in place and validated with ValidateTags, the name must
+// consist of the same characters as tag names but can be empty. The ID
+// takes ownership of bytes from the pool which are returned on finalize.
+// The format is stable and must not change across releases.
+func NewCanonicalID(
+	name []byte,
+	tags Tags,
+	bytesPool pool.CheckedBytesPool,
+) (ID, error) {
+	if err := prepareCanonicalTags(name, tags); err != nil {
+		return nil, err
+	}
+
+	values := tags.Values()
+	size := len(name) + 2
+	for _, tag := range values {
+		size += len(tag.Name.Bytes()) + len(tag.Value.Bytes()) + 2
+	}
+
+	data := bytesPool.Get(size)
+	data.IncRef()
+	data.AppendAll(name)
+	data.Append('{')
+	for i, tag := range values {
+		if i > 0 {
+			data.Append(',')
+		}
+		data.AppendAll(tag.Name.Bytes())
+		data.Append('=')
+		data.AppendAll(tag.Value.Bytes())
+	}
+	data.Append('}')
+	data.DecRef()
+
+	return BinaryID(data), nil
+}
+
+// NewCanonicalBinaryID returns the canonical binary ID for a name and set
+// of tags, each literal is prefixed by its length as a little endian
+// uint16 and tags are in ascending order of name:
+//
+//	name length | name | number of tags (uint16) |
+//	tag name length | tag name | tag value length | tag value | ...
+//
+// The tags are sorted in place and validated as with NewCanonicalID. The
+// format is stable and must not change across releases.
+func NewCanonicalBinaryID(
+	name []byte,
+	tags Tags,
+	bytesPool pool.CheckedBytesPool,
+) (ID, error) {
+	if err := prepareCanonicalTags(name, tags); err != nil {
+		return nil, err
+	}
+
+	values := tags.Values()
+	if len(values) > math.MaxUint16 {
+		return nil, fmt.Errorf("too many tags for binary ID: %d", len(values))
+	}
+
+	size := 2*canonicalLengthPrefixLength + len(name)
+	for _, tag := range values {
+		size += 2*canonicalLengthPrefixLength +
+			len(tag.Name.Bytes()) + len(tag.Value.Bytes())
+	}
+
+	var (
+		data = bytesPool.Get(size)
+		buf  [canonicalLengthPrefixLength]byte
+		err  error
+	)
+	appendLiteral := func(v []byte) {
+		if len(v) > math.MaxUint16 {
+			err = fmt.Errorf("literal too long for binary ID: %d", len(v))
+			return
+		}
+		binary.LittleEndian.PutUint16(buf[:], uint16(len(v)))
+		data.AppendAll(buf[:])
+		data.AppendAll(v)
+	}
+
+	data.IncRef()
+	appendLiteral(name)
+	binary.LittleEndian.PutUint16(buf[:], uint16(len(values)))
+	data.AppendAll(buf[:])
+	for _, tag := range values {
+		appendLiteral(tag.Name.Bytes())
+		appendLiteral(tag.Value.Bytes())
+	}
+	data.DecRef()
+
+	if err != nil {
+		data.Finalize()
+		return nil, err
+	}
+	return BinaryID(data), nil
+}
+
+func prepareCanonicalTags(name []byte, tags Tags) error {
+	if len(name) > 0 {
+		if err := validateTagName(name); err != nil {
+			return fmt.Errorf("invalid name: %v", err)
+		}
+	}
+	SortTags(tags)
+	return ValidateTags(tags)
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The canonical IDs must be stable across releases, these golden values
+// must never be changed.
+var canonicalIDGoldenTests = []struct {
+	name   string
+	tags   []string
+	id     string
+	binary string
+}{
+	{
+		name:   "",
+		tags:   nil,
+		id:     "{}",
+		binary: "00000000",
+	},
+	{
+		name:   "requests",
+		tags:   nil,
+		id:     "requests{}",
+		binary: "0800" + hex.EncodeToString([]byte("requests")) + "0000",
+	},
+	{
+		name: "requests",
+		tags: []string{"service", "m3", "env", "prod", "dc", ""},
+		id:   "requests{dc=,env=prod,service=m3}",
+		binary: "0800" + hex.EncodeToString([]byte("requests")) + "0300" +
+			"0200" + hex.EncodeToString([]byte("dc")) + "0000" +
+			"0300" + hex.EncodeToString([]byte("env")) +
+			"0400" + hex.EncodeToString([]byte("prod")) +
+			"0700" + hex.EncodeToString([]byte("service")) +
+			"0200" + hex.EncodeToString([]byte("m3")),
+	},
+	{
+		name:   "",
+		tags:   []string{"b", "é", "a", "x y"},
+		id:     "{a=x y,b=é}",
+		binary: "00000200" + "0100610300782079" + "01006202" + "00c3a9",
+	},
+}
+
+func TestCanonicalIDGolden(t *testing.T) {
+	bytesPool := newTestBytesPool()
+	for _, test := range canonicalIDGoldenTests {
+		id, err := NewCanonicalID([]byte(test.name), newTestTags(test.tags...), bytesPool)
+		require.NoError(t, err)
+		assert.Equal(t, test.id, id.String())
+		id.Finalize()
+
+		id, err = NewCanonicalBinaryID([]byte(test.name), newTestTags(test.tags...), bytesPool)
+		require.NoError(t, err)
+		assert.Equal(t, test.binary, hex.EncodeToString(id.Bytes()), test.id)
+		id.Finalize()
+	}
+}
+
+func TestCanonicalIDOrderIndependent(t *testing.T) {
+	bytesPool := newTestBytesPool()
+	a, err := NewCanonicalID([]byte("m"), newTestTags("a", "1", "b", "2"), bytesPool)
+	require.NoError(t, err)
+	b, err := NewCanonicalID([]byte("m"), newTestTags("b", "2", "a", "1"), bytesPool)
+	require.NoError(t, err)
+	assert.True(t, a.Equal(b))
+}
+
+func TestCanonicalIDInvalid(t *testing.T) {
+	bytesPool := newTestBytesPool()
+
+	_, err := NewCanonicalID([]byte("a{"), newTestTags("a", "1"), bytesPool)
+	assert.Error(t, err)
+	_, err = NewCanonicalID([]byte("m"), newTestTags("a", "1", "a", "2"), bytesPool)
+	assert.Error(t, err)
+	_, err = NewCanonicalBinaryID([]byte("m"), newTestTags("a", "1,"), bytesPool)
+	assert.Error(t, err)
+}
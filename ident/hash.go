@@ -0,0 +1,210 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"errors"
+	"math"
+
+	"github.com/m3db/m3x/hash/jump"
+
+	"github.com/cespare/xxhash"
+)
+
+const (
+	// tagHashPrime is used to combine the hashes of a tag name and value so
+	// that swapping a name and value does not produce the same hash.
+	tagHashPrime = 0x9e3779b97f4a7c15
+)
+
+var (
+	errInvalidNumShards = errors.New("number of shards must be positive")
+)
+
+// HashBytes returns the hash of a byte slice, it is the xxhash64 of the
+// bytes with a zero seed. The hash is stable and must not change across
+// releases as it is used to place data on shards.
+func HashBytes(data []byte) uint64 {
+	return xxhash.Sum64(data)
+}
+
+// HashID returns the stable hash of an ID.
+func HashID(id ID) uint64 {
+	return HashBytes(id.Bytes())
+}
+
+// HashTag returns the stable hash of a single tag.
+func HashTag(tag Tag) uint64 {
+	return hashTag(tag.Name.Bytes(), tag.Value.Bytes())
+}
+
+// HashTags returns the stable hash of a set of tags, the hash does not
+// depend on the order of the tags.
+func HashTags(tags Tags) uint64 {
+	var sum uint64
+	for _, tag := range tags.Values() {
+		sum += HashTag(tag)
+	}
+	return finalizeTagsHash(sum, len(tags.Values()))
+}
+
+// HashTagIterator returns the stable hash of the tags remaining in a tag
+// iterator, the hash does not depend on the order of the tags and matches
+// HashTags for the same set of tags. The iterator is not advanced, an
+// error is returned if iterating the tags fails.
+func HashTagIterator(iter TagIterator) (uint64, error) {
+	dupe := iter.Duplicate()
+	defer dupe.Close()
+
+	var (
+		sum uint64
+		n   int
+	)
+	for dupe.Next() {
+		sum += HashTag(dupe.Current())
+		n++
+	}
+	if err := dupe.Err(); err != nil {
+		return 0, err
+	}
+	return finalizeTagsHash(sum, n), nil
+}
+
+func hashTag(name, value []byte) uint64 {
+	return xxhash.Sum64(name)*tagHashPrime ^ xxhash.Sum64(value)
+}
+
+// finalizeTagsHash mixes the commutative sum of the tag hashes with the
+// number of tags so that the result is well distributed, the mixing
+// function is the 64 bit finalizer of MurmurHash3.
+func finalizeTagsHash(sum uint64, n int) uint64 {
+	h := sum ^ uint64(n)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// Sharder maps IDs and tags to shards using jump consistent hashing, when
+// the number of shards changes only the minimum number of IDs move to a
+// different shard.
+type Sharder interface {
+	// NumShards returns the number of shards.
+	NumShards() int
+
+	// Shard returns the shard for an ID.
+	Shard(id ID) uint32
+
+	// ShardHash returns the shard for a hash returned by one of the hash
+	// functions in this package.
+	ShardHash(hash uint64) uint32
+
+	// Resharded returns a new sharder with a different number of shards,
+	// the sharder itself is not modified.
+	Resharded(numShards int) (Sharder, error)
+
+	// Movement returns the statistics of IDs moving between shards if the
+	// number of shards changed to the specified number, the IDs are
+	// consumed from the iterator.
+	Movement(numShards int, ids Iterator) (ShardMovement, error)
+}
+
+// ShardMovement describes how IDs move between shards when the number of
+// shards changes.
+type ShardMovement struct {
+	// FromShards is the number of shards before the change.
+	FromShards int
+	// ToShards is the number of shards after the change.
+	ToShards int
+	// Total is the number of IDs inspected.
+	Total int
+	// Moved is the number of IDs that moved to a different shard.
+	Moved int
+}
+
+// MovedFraction returns the fraction of IDs that moved shards.
+func (m ShardMovement) MovedFraction() float64 {
+	if m.Total == 0 {
+		return 0
+	}
+	return float64(m.Moved) / float64(m.Total)
+}
+
+// ExpectedMovedFraction returns the fraction of IDs expected to move with
+// jump consistent hashing, which is the optimal fraction for the change.
+func (m ShardMovement) ExpectedMovedFraction() float64 {
+	max := math.Max(float64(m.FromShards), float64(m.ToShards))
+	if max == 0 {
+		return 0
+	}
+	return math.Abs(float64(m.ToShards-m.FromShards)) / max
+}
+
+type sharder struct {
+	numShards int
+}
+
+// NewSharder returns a new sharder with the specified number of shards.
+func NewSharder(numShards int) (Sharder, error) {
+	if numShards <= 0 || int64(numShards) > math.MaxUint32 {
+		return nil, errInvalidNumShards
+	}
+	return sharder{numShards: numShards}, nil
+}
+
+func (s sharder) NumShards() int {
+	return s.numShards
+}
+
+func (s sharder) Shard(id ID) uint32 {
+	return s.ShardHash(HashID(id))
+}
+
+func (s sharder) ShardHash(hash uint64) uint32 {
+	return uint32(jump.Hash(hash, int64(s.numShards)))
+}
+
+func (s sharder) Resharded(numShards int) (Sharder, error) {
+	return NewSharder(numShards)
+}
+
+func (s sharder) Movement(numShards int, ids Iterator) (ShardMovement, error) {
+	if numShards <= 0 || int64(numShards) > math.MaxUint32 {
+		return ShardMovement{}, errInvalidNumShards
+	}
+
+	m := ShardMovement{FromShards: s.numShards, ToShards: numShards}
+	for ids.Next() {
+		hash := HashID(ids.Current())
+		from := jump.Hash(hash, int64(s.numShards))
+		to := jump.Hash(hash, int64(numShards))
+		if from != to {
+			m.Moved++
+		}
+		m.Total++
+	}
+	if err := ids.Err(); err != nil {
+		return ShardMovement{}, err
+	}
+	return m, nil
+}
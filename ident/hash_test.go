@@ -0,0 +1,166 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The golden values below lock down the hash functions, changing any of
+// them changes the placement of data on shards.
+
+func TestHashIDGolden(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected uint64
+	}{
+		{id: "", expected: 0xef46db3751d8e999},
+		{id: "foo", expected: 0x33bf00a859c4ba3f},
+		{id: "foo{bar=baz}", expected: 0xa9de179859a3fa59},
+		{id: "series.a.b.c", expected: 0x8a0536b61204355c},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, HashID(StringID(test.id)), test.id)
+		assert.Equal(t, test.expected, HashBytes([]byte(test.id)), test.id)
+	}
+}
+
+func TestHashTagsGolden(t *testing.T) {
+	assert.Equal(t, uint64(0x7049ff8413a86d72), HashTag(StringTag("foo", "bar")))
+	assert.Equal(t, uint64(0), HashTags(NewTags()))
+	assert.Equal(t, uint64(0xd23296890c079a13), HashTags(NewTags(
+		StringTag("foo", "bar"),
+		StringTag("baz", "qux"),
+	)))
+}
+
+func TestHashTagsOrderIndependent(t *testing.T) {
+	a := NewTags(StringTag("a", "1"), StringTag("b", "2"), StringTag("c", "3"))
+	b := NewTags(StringTag("c", "3"), StringTag("a", "1"), StringTag("b", "2"))
+	assert.Equal(t, HashTags(a), HashTags(b))
+
+	iter, err := NewTagStringsIterator("b", "2", "c", "3", "a", "1")
+	require.NoError(t, err)
+	hash, err := HashTagIterator(iter)
+	require.NoError(t, err)
+	assert.Equal(t, HashTags(a), hash)
+
+	// Hashing must not advance the iterator.
+	assert.Equal(t, 3, iter.Remaining())
+}
+
+func TestHashTagsDistinguishesTags(t *testing.T) {
+	base := HashTags(NewTags(StringTag("a", "1"), StringTag("b", "2")))
+	others := []Tags{
+		NewTags(StringTag("a", "2"), StringTag("b", "1")),
+		NewTags(StringTag("1", "a"), StringTag("2", "b")),
+		NewTags(StringTag("a", "1")),
+		NewTags(StringTag("a", "1"), StringTag("b", "2"), StringTag("b", "2")),
+		NewTags(StringTag("a", "1"), StringTag("b", "2"), StringTag("a", "1"), StringTag("b", "2")),
+	}
+	for _, tags := range others {
+		assert.NotEqual(t, base, HashTags(tags), fmt.Sprintf("%v", tags.Values()))
+	}
+}
+
+func TestNewSharderInvalid(t *testing.T) {
+	_, err := NewSharder(0)
+	assert.Error(t, err)
+	_, err = NewSharder(-1)
+	assert.Error(t, err)
+}
+
+func TestSharderGolden(t *testing.T) {
+	s, err := NewSharder(64)
+	require.NoError(t, err)
+	assert.Equal(t, 64, s.NumShards())
+
+	expected := map[string]uint32{
+		"foo":          36,
+		"bar":          30,
+		"baz":          49,
+		"qux":          51,
+		"series.a.b.c": 54,
+	}
+	for id, shard := range expected {
+		assert.Equal(t, shard, s.Shard(StringID(id)), id)
+		assert.Equal(t, shard, s.ShardHash(HashID(StringID(id))), id)
+	}
+}
+
+func TestSharderResharded(t *testing.T) {
+	s, err := NewSharder(8)
+	require.NoError(t, err)
+
+	resharded, err := s.Resharded(16)
+	require.NoError(t, err)
+	assert.Equal(t, 8, s.NumShards())
+	assert.Equal(t, 16, resharded.NumShards())
+
+	for i := 0; i < 1000; i++ {
+		id := StringID(fmt.Sprintf("id.%d", i))
+		assert.True(t, resharded.Shard(id) < 16)
+		// IDs either stay on their shard or move to one of the new shards.
+		if from, to := s.Shard(id), resharded.Shard(id); from != to {
+			assert.True(t, to >= 8)
+		}
+	}
+
+	_, err = s.Resharded(0)
+	assert.Error(t, err)
+}
+
+func TestSharderMovement(t *testing.T) {
+	s, err := NewSharder(10)
+	require.NoError(t, err)
+
+	ids := make([]string, 10000)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("series.%d", i)
+	}
+
+	for _, numShards := range []int{5, 10, 11, 20} {
+		m, err := s.Movement(numShards, NewStringIDsSliceIterator(ids))
+		require.NoError(t, err)
+		assert.Equal(t, 10, m.FromShards)
+		assert.Equal(t, numShards, m.ToShards)
+		assert.Equal(t, len(ids), m.Total)
+		assert.InDelta(t, m.ExpectedMovedFraction(), m.MovedFraction(), 0.02,
+			fmt.Sprintf("shards=%d", numShards))
+	}
+
+	m, err := s.Movement(10, NewStringIDsSliceIterator(ids))
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.Moved)
+
+	_, err = s.Movement(0, NewStringIDsSliceIterator(ids))
+	assert.Error(t, err)
+}
+
+func TestShardMovementEmpty(t *testing.T) {
+	var m ShardMovement
+	assert.Equal(t, 0.0, m.MovedFraction())
+	assert.Equal(t, 0.0, m.ExpectedMovedFraction())
+}
@@ -38,6 +38,10 @@ type PoolOptions struct {
 	TagsCapacity            int
 	TagsMaxCapacity         int
 	TagsIteratorPoolOptions pool.ObjectPoolOptions
+	// Interner if set is used to return shared IDs for string and binary
+	// IDs and tags rather than copying each value into pooled bytes, binary
+	// IDs then copy rather than take a reference to the bytes.
+	Interner Interner
 }
 
 func (o PoolOptions) defaultsIfNotSet() PoolOptions {
@@ -75,6 +79,7 @@ func NewPool(
 			MaxCapacity: opts.TagsMaxCapacity,
 		}),
 		itersPool: pool.NewObjectPool(opts.TagsIteratorPoolOptions),
+		interner:  opts.Interner,
 	}
 	p.pool.Init(func() interface{} {
 		return &id{pool: p}
@@ -92,6 +97,7 @@ type simplePool struct {
 	pool         pool.ObjectPool
 	tagArrayPool tagArrayPool
 	itersPool    pool.ObjectPool
+	interner     Interner
 }
 
 func (p *simplePool) GetBinaryID(ctx context.Context, v checked.Bytes) ID {
@@ -101,6 +107,15 @@ func (p *simplePool) GetBinaryID(ctx context.Context, v checked.Bytes) ID {
 }
 
 func (p *simplePool) BinaryID(v checked.Bytes) ID {
+	if p.interner != nil {
+		// The interned ID holds a copy of the bytes, ownership of the bytes
+		// stays with the caller.
+		v.IncRef()
+		id := p.interner.ID(v.Bytes())
+		v.DecRef()
+		return id
+	}
+
 	id := p.pool.Get().(*id)
 	v.IncRef()
 	id.pool, id.data = p, v
@@ -135,6 +150,10 @@ func (p *simplePool) GetStringID(ctx context.Context, v string) ID {
 }
 
 func (p *simplePool) StringID(v string) ID {
+	if p.interner != nil {
+		return p.interner.StringID(v)
+	}
+
 	data := p.bytesPool.Get(len(v))
 	data.IncRef()
 	data.AppendAll([]byte(v))
@@ -161,6 +180,11 @@ func (p *simplePool) Tags() Tags {
 }
 
 func (p *simplePool) Put(v ID) {
+	// Only IDs taken from the pool are returned to it, interned IDs
+	// are owned by the interner.
+	if _, ok := v.(*id); !ok {
+		return
+	}
 	p.pool.Put(v)
 }
 
@@ -193,6 +217,10 @@ func (p *simplePool) StringTag(name string, value string) Tag {
 }
 
 func (p *simplePool) Clone(existing ID) ID {
+	if p.interner != nil {
+		return p.interner.ID(existing.Bytes())
+	}
+
 	var (
 		id      = p.pool.Get().(*id)
 		data    = existing.Bytes()
@@ -0,0 +1,247 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+
+	"github.com/m3db/m3x/instrument"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultInternerSize = 1 << 16
+)
+
+// Interner returns shared IDs for equal values so that frequently seen
+// values such as metric names and tag values are only copied once.
+type Interner interface {
+	// ID returns a shared ID for the bytes, the bytes are copied if the
+	// value is not already interned.
+	ID(data []byte) ID
+
+	// StringID returns a shared ID for the string, the string is copied
+	// if the value is not already interned.
+	StringID(data string) ID
+
+	// Len returns the number of interned values.
+	Len() int
+}
+
+// InternerOptions is a set of interner options.
+type InternerOptions struct {
+	// Size is the maximum number of interned values, once reached values
+	// are evicted using the clock algorithm.
+	Size              int
+	InstrumentOptions instrument.Options
+}
+
+func (o InternerOptions) defaultsIfNotSet() InternerOptions {
+	if o.Size <= 0 {
+		o.Size = defaultInternerSize
+	}
+	if o.InstrumentOptions == nil {
+		o.InstrumentOptions = instrument.NewOptions()
+	}
+	return o
+}
+
+type interner struct {
+	sync.RWMutex
+
+	size    int
+	entries map[string]*internedID
+	clock   []*internedID
+	hand    int
+	metrics internerMetrics
+}
+
+type internerMetrics struct {
+	hits       tally.Counter
+	misses     tally.Counter
+	evictions  tally.Counter
+	rejections tally.Counter
+	entries    tally.Gauge
+}
+
+func newInternerMetrics(scope tally.Scope) internerMetrics {
+	return internerMetrics{
+		hits:       scope.Counter("hits"),
+		misses:     scope.Counter("misses"),
+		evictions:  scope.Counter("evictions"),
+		rejections: scope.Counter("rejections"),
+		entries:    scope.Gauge("entries"),
+	}
+}
+
+// NewInterner returns a new bounded Interner, values are reference counted
+// by finalizing the IDs returned and only values no longer referenced are
+// evicted. Values of IDs that NoFinalize is called on are never evicted.
+func NewInterner(opts InternerOptions) Interner {
+	opts = opts.defaultsIfNotSet()
+	return &interner{
+		size:    opts.Size,
+		entries: make(map[string]*internedID, opts.Size),
+		clock:   make([]*internedID, 0, opts.Size),
+		metrics: newInternerMetrics(opts.InstrumentOptions.MetricsScope()),
+	}
+}
+
+func (i *interner) ID(data []byte) ID {
+	// The map lookup converting the bytes to a string does not allocate.
+	i.RLock()
+	entry, ok := i.entries[string(data)]
+	if ok {
+		entry.acquire()
+	}
+	i.RUnlock()
+
+	if ok {
+		i.metrics.hits.Inc(1)
+		return entry
+	}
+	return i.intern(string(data))
+}
+
+func (i *interner) StringID(data string) ID {
+	i.RLock()
+	entry, ok := i.entries[data]
+	if ok {
+		entry.acquire()
+	}
+	i.RUnlock()
+
+	if ok {
+		i.metrics.hits.Inc(1)
+		return entry
+	}
+	return i.intern(data)
+}
+
+func (i *interner) Len() int {
+	i.RLock()
+	n := len(i.entries)
+	i.RUnlock()
+	return n
+}
+
+func (i *interner) intern(value string) ID {
+	i.Lock()
+	defer i.Unlock()
+
+	if entry, ok := i.entries[value]; ok {
+		entry.acquire()
+		i.metrics.hits.Inc(1)
+		return entry
+	}
+
+	i.metrics.misses.Inc(1)
+
+	entry := &internedID{value: value, data: []byte(value)}
+	if len(i.clock) < i.size {
+		i.clock = append(i.clock, entry)
+	} else if idx, ok := i.evict(); ok {
+		i.clock[idx] = entry
+	} else {
+		// Every value is referenced so return an ID that is not shared.
+		i.metrics.rejections.Inc(1)
+		return BytesID(entry.data)
+	}
+
+	entry.acquire()
+	i.entries[value] = entry
+	i.metrics.entries.Update(float64(len(i.entries)))
+	return entry
+}
+
+// evict evicts an unreferenced value using the clock algorithm, values
+// recently acquired are given a second chance before being evicted. It
+// returns the index of the evicted value in the clock.
+func (i *interner) evict() (int, bool) {
+	for n := 0; n < 2*len(i.clock); n++ {
+		idx := i.hand
+		i.hand = (i.hand + 1) % len(i.clock)
+
+		entry := i.clock[idx]
+		if entry.IsNoFinalize() || atomic.LoadInt32(&entry.refs) > 0 {
+			continue
+		}
+		if atomic.CompareAndSwapInt32(&entry.recent, 1, 0) {
+			continue
+		}
+
+		delete(i.entries, entry.value)
+		i.metrics.evictions.Inc(1)
+		return idx, true
+	}
+	return 0, false
+}
+
+// internedID is an ID shared by all callers interning an equal value,
+// finalizing the ID releases a reference to the value rather than the
+// value itself which is left to the garbage collector once evicted.
+type internedID struct {
+	value      string
+	data       []byte
+	refs       int32
+	recent     int32
+	noFinalize int32
+}
+
+func (v *internedID) acquire() {
+	atomic.AddInt32(&v.refs, 1)
+	atomic.StoreInt32(&v.recent, 1)
+}
+
+func (v *internedID) Bytes() []byte {
+	return v.data
+}
+
+func (v *internedID) Equal(value ID) bool {
+	if other, ok := value.(*internedID); ok && v == other {
+		return true
+	}
+	return bytes.Equal(v.data, value.Bytes())
+}
+
+// NoFinalize pins the value so that it is never evicted, finalizing the ID
+// becomes a no-op for all callers sharing the value.
+func (v *internedID) NoFinalize() {
+	atomic.StoreInt32(&v.noFinalize, 1)
+}
+
+func (v *internedID) IsNoFinalize() bool {
+	return atomic.LoadInt32(&v.noFinalize) == 1
+}
+
+func (v *internedID) Finalize() {
+	if v.IsNoFinalize() {
+		return
+	}
+	atomic.AddInt32(&v.refs, -1)
+}
+
+func (v *internedID) String() string {
+	return v.value
+}
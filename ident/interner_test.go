@@ -0,0 +1,228 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/context"
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/pool"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func newTestInterner(size int) (Interner, tally.TestScope) {
+	scope := tally.NewTestScope("", nil)
+	return NewInterner(InternerOptions{
+		Size:              size,
+		InstrumentOptions: instrument.NewOptions().SetMetricsScope(scope),
+	}), scope
+}
+
+func assertCounter(t *testing.T, scope tally.TestScope, name string, expected int64) {
+	counter, ok := scope.Snapshot().Counters()[name+"+"]
+	require.True(t, ok)
+	assert.Equal(t, expected, counter.Value(), name)
+}
+
+func TestInternerSharesIDs(t *testing.T) {
+	interner, scope := newTestInterner(4)
+
+	a := interner.StringID("foo")
+	b := interner.ID([]byte("foo"))
+	c := interner.StringID("bar")
+
+	assert.True(t, a == b)
+	assert.True(t, a.Equal(b))
+	assert.False(t, a.Equal(c))
+	assert.True(t, a.Equal(StringID("foo")))
+	assert.Equal(t, "foo", a.String())
+	assert.Equal(t, []byte("foo"), b.Bytes())
+	assert.Equal(t, 2, interner.Len())
+
+	assertCounter(t, scope, "hits", 1)
+	assertCounter(t, scope, "misses", 2)
+}
+
+func TestInternerEvictsUnreferenced(t *testing.T) {
+	interner, scope := newTestInterner(2)
+
+	a := interner.StringID("a")
+	b := interner.StringID("b")
+
+	// Both values are referenced so the value is not interned.
+	c := interner.StringID("c")
+	assert.Equal(t, "c", c.String())
+	assert.True(t, c.IsNoFinalize())
+	assert.Equal(t, 2, interner.Len())
+	assertCounter(t, scope, "rejections", 1)
+
+	a.Finalize()
+	b.Finalize()
+
+	c = interner.StringID("c")
+	assert.Equal(t, 2, interner.Len())
+	assertCounter(t, scope, "evictions", 1)
+
+	// The evicted ID remains valid for any caller still holding it.
+	assert.Equal(t, "a", a.String())
+
+	c.Finalize()
+}
+
+func TestInternerClockSecondChance(t *testing.T) {
+	i, _ := newTestInterner(3)
+	for _, value := range []string{"a", "b", "c", "d"} {
+		i.StringID(value).Finalize()
+	}
+
+	// Interning "d" evicted "a" after clearing the recent bit of all
+	// values, acquiring "b" again gives it a second chance over "c".
+	i.StringID("b").Finalize()
+	i.StringID("e").Finalize()
+
+	entries := i.(*interner).entries
+	for _, value := range []string{"b", "d", "e"} {
+		_, ok := entries[value]
+		assert.True(t, ok, value)
+	}
+	assert.Equal(t, 3, len(entries))
+}
+
+func TestInternerNoFinalizePins(t *testing.T) {
+	interner, scope := newTestInterner(1)
+
+	a := interner.StringID("a")
+	a.NoFinalize()
+	a.Finalize()
+
+	shared := interner.StringID("a")
+	assert.True(t, shared.IsNoFinalize())
+	shared.Finalize()
+
+	b := interner.StringID("b")
+	assert.True(t, b.IsNoFinalize())
+	assertCounter(t, scope, "evictions", 0)
+	assertCounter(t, scope, "rejections", 1)
+	assert.Equal(t, "a", a.String())
+}
+
+func TestInternerTagNoFinalize(t *testing.T) {
+	interner, _ := newTestInterner(2)
+	p := NewPool(newTestBytesPool(), PoolOptions{Interner: interner})
+
+	tag := p.StringTag("name", "value")
+	tag.NoFinalize()
+	tag.Finalize()
+
+	assert.True(t, interner.StringID("name").IsNoFinalize())
+	assert.True(t, interner.StringID("value").IsNoFinalize())
+}
+
+func TestInternerPool(t *testing.T) {
+	interner, scope := newTestInterner(16)
+	p := NewPool(newTestBytesPool(), PoolOptions{Interner: interner})
+
+	ctx := context.NewContext()
+	a := p.GetStringID(ctx, "foo")
+	b := p.BinaryID(checked.NewBytes([]byte("foo"), nil))
+	c := p.Clone(a)
+	tag := p.StringTag("foo", "bar")
+
+	assert.True(t, a == b)
+	assert.True(t, a == c)
+	assert.True(t, a == tag.Name)
+	assert.Equal(t, 2, interner.Len())
+	assert.Equal(t, int32(4), a.(*internedID).refs)
+
+	ctx.BlockingClose()
+	b.Finalize()
+	c.Finalize()
+	tag.Finalize()
+	p.PutTag(tag)
+
+	assert.Equal(t, int32(0), a.(*internedID).refs)
+	assert.Equal(t, "foo", a.String())
+	assertCounter(t, scope, "hits", 3)
+}
+
+func TestInternerPoolBinaryIDLeavesBytesWithCaller(t *testing.T) {
+	interner, _ := newTestInterner(16)
+	bytesPool := pool.NewCheckedBytesPool([]pool.Bucket{{Capacity: 16, Count: 1}}, nil,
+		func(s []pool.Bucket) pool.BytesPool {
+			return pool.NewBytesPool(s, nil)
+		})
+	bytesPool.Init()
+	p := NewPool(bytesPool, PoolOptions{Interner: interner})
+
+	v := bytesPool.Get(3)
+	v.IncRef()
+	v.AppendAll([]byte("foo"))
+
+	id := p.BinaryID(v)
+	assert.Equal(t, "foo", id.String())
+	assert.Equal(t, 1, v.NumRef())
+
+	// The interned ID holds a copy so the caller may still modify the bytes.
+	v.Resize(0)
+	v.AppendAll([]byte("bar"))
+	assert.Equal(t, "foo", id.String())
+	assert.Equal(t, []byte("bar"), v.Bytes())
+	v.DecRef()
+}
+
+func TestInternerConcurrent(t *testing.T) {
+	var (
+		interner, _ = newTestInterner(8)
+		wg          sync.WaitGroup
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				value := fmt.Sprintf("value-%d", (i+j)%16)
+				id := interner.StringID(value)
+				assert.Equal(t, value, id.String())
+				id.Finalize()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	assert.True(t, interner.Len() <= 8)
+}
+
+func newTestBytesPool() pool.CheckedBytesPool {
+	bytesPool := pool.NewCheckedBytesPool(nil, nil,
+		func(s []pool.Bucket) pool.BytesPool {
+			return pool.NewBytesPool(s, nil)
+		})
+	bytesPool.Init()
+	return bytesPool
+}
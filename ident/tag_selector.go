@@ -0,0 +1,274 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// maxStackSelectorLeaves is the number of leaf selectors that can be
+	// evaluated without allocating.
+	maxStackSelectorLeaves = 16
+)
+
+// TagSelector matches tags against tag name and value selectors, a tag
+// that is not present is matched as if it had an empty value. Selectors
+// can only be composed with selectors constructed by this package.
+type TagSelector interface {
+	fmt.Stringer
+
+	// Matches returns whether the tags match the selector, the iterator is
+	// duplicated so it is not advanced.
+	Matches(tags TagIterator) bool
+}
+
+type selectorType int
+
+const (
+	equalSelector selectorType = iota
+	regexpSelector
+	setSelector
+	notSelector
+	andSelector
+	orSelector
+)
+
+type tagSelector struct {
+	selectorType selectorType
+	name         []byte
+	value        []byte
+	re           *regexp.Regexp
+	set          map[string]struct{}
+	children     []*tagSelector
+
+	// leaf is the index of the result of a selector on a tag value,
+	// leaves are the selectors on tag values in the whole selector tree.
+	leaf   int
+	leaves []*tagSelector
+}
+
+// NewEqualTagSelector returns a selector matching tags with a value equal
+// to the value provided.
+func NewEqualTagSelector(name, value string) TagSelector {
+	return newLeafTagSelector(&tagSelector{
+		selectorType: equalSelector,
+		name:         []byte(name),
+		value:        []byte(value),
+	})
+}
+
+// NewNotEqualTagSelector returns a selector matching tags with a value
+// not equal to the value provided.
+func NewNotEqualTagSelector(name, value string) TagSelector {
+	return NewNotTagSelector(NewEqualTagSelector(name, value))
+}
+
+// NewRegexpTagSelector returns a selector matching tags with a value that
+// fully matches the regular expression provided.
+func NewRegexpTagSelector(name, pattern string) (TagSelector, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	return newLeafTagSelector(&tagSelector{
+		selectorType: regexpSelector,
+		name:         []byte(name),
+		value:        []byte(pattern),
+		re:           re,
+	}), nil
+}
+
+// NewSetTagSelector returns a selector matching tags with a value that is
+// one of the values provided.
+func NewSetTagSelector(name string, values ...string) TagSelector {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return newLeafTagSelector(&tagSelector{
+		selectorType: setSelector,
+		name:         []byte(name),
+		set:          set,
+	})
+}
+
+// NewNotTagSelector returns a selector matching tags not matched by the
+// selector provided.
+func NewNotTagSelector(selector TagSelector) TagSelector {
+	return newParentTagSelector(notSelector, selector)
+}
+
+// NewAndTagSelector returns a selector matching tags matched by all of
+// the selectors provided.
+func NewAndTagSelector(selectors ...TagSelector) TagSelector {
+	return newParentTagSelector(andSelector, selectors...)
+}
+
+// NewOrTagSelector returns a selector matching tags matched by any of the
+// selectors provided.
+func NewOrTagSelector(selectors ...TagSelector) TagSelector {
+	return newParentTagSelector(orSelector, selectors...)
+}
+
+func newLeafTagSelector(s *tagSelector) *tagSelector {
+	s.leaves = []*tagSelector{s}
+	return s
+}
+
+func newParentTagSelector(t selectorType, selectors ...TagSelector) *tagSelector {
+	s := &tagSelector{selectorType: t}
+	for _, selector := range selectors {
+		// Copy children so that selectors shared between trees are
+		// not renumbered.
+		s.children = append(s.children, selector.(*tagSelector).clone())
+	}
+	s.leaves = s.appendLeaves(nil)
+	for i, leaf := range s.leaves {
+		leaf.leaf = i
+	}
+	return s
+}
+
+func (s *tagSelector) clone() *tagSelector {
+	c := *s
+	c.leaves = nil
+	c.children = make([]*tagSelector, 0, len(s.children))
+	for _, child := range s.children {
+		c.children = append(c.children, child.clone())
+	}
+	return &c
+}
+
+func (s *tagSelector) appendLeaves(leaves []*tagSelector) []*tagSelector {
+	if s.isLeaf() {
+		return append(leaves, s)
+	}
+	for _, child := range s.children {
+		leaves = child.appendLeaves(leaves)
+	}
+	return leaves
+}
+
+func (s *tagSelector) isLeaf() bool {
+	return s.selectorType < notSelector
+}
+
+func (s *tagSelector) Matches(tags TagIterator) bool {
+	var (
+		foundArr   [maxStackSelectorLeaves]bool
+		matchedArr [maxStackSelectorLeaves]bool
+		found      = foundArr[:]
+		matched    = matchedArr[:]
+	)
+	if len(s.leaves) > maxStackSelectorLeaves {
+		found = make([]bool, len(s.leaves))
+		matched = make([]bool, len(s.leaves))
+	}
+
+	// Evaluate all leaves in a single pass over the tags.
+	iter := tags.Duplicate()
+	for iter.Next() {
+		tag := iter.Current()
+		name := tag.Name.Bytes()
+		for i, leaf := range s.leaves {
+			if bytes.Equal(leaf.name, name) {
+				found[i] = true
+				matched[i] = leaf.matchValue(tag.Value.Bytes())
+			}
+		}
+	}
+	iter.Close()
+
+	for i, leaf := range s.leaves {
+		if !found[i] {
+			matched[i] = leaf.matchValue(nil)
+		}
+	}
+	return s.eval(matched)
+}
+
+func (s *tagSelector) matchValue(value []byte) bool {
+	switch s.selectorType {
+	case equalSelector:
+		return bytes.Equal(s.value, value)
+	case regexpSelector:
+		return s.re.Match(value)
+	case setSelector:
+		_, ok := s.set[string(value)]
+		return ok
+	}
+	return false
+}
+
+func (s *tagSelector) eval(matched []bool) bool {
+	switch s.selectorType {
+	case notSelector:
+		return !s.children[0].eval(matched)
+	case andSelector:
+		for _, child := range s.children {
+			if !child.eval(matched) {
+				return false
+			}
+		}
+		return true
+	case orSelector:
+		for _, child := range s.children {
+			if child.eval(matched) {
+				return true
+			}
+		}
+		return false
+	}
+	return matched[s.leaf]
+}
+
+func (s *tagSelector) String() string {
+	switch s.selectorType {
+	case equalSelector:
+		return fmt.Sprintf("%s=%q", s.name, s.value)
+	case regexpSelector:
+		return fmt.Sprintf("%s=~%q", s.name, s.value)
+	case setSelector:
+		values := make([]string, 0, len(s.set))
+		for v := range s.set {
+			values = append(values, fmt.Sprintf("%q", v))
+		}
+		sort.Strings(values)
+		return fmt.Sprintf("%s in (%s)", s.name, strings.Join(values, ", "))
+	}
+
+	children := make([]string, 0, len(s.children))
+	for _, child := range s.children {
+		children = append(children, child.String())
+	}
+	switch s.selectorType {
+	case notSelector:
+		return fmt.Sprintf("not(%s)", children[0])
+	case andSelector:
+		return fmt.Sprintf("and(%s)", strings.Join(children, ", "))
+	}
+	return fmt.Sprintf("or(%s)", strings.Join(children, ", "))
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagSelectors(t *testing.T) {
+	re, err := NewRegexpTagSelector("service", "m3.*")
+	require.NoError(t, err)
+	reEmpty, err := NewRegexpTagSelector("missing", ".*")
+	require.NoError(t, err)
+
+	tests := []struct {
+		selector TagSelector
+		matches  bool
+	}{
+		{selector: NewEqualTagSelector("env", "prod"), matches: true},
+		{selector: NewEqualTagSelector("env", "dev"), matches: false},
+		{selector: NewEqualTagSelector("missing", ""), matches: true},
+		{selector: NewNotEqualTagSelector("env", "dev"), matches: true},
+		{selector: NewNotEqualTagSelector("missing", "x"), matches: true},
+		{selector: re, matches: true},
+		{selector: reEmpty, matches: true},
+		{selector: NewSetTagSelector("dc", "sjc1", "dca1"), matches: true},
+		{selector: NewSetTagSelector("dc", "phx3"), matches: false},
+		{selector: NewAndTagSelector(
+			NewEqualTagSelector("env", "prod"),
+			re,
+			NewNotTagSelector(NewSetTagSelector("dc", "phx3")),
+		), matches: true},
+		{selector: NewAndTagSelector(
+			NewEqualTagSelector("env", "prod"),
+			NewEqualTagSelector("dc", "phx3"),
+		), matches: false},
+		{selector: NewOrTagSelector(
+			NewEqualTagSelector("env", "dev"),
+			NewEqualTagSelector("dc", "dca1"),
+		), matches: true},
+		{selector: NewOrTagSelector(
+			NewEqualTagSelector("env", "dev"),
+			NewAndTagSelector(re, NewEqualTagSelector("dc", "phx3")),
+		), matches: false},
+		{selector: NewAndTagSelector(), matches: true},
+		{selector: NewOrTagSelector(), matches: false},
+	}
+
+	for _, test := range tests {
+		tags := MustNewTagStringsIterator("dc", "dca1", "env", "prod", "service", "m3db")
+		assert.Equal(t, test.matches, test.selector.Matches(tags), test.selector.String())
+		assert.Equal(t, 3, tags.Remaining())
+	}
+}
+
+func TestTagSelectorRegexpAnchored(t *testing.T) {
+	re, err := NewRegexpTagSelector("service", "m3")
+	require.NoError(t, err)
+	assert.False(t, re.Matches(MustNewTagStringsIterator("service", "m3db")))
+	assert.True(t, re.Matches(MustNewTagStringsIterator("service", "m3")))
+
+	_, err = NewRegexpTagSelector("service", "(")
+	assert.Error(t, err)
+}
+
+func TestTagSelectorSharedChildren(t *testing.T) {
+	var (
+		env = NewEqualTagSelector("env", "prod")
+		dc  = NewEqualTagSelector("dc", "dca1")
+		a   = NewAndTagSelector(dc, env)
+		b   = NewOrTagSelector(NewNotTagSelector(env), a)
+	)
+
+	tags := MustNewTagStringsIterator("dc", "dca1", "env", "prod")
+	assert.True(t, env.Matches(tags))
+	assert.True(t, a.Matches(tags))
+	assert.True(t, b.Matches(tags))
+}
+
+func TestTagSelectorManyLeaves(t *testing.T) {
+	var selectors []TagSelector
+	for i := 0; i < 2*maxStackSelectorLeaves; i++ {
+		selectors = append(selectors, NewNotEqualTagSelector("env", "dev"))
+	}
+	selectors = append(selectors, NewEqualTagSelector("env", "prod"))
+
+	tags := MustNewTagStringsIterator("env", "prod")
+	assert.True(t, NewAndTagSelector(selectors...).Matches(tags))
+}
+
+func TestTagSelectorString(t *testing.T) {
+	re, err := NewRegexpTagSelector("service", "m3.*")
+	require.NoError(t, err)
+	s := NewOrTagSelector(
+		NewAndTagSelector(NewEqualTagSelector("env", "prod"), re),
+		NewNotTagSelector(NewSetTagSelector("dc", "sjc1", "dca1")),
+	)
+	assert.Equal(t,
+		`or(and(env="prod", service=~"m3.*"), not(dc in ("dca1", "sjc1")))`,
+		s.String())
+}
+
+func newBenchmarkTags() TagIterator {
+	return MustNewTagStringsIterator(
+		"__name__", "http_requests_total",
+		"dc", "dca1",
+		"env", "prod",
+		"host", "host-123",
+		"method", "GET",
+		"path", "/api/v1/query",
+		"service", "m3query",
+		"status", "200",
+	)
+}
+
+func BenchmarkTagSelectorEqual(b *testing.B) {
+	var (
+		tags     = newBenchmarkTags()
+		selector = NewEqualTagSelector("service", "m3query")
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !selector.Matches(tags) {
+			b.Fatal("expected match")
+		}
+	}
+}
+
+func BenchmarkTagSelectorComposite(b *testing.B) {
+	re, err := NewRegexpTagSelector("path", "/api/v1/.*")
+	require.NoError(b, err)
+
+	var (
+		tags     = newBenchmarkTags()
+		selector = NewAndTagSelector(
+			NewEqualTagSelector("env", "prod"),
+			NewSetTagSelector("method", "GET", "POST"),
+			re,
+			NewOrTagSelector(
+				NewNotEqualTagSelector("status", "500"),
+				NewEqualTagSelector("dc", "sjc1"),
+			),
+		)
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !selector.Matches(tags) {
+			b.Fatal("expected match")
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	errEmptyTagName = errors.New("tag name cannot be empty")
+)
+
+// SortTags sorts tags in place in ascending order of name and then value.
+func SortTags(tags Tags) {
+	sort.Sort(tagsByName(tags.Values()))
+}
+
+// IsSortedTags returns whether tags are in ascending order of name and
+// then value.
+func IsSortedTags(tags Tags) bool {
+	return sort.IsSorted(tagsByName(tags.Values()))
+}
+
+type tagsByName []Tag
+
+func (t tagsByName) Len() int      { return len(t) }
+func (t tagsByName) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t tagsByName) Less(i, j int) bool {
+	return compareTags(t[i], t[j]) < 0
+}
+
+func compareTags(a, b Tag) int {
+	if c := bytes.Compare(a.Name.Bytes(), b.Name.Bytes()); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.Value.Bytes(), b.Value.Bytes())
+}
+
+// ValidateTags validates that tag names are unique and that tags only use
+// allowed characters. Tag names must be non-empty and consist of ASCII
+// letters, digits, '_', '-', '.' and ':'. Tag values must not contain
+// control characters or any of ',', '=', '{' and '}' so that canonical IDs
+// built from the tags are unambiguous.
+func ValidateTags(tags Tags) error {
+	values := tags.Values()
+	sorted := IsSortedTags(tags)
+	for i, tag := range values {
+		name := tag.Name.Bytes()
+		if err := validateTagName(name); err != nil {
+			return err
+		}
+		if err := validateTagValue(name, tag.Value.Bytes()); err != nil {
+			return err
+		}
+
+		// Duplicates are adjacent when sorted, otherwise compare with
+		// all previous names as tag sets are typically small.
+		prev := values[:i]
+		if sorted && i > 0 {
+			prev = values[i-1 : i]
+		}
+		for _, other := range prev {
+			if bytes.Equal(other.Name.Bytes(), name) {
+				return fmt.Errorf("duplicate tag name: %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateTagName(name []byte) error {
+	if len(name) == 0 {
+		return errEmptyTagName
+	}
+	for _, c := range name {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(c >= '0' && c <= '9') || c == '_' || c == '-' || c == '.' || c == ':'
+		if !valid {
+			return fmt.Errorf("invalid character %q in tag name: %s", c, name)
+		}
+	}
+	return nil
+}
+
+func validateTagValue(name, value []byte) error {
+	for _, c := range value {
+		invalid := c < 0x20 || c == 0x7f ||
+			c == ',' || c == '=' || c == '{' || c == '}'
+		if invalid {
+			return fmt.Errorf("invalid character %q in value of tag: %s", c, name)
+		}
+	}
+	return nil
+}
+
+// NewMergedTagIterator returns an iterator that merges two iterators over
+// sorted tags in sorted order, tags with equal names from both iterators
+// are both returned with the tag from the first iterator first. The merged
+// iterator takes ownership of both iterators and closes them when closed.
+func NewMergedTagIterator(a, b TagIterator) TagIterator {
+	return &mergedTagIter{a: a, b: b}
+}
+
+type mergedTagIter struct {
+	a, b TagIterator
+
+	// aPending and bPending are whether the current tag of each iterator
+	// has been read but not yet returned by the merged iterator.
+	aPending bool
+	bPending bool
+	aDone    bool
+	bDone    bool
+	current  Tag
+	idx      int
+	err      error
+}
+
+func (i *mergedTagIter) Next() bool {
+	if i.err != nil {
+		return false
+	}
+
+	if !i.aPending && !i.aDone {
+		i.aPending = i.a.Next()
+		i.aDone = !i.aPending
+	}
+	if !i.bPending && !i.bDone {
+		i.bPending = i.b.Next()
+		i.bDone = !i.bPending
+	}
+	if err := i.a.Err(); err != nil {
+		i.err = err
+	} else if err := i.b.Err(); err != nil {
+		i.err = err
+	}
+	if i.err != nil {
+		i.current = Tag{}
+		return false
+	}
+
+	switch {
+	case i.aPending && i.bPending:
+		if compareTags(i.b.Current(), i.a.Current()) < 0 {
+			i.current, i.bPending = i.b.Current(), false
+		} else {
+			i.current, i.aPending = i.a.Current(), false
+		}
+	case i.aPending:
+		i.current, i.aPending = i.a.Current(), false
+	case i.bPending:
+		i.current, i.bPending = i.b.Current(), false
+	default:
+		i.current = Tag{}
+		return false
+	}
+
+	i.idx++
+	return true
+}
+
+func (i *mergedTagIter) Current() Tag {
+	return i.current
+}
+
+func (i *mergedTagIter) CurrentIndex() int {
+	if i.idx > 0 {
+		return i.idx - 1
+	}
+	return 0
+}
+
+func (i *mergedTagIter) Err() error {
+	return i.err
+}
+
+func (i *mergedTagIter) Close() {
+	i.a.Close()
+	i.b.Close()
+	i.current = Tag{}
+}
+
+func (i *mergedTagIter) Len() int {
+	return i.a.Len() + i.b.Len()
+}
+
+func (i *mergedTagIter) Remaining() int {
+	remaining := i.a.Remaining() + i.b.Remaining()
+	if i.aPending {
+		remaining++
+	}
+	if i.bPending {
+		remaining++
+	}
+	return remaining
+}
+
+func (i *mergedTagIter) Duplicate() TagIterator {
+	dupe := *i
+	dupe.a = i.a.Duplicate()
+	dupe.b = i.b.Duplicate()
+	return &dupe
+}
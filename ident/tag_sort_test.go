@@ -0,0 +1,136 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ident
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTags(inputs ...string) Tags {
+	tags := NewTags()
+	for i := 0; i < len(inputs); i += 2 {
+		tags.Append(StringTag(inputs[i], inputs[i+1]))
+	}
+	return tags
+}
+
+func tagStrings(iter TagIterator) []string {
+	var result []string
+	for iter.Next() {
+		tag := iter.Current()
+		result = append(result, tag.Name.String(), tag.Value.String())
+	}
+	return result
+}
+
+func TestSortTags(t *testing.T) {
+	tags := newTestTags("c", "1", "a", "2", "b", "3", "a", "1")
+	assert.False(t, IsSortedTags(tags))
+
+	SortTags(tags)
+	assert.True(t, IsSortedTags(tags))
+	assert.Equal(t, []string{"a", "1", "a", "2", "b", "3", "c", "1"},
+		tagStrings(NewTagsIterator(tags)))
+}
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		tags  []string
+		valid bool
+	}{
+		{tags: nil, valid: true},
+		{tags: []string{"a", "1", "b_c-d.e:f", "g h/é"}, valid: true},
+		{tags: []string{"b", "1", "a", ""}, valid: true},
+		{tags: []string{"", "1"}},
+		{tags: []string{"a b", "1"}},
+		{tags: []string{"a{", "1"}},
+		{tags: []string{"a", "1,"}},
+		{tags: []string{"a", "x=y"}},
+		{tags: []string{"a", "}"}},
+		{tags: []string{"a", "\n"}},
+		{tags: []string{"a", "1", "b", "2", "a", "3"}},
+		{tags: []string{"a", "1", "a", "2"}},
+	}
+
+	for _, test := range tests {
+		err := ValidateTags(newTestTags(test.tags...))
+		if test.valid {
+			assert.NoError(t, err, "%v", test.tags)
+		} else {
+			assert.Error(t, err, "%v", test.tags)
+		}
+	}
+}
+
+func TestMergedTagIterator(t *testing.T) {
+	iter := NewMergedTagIterator(
+		MustNewTagStringsIterator("a", "1", "c", "3", "e", "5"),
+		MustNewTagStringsIterator("b", "2", "c", "4", "f", "6", "g", "7"),
+	)
+	assert.Equal(t, 7, iter.Len())
+	assert.Equal(t, 7, iter.Remaining())
+
+	require.True(t, iter.Next())
+	assert.Equal(t, "a", iter.Current().Name.String())
+	assert.Equal(t, 0, iter.CurrentIndex())
+	assert.Equal(t, 6, iter.Remaining())
+
+	dupe := iter.Duplicate()
+	assert.Equal(t, []string{"b", "2", "c", "3", "c", "4", "e", "5", "f", "6", "g", "7"},
+		tagStrings(iter))
+	assert.Equal(t, 0, iter.Remaining())
+	assert.NoError(t, iter.Err())
+	iter.Close()
+
+	assert.Equal(t, "a", dupe.Current().Name.String())
+	assert.Equal(t, []string{"b", "2", "c", "3", "c", "4", "e", "5", "f", "6", "g", "7"},
+		tagStrings(dupe))
+	dupe.Close()
+}
+
+func TestMergedTagIteratorEmpty(t *testing.T) {
+	iter := NewMergedTagIterator(EmptyTagIterator,
+		MustNewTagStringsIterator("a", "1"))
+	assert.Equal(t, []string{"a", "1"}, tagStrings(iter))
+
+	iter = NewMergedTagIterator(EmptyTagIterator, EmptyTagIterator)
+	assert.False(t, iter.Next())
+	assert.Equal(t, 0, iter.Len())
+}
+
+func TestMergedTagIteratorErr(t *testing.T) {
+	err := errors.New("iterator error")
+	iter := NewMergedTagIterator(MustNewTagStringsIterator("a", "1"),
+		&errTagIter{TagIterator: EmptyTagIterator, err: err})
+	assert.False(t, iter.Next())
+	assert.Equal(t, err, iter.Err())
+}
+
+type errTagIter struct {
+	TagIterator
+	err error
+}
+
+func (i *errTagIter) Err() error { return i.err }
@@ -40,6 +40,14 @@ var (
 	errReporterReportIntervalInvalid   = errors.New("reporter report interval is invalid")
 )
 
+// NewReporter returns a new reporter that calls the report function every
+// report interval once started.
+func NewReporter(reportInterval time.Duration, report func()) Reporter {
+	r := new(baseReporter)
+	r.init(reportInterval, report)
+	return r
+}
+
 type baseReporter struct {
 	sync.Mutex
 
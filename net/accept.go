@@ -0,0 +1,351 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/retry"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultAcceptInitialBackoff = 5 * time.Millisecond
+	defaultAcceptMaxBackoff     = time.Second
+
+	defaultFileDescriptorExhaustionInitialBackoff = 100 * time.Millisecond
+	defaultFileDescriptorExhaustionMaxBackoff     = 5 * time.Second
+)
+
+// AcceptErrorClass is the class of an error returned by a listener's Accept.
+type AcceptErrorClass int
+
+const (
+	// FatalAcceptError is an error that ends the accept loop.
+	FatalAcceptError AcceptErrorClass = iota
+
+	// TemporaryAcceptError is an error that is retried with the retry options.
+	TemporaryAcceptError
+
+	// FileDescriptorExhaustionAcceptError is an error caused by running out of
+	// file descriptors, it is retried with the file descriptor exhaustion
+	// retry options which usually back off for longer to give connections
+	// a chance to be closed.
+	FileDescriptorExhaustionAcceptError
+)
+
+// AcceptErrorClassifier classifies an error returned by a listener's Accept,
+// returning the class of the error and a short type used to tag metrics.
+type AcceptErrorClassifier func(err error) (AcceptErrorClass, string)
+
+// DefaultAcceptErrorClassifier classifies EMFILE and ENFILE errors as file
+// descriptor exhaustion, other temporary network errors as temporary and all
+// other errors as fatal.
+func DefaultAcceptErrorClassifier(err error) (AcceptErrorClass, string) {
+	if errno, ok := acceptErrno(err); ok {
+		switch errno {
+		case syscall.EMFILE:
+			return FileDescriptorExhaustionAcceptError, "emfile"
+		case syscall.ENFILE:
+			return FileDescriptorExhaustionAcceptError, "enfile"
+		case syscall.ECONNABORTED:
+			return TemporaryAcceptError, "econnaborted"
+		case syscall.ECONNRESET:
+			return TemporaryAcceptError, "econnreset"
+		case syscall.EINTR:
+			return TemporaryAcceptError, "eintr"
+		}
+	}
+	ne, ok := err.(net.Error)
+	if !ok {
+		return FatalAcceptError, "fatal"
+	}
+	if ne.Timeout() {
+		return TemporaryAcceptError, "timeout"
+	}
+	if ne.Temporary() {
+		return TemporaryAcceptError, "temporary"
+	}
+	return FatalAcceptError, "fatal"
+}
+
+func acceptErrno(err error) (syscall.Errno, bool) {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	errno, ok := err.(syscall.Errno)
+	return errno, ok
+}
+
+// AcceptLoopOptions provide a set of accept loop options.
+type AcceptLoopOptions interface {
+	// SetInstrumentOptions sets the instrument options.
+	SetInstrumentOptions(value instrument.Options) AcceptLoopOptions
+
+	// InstrumentOptions returns the instrument options.
+	InstrumentOptions() instrument.Options
+
+	// SetRetryOptions sets the retry options for temporary errors, the
+	// accept loop ends once the retries are exhausted.
+	SetRetryOptions(value retry.Options) AcceptLoopOptions
+
+	// RetryOptions returns the retry options for temporary errors.
+	RetryOptions() retry.Options
+
+	// SetFileDescriptorExhaustionRetryOptions sets the retry options for
+	// file descriptor exhaustion errors, the accept loop ends once the
+	// retries are exhausted.
+	SetFileDescriptorExhaustionRetryOptions(value retry.Options) AcceptLoopOptions
+
+	// FileDescriptorExhaustionRetryOptions returns the retry options for
+	// file descriptor exhaustion errors.
+	FileDescriptorExhaustionRetryOptions() retry.Options
+
+	// SetErrorClassifier sets the classifier of accept errors.
+	SetErrorClassifier(value AcceptErrorClassifier) AcceptLoopOptions
+
+	// ErrorClassifier returns the classifier of accept errors.
+	ErrorClassifier() AcceptErrorClassifier
+}
+
+type acceptLoopOptions struct {
+	instrumentOpts        instrument.Options
+	retryOpts             retry.Options
+	fdExhaustionRetryOpts retry.Options
+	errorClassifier       AcceptErrorClassifier
+}
+
+// NewAcceptLoopOptions creates a new set of accept loop options.
+func NewAcceptLoopOptions() AcceptLoopOptions {
+	return &acceptLoopOptions{
+		instrumentOpts: instrument.NewOptions(),
+		retryOpts: retry.NewOptions().
+			SetInitialBackoff(defaultAcceptInitialBackoff).
+			SetMaxBackoff(defaultAcceptMaxBackoff).
+			SetForever(true),
+		fdExhaustionRetryOpts: retry.NewOptions().
+			SetInitialBackoff(defaultFileDescriptorExhaustionInitialBackoff).
+			SetMaxBackoff(defaultFileDescriptorExhaustionMaxBackoff).
+			SetForever(true),
+		errorClassifier: DefaultAcceptErrorClassifier,
+	}
+}
+
+func (o *acceptLoopOptions) SetInstrumentOptions(value instrument.Options) AcceptLoopOptions {
+	opts := *o
+	opts.instrumentOpts = value
+	return &opts
+}
+
+func (o *acceptLoopOptions) InstrumentOptions() instrument.Options {
+	return o.instrumentOpts
+}
+
+func (o *acceptLoopOptions) SetRetryOptions(value retry.Options) AcceptLoopOptions {
+	opts := *o
+	opts.retryOpts = value
+	return &opts
+}
+
+func (o *acceptLoopOptions) RetryOptions() retry.Options {
+	return o.retryOpts
+}
+
+func (o *acceptLoopOptions) SetFileDescriptorExhaustionRetryOptions(value retry.Options) AcceptLoopOptions {
+	opts := *o
+	opts.fdExhaustionRetryOpts = value
+	return &opts
+}
+
+func (o *acceptLoopOptions) FileDescriptorExhaustionRetryOptions() retry.Options {
+	return o.fdExhaustionRetryOpts
+}
+
+func (o *acceptLoopOptions) SetErrorClassifier(value AcceptErrorClassifier) AcceptLoopOptions {
+	opts := *o
+	opts.errorClassifier = value
+	return &opts
+}
+
+func (o *acceptLoopOptions) ErrorClassifier() AcceptErrorClassifier {
+	return o.errorClassifier
+}
+
+type acceptLoopMetrics struct {
+	scope                    tally.Scope
+	acceptedConnections      tally.Counter
+	fileDescriptorExhaustion tally.Counter
+	acceptErrors             map[string]tally.Counter
+}
+
+func newAcceptLoopMetrics(scope tally.Scope) *acceptLoopMetrics {
+	return &acceptLoopMetrics{
+		scope:                    scope,
+		acceptedConnections:      scope.Counter("accepted-connections"),
+		fileDescriptorExhaustion: scope.Counter("file-descriptor-exhaustion"),
+		acceptErrors:             make(map[string]tally.Counter),
+	}
+}
+
+// acceptError returns the counter of accept errors of the given type, it is
+// only called from the accept loop goroutine.
+func (m *acceptLoopMetrics) acceptError(errType string) tally.Counter {
+	counter, ok := m.acceptErrors[errType]
+	if !ok {
+		counter = m.scope.Tagged(map[string]string{"error-type": errType}).Counter("accept-errors")
+		m.acceptErrors[errType] = counter
+	}
+	return counter
+}
+
+// StartAcceptLoopWithContext starts an accept loop for the given listener,
+// returning accepted connections via a channel while retrying errors the
+// error classifier deems retryable. The loop ends once the context is
+// cancelled or on a fatal error, which is returned via the error channel
+// with the listener closed on return.
+func StartAcceptLoopWithContext(
+	ctx context.Context,
+	l net.Listener,
+	opts AcceptLoopOptions,
+) (<-chan net.Conn, <-chan error) {
+	if opts == nil {
+		opts = NewAcceptLoopOptions()
+	}
+	var (
+		connCh = make(chan net.Conn)
+		// NB: the error channel is buffered so that the loop can end even
+		// if the caller has stopped listening after cancelling the context.
+		errCh = make(chan error, 1)
+		loop  = &acceptLoop{
+			ctx:                   ctx,
+			listener:              l,
+			retryOpts:             opts.RetryOptions(),
+			fdExhaustionRetryOpts: opts.FileDescriptorExhaustionRetryOptions(),
+			errorClassifier:       opts.ErrorClassifier(),
+			metrics:               newAcceptLoopMetrics(opts.InstrumentOptions().MetricsScope()),
+		}
+	)
+
+	go func() {
+		err := loop.run(connCh)
+		close(connCh)
+		errCh <- err
+		close(errCh)
+	}()
+
+	return connCh, errCh
+}
+
+type acceptLoop struct {
+	ctx                   context.Context
+	listener              net.Listener
+	retryOpts             retry.Options
+	fdExhaustionRetryOpts retry.Options
+	errorClassifier       AcceptErrorClassifier
+	metrics               *acceptLoopMetrics
+}
+
+func (a *acceptLoop) run(connCh chan<- net.Conn) error {
+	defer a.listener.Close()
+
+	// Close the listener once the context is cancelled to unblock Accept.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-a.ctx.Done():
+			a.listener.Close()
+		case <-done:
+		}
+	}()
+
+	retries := 0
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			if ctxErr := a.ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			retries++
+			if err := a.backoff(err, retries); err != nil {
+				return err
+			}
+			continue
+		}
+
+		retries = 0
+		a.metrics.acceptedConnections.Inc(1)
+		select {
+		case connCh <- conn:
+		case <-a.ctx.Done():
+			conn.Close()
+			return a.ctx.Err()
+		}
+	}
+}
+
+// backoff waits before retrying the given accept error, it returns an error
+// if the accept error is fatal, the retries are exhausted or the context is
+// cancelled while waiting.
+func (a *acceptLoop) backoff(err error, retries int) error {
+	class, errType := a.errorClassifier(err)
+	a.metrics.acceptError(errType).Inc(1)
+
+	var retryOpts retry.Options
+	switch class {
+	case TemporaryAcceptError:
+		retryOpts = a.retryOpts
+	case FileDescriptorExhaustionAcceptError:
+		a.metrics.fileDescriptorExhaustion.Inc(1)
+		retryOpts = a.fdExhaustionRetryOpts
+	default:
+		return err
+	}
+	if !retryOpts.Forever() && retries > retryOpts.MaxRetries() {
+		return err
+	}
+
+	backoff := time.Duration(retry.BackoffNanos(
+		retries,
+		retryOpts.Jitter(),
+		retryOpts.BackoffFactor(),
+		retryOpts.InitialBackoff(),
+		retryOpts.MaxBackoff(),
+		retryOpts.RngFn(),
+	))
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-a.ctx.Done():
+		return a.ctx.Err()
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/retry"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// erroringListener returns the given errors from Accept before accepting
+// from the underlying listener.
+type erroringListener struct {
+	net.Listener
+
+	sync.Mutex
+	errs []error
+}
+
+func (l *erroringListener) Accept() (net.Conn, error) {
+	l.Lock()
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		l.Unlock()
+		return nil, err
+	}
+	l.Unlock()
+	return l.Listener.Accept()
+}
+
+func acceptSyscallError(errno syscall.Errno) error {
+	return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", errno)}
+}
+
+func testAcceptLoopOptions(scope tally.Scope) AcceptLoopOptions {
+	retryOpts := retry.NewOptions().
+		SetInitialBackoff(time.Millisecond).
+		SetMaxBackoff(time.Millisecond).
+		SetForever(true)
+	return NewAcceptLoopOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetRetryOptions(retryOpts).
+		SetFileDescriptorExhaustionRetryOptions(retryOpts)
+}
+
+func TestDefaultAcceptErrorClassifier(t *testing.T) {
+	tests := []struct {
+		err     error
+		class   AcceptErrorClass
+		errType string
+	}{
+		{acceptSyscallError(syscall.EMFILE), FileDescriptorExhaustionAcceptError, "emfile"},
+		{acceptSyscallError(syscall.ENFILE), FileDescriptorExhaustionAcceptError, "enfile"},
+		{acceptSyscallError(syscall.ECONNABORTED), TemporaryAcceptError, "econnaborted"},
+		{syscall.ECONNRESET, TemporaryAcceptError, "econnreset"},
+		{temporaryError{}, TemporaryAcceptError, "temporary"},
+		{errors.New("foo"), FatalAcceptError, "fatal"},
+	}
+	for _, test := range tests {
+		class, errType := DefaultAcceptErrorClassifier(test.err)
+		require.Equal(t, test.class, class, test.err.Error())
+		require.Equal(t, test.errType, errType, test.err.Error())
+	}
+}
+
+func TestAcceptLoopWithContextRetriesAndReportsErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	el := &erroringListener{
+		Listener: l,
+		errs: []error{
+			acceptSyscallError(syscall.EMFILE),
+			acceptSyscallError(syscall.EMFILE),
+			acceptSyscallError(syscall.ENFILE),
+			temporaryError{},
+		},
+	}
+
+	scope := tally.NewTestScope("", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connCh, errCh := StartAcceptLoopWithContext(ctx, el, testAcceptLoopOptions(scope))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	accepted := <-connCh
+	require.NotNil(t, accepted)
+	accepted.Close()
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["accepted-connections+"].Value())
+	require.Equal(t, int64(3), counters["file-descriptor-exhaustion+"].Value())
+	require.Equal(t, int64(2), counters["accept-errors+error-type=emfile"].Value())
+	require.Equal(t, int64(1), counters["accept-errors+error-type=enfile"].Value())
+	require.Equal(t, int64(1), counters["accept-errors+error-type=temporary"].Value())
+
+	cancel()
+	_, ok := <-connCh
+	require.False(t, ok)
+	require.Equal(t, context.Canceled, <-errCh)
+
+	// The listener is closed once the loop ends.
+	_, err = l.Accept()
+	require.Error(t, err)
+}
+
+func TestAcceptLoopWithContextFatalError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fatalErr := errors.New("fatal")
+	el := &erroringListener{Listener: l, errs: []error{fatalErr}}
+
+	scope := tally.NewTestScope("", nil)
+	connCh, errCh := StartAcceptLoopWithContext(context.Background(), el, testAcceptLoopOptions(scope))
+
+	_, ok := <-connCh
+	require.False(t, ok)
+	require.Equal(t, fatalErr, <-errCh)
+	require.Equal(t, int64(1), scope.Snapshot().Counters()["accept-errors+error-type=fatal"].Value())
+}
+
+func TestAcceptLoopWithContextRetriesExhausted(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	el := &erroringListener{
+		Listener: l,
+		errs:     []error{temporaryError{}, temporaryError{}, temporaryError{}},
+	}
+
+	opts := testAcceptLoopOptions(tally.NoopScope)
+	opts = opts.SetRetryOptions(opts.RetryOptions().SetForever(false).SetMaxRetries(2))
+	connCh, errCh := StartAcceptLoopWithContext(context.Background(), el, opts)
+
+	_, ok := <-connCh
+	require.False(t, ok)
+	require.Equal(t, temporaryError{}, <-errCh)
+}
+
+func TestAcceptLoopWithContextCustomClassifier(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	customErr := errors.New("custom")
+	el := &erroringListener{Listener: l, errs: []error{customErr}}
+
+	scope := tally.NewTestScope("", nil)
+	opts := testAcceptLoopOptions(scope).SetErrorClassifier(func(err error) (AcceptErrorClass, string) {
+		if err == customErr {
+			return TemporaryAcceptError, "custom"
+		}
+		return DefaultAcceptErrorClassifier(err)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	connCh, errCh := StartAcceptLoopWithContext(ctx, el, opts)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	accepted := <-connCh
+	accepted.Close()
+	require.Equal(t, int64(1), scope.Snapshot().Counters()["accept-errors+error-type=custom"].Value())
+
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+}
+
+func TestAcceptLoopWithContextCancelDuringBackoff(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	el := &erroringListener{Listener: l, errs: []error{acceptSyscallError(syscall.EMFILE)}}
+
+	opts := testAcceptLoopOptions(tally.NoopScope).
+		SetFileDescriptorExhaustionRetryOptions(retry.NewOptions().
+			SetInitialBackoff(time.Hour).
+			SetForever(true))
+	ctx, cancel := context.WithCancel(context.Background())
+	_, errCh := StartAcceptLoopWithContext(ctx, el, opts)
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		require.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "accept loop did not end after the context was cancelled")
+	}
+}
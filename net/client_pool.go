@@ -0,0 +1,726 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
+	"github.com/m3db/m3x/retry"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultConnectionPoolMinConnections  = 0
+	defaultConnectionPoolMaxConnections  = 16
+	defaultConnectionPoolDialTimeout     = 5 * time.Second
+	defaultConnectionPoolIdleTimeout     = 5 * time.Minute
+	defaultConnectionPoolCheckInterval   = 10 * time.Second
+	defaultConnectionPoolKeepAlive       = true
+	defaultConnectionPoolKeepAlivePeriod = 0
+)
+
+var (
+	errConnectionPoolClosed = errors.New("connection pool is closed")
+	errEndpointPoolRemoved  = errors.New("endpoint pool was removed")
+)
+
+// HealthCheckFn checks the health of an idle connection, the connection is
+// closed and removed from the pool if it returns an error.
+type HealthCheckFn func(conn net.Conn) error
+
+// ConnectionPoolOptions provide a set of client connection pool options.
+type ConnectionPoolOptions interface {
+	// SetInstrumentOptions sets the instrument options.
+	SetInstrumentOptions(value instrument.Options) ConnectionPoolOptions
+
+	// InstrumentOptions returns the instrument options.
+	InstrumentOptions() instrument.Options
+
+	// SetRetryOptions sets the retry options used when dialing.
+	SetRetryOptions(value retry.Options) ConnectionPoolOptions
+
+	// RetryOptions returns the retry options used when dialing.
+	RetryOptions() retry.Options
+
+	// SetDialTimeout sets the timeout of each dial attempt.
+	SetDialTimeout(value time.Duration) ConnectionPoolOptions
+
+	// DialTimeout returns the timeout of each dial attempt.
+	DialTimeout() time.Duration
+
+	// SetMinConnections sets the minimum number of connections kept open to
+	// each endpoint that has been used.
+	SetMinConnections(value int) ConnectionPoolOptions
+
+	// MinConnections returns the minimum number of connections kept open to
+	// each endpoint that has been used.
+	MinConnections() int
+
+	// SetMaxConnections sets the maximum number of connections open to each
+	// endpoint, getting a connection waits once it's reached.
+	SetMaxConnections(value int) ConnectionPoolOptions
+
+	// MaxConnections returns the maximum number of connections open to each
+	// endpoint.
+	MaxConnections() int
+
+	// SetIdleTimeout sets the duration after which idle connections above the
+	// minimum number of connections are closed. Zero means never.
+	SetIdleTimeout(value time.Duration) ConnectionPoolOptions
+
+	// IdleTimeout returns the duration after which idle connections above the
+	// minimum number of connections are closed.
+	IdleTimeout() time.Duration
+
+	// SetCheckInterval sets how often idle connections are evicted and health
+	// checked, and connections are opened up to the minimum.
+	SetCheckInterval(value time.Duration) ConnectionPoolOptions
+
+	// CheckInterval returns how often idle connections are evicted and health
+	// checked, and connections are opened up to the minimum.
+	CheckInterval() time.Duration
+
+	// SetHealthCheckFn sets the health check of idle connections, idle
+	// connections are not health checked if it's nil.
+	SetHealthCheckFn(value HealthCheckFn) ConnectionPoolOptions
+
+	// HealthCheckFn returns the health check of idle connections.
+	HealthCheckFn() HealthCheckFn
+
+	// SetTCPConnectionKeepAlive sets the keep alive state for tcp connections.
+	SetTCPConnectionKeepAlive(value bool) ConnectionPoolOptions
+
+	// TCPConnectionKeepAlive returns the keep alive state for tcp connections.
+	TCPConnectionKeepAlive() bool
+
+	// SetTCPConnectionKeepAlivePeriod sets the keep alive period for tcp connections.
+	SetTCPConnectionKeepAlivePeriod(value time.Duration) ConnectionPoolOptions
+
+	// TCPConnectionKeepAlivePeriod returns the keep alive period for tcp connections.
+	TCPConnectionKeepAlivePeriod() time.Duration
+}
+
+type connectionPoolOptions struct {
+	instrumentOpts               instrument.Options
+	retryOpts                    retry.Options
+	dialTimeout                  time.Duration
+	minConnections               int
+	maxConnections               int
+	idleTimeout                  time.Duration
+	checkInterval                time.Duration
+	healthCheckFn                HealthCheckFn
+	tcpConnectionKeepAlive       bool
+	tcpConnectionKeepAlivePeriod time.Duration
+}
+
+// NewConnectionPoolOptions creates a new set of client connection pool options.
+func NewConnectionPoolOptions() ConnectionPoolOptions {
+	return &connectionPoolOptions{
+		instrumentOpts:               instrument.NewOptions(),
+		retryOpts:                    retry.NewOptions(),
+		dialTimeout:                  defaultConnectionPoolDialTimeout,
+		minConnections:               defaultConnectionPoolMinConnections,
+		maxConnections:               defaultConnectionPoolMaxConnections,
+		idleTimeout:                  defaultConnectionPoolIdleTimeout,
+		checkInterval:                defaultConnectionPoolCheckInterval,
+		tcpConnectionKeepAlive:       defaultConnectionPoolKeepAlive,
+		tcpConnectionKeepAlivePeriod: defaultConnectionPoolKeepAlivePeriod,
+	}
+}
+
+func (o *connectionPoolOptions) SetInstrumentOptions(value instrument.Options) ConnectionPoolOptions {
+	opts := *o
+	opts.instrumentOpts = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) InstrumentOptions() instrument.Options {
+	return o.instrumentOpts
+}
+
+func (o *connectionPoolOptions) SetRetryOptions(value retry.Options) ConnectionPoolOptions {
+	opts := *o
+	opts.retryOpts = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) RetryOptions() retry.Options {
+	return o.retryOpts
+}
+
+func (o *connectionPoolOptions) SetDialTimeout(value time.Duration) ConnectionPoolOptions {
+	opts := *o
+	opts.dialTimeout = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) DialTimeout() time.Duration {
+	return o.dialTimeout
+}
+
+func (o *connectionPoolOptions) SetMinConnections(value int) ConnectionPoolOptions {
+	opts := *o
+	opts.minConnections = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) MinConnections() int {
+	return o.minConnections
+}
+
+func (o *connectionPoolOptions) SetMaxConnections(value int) ConnectionPoolOptions {
+	opts := *o
+	opts.maxConnections = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) MaxConnections() int {
+	return o.maxConnections
+}
+
+func (o *connectionPoolOptions) SetIdleTimeout(value time.Duration) ConnectionPoolOptions {
+	opts := *o
+	opts.idleTimeout = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) IdleTimeout() time.Duration {
+	return o.idleTimeout
+}
+
+func (o *connectionPoolOptions) SetCheckInterval(value time.Duration) ConnectionPoolOptions {
+	opts := *o
+	opts.checkInterval = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) CheckInterval() time.Duration {
+	return o.checkInterval
+}
+
+func (o *connectionPoolOptions) SetHealthCheckFn(value HealthCheckFn) ConnectionPoolOptions {
+	opts := *o
+	opts.healthCheckFn = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) HealthCheckFn() HealthCheckFn {
+	return o.healthCheckFn
+}
+
+func (o *connectionPoolOptions) SetTCPConnectionKeepAlive(value bool) ConnectionPoolOptions {
+	opts := *o
+	opts.tcpConnectionKeepAlive = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) TCPConnectionKeepAlive() bool {
+	return o.tcpConnectionKeepAlive
+}
+
+func (o *connectionPoolOptions) SetTCPConnectionKeepAlivePeriod(value time.Duration) ConnectionPoolOptions {
+	opts := *o
+	opts.tcpConnectionKeepAlivePeriod = value
+	return &opts
+}
+
+func (o *connectionPoolOptions) TCPConnectionKeepAlivePeriod() time.Duration {
+	return o.tcpConnectionKeepAlivePeriod
+}
+
+// ConnectionPool is a pool of client connections to TCP endpoints.
+type ConnectionPool interface {
+	// Get returns a connection to an endpoint, either an idle one or a newly
+	// dialed one. If the maximum number of connections to the endpoint are
+	// in use it waits for one to be released until the context is done.
+	Get(ctx context.Context, endpoint string) (PooledConn, error)
+
+	// Close closes the pool and its idle connections, connections in use are
+	// closed when they are released.
+	Close() error
+}
+
+// PooledConn is a connection from a connection pool.
+type PooledConn interface {
+	net.Conn
+
+	// Release returns the connection to the pool. Close should be called
+	// instead if the connection had an error so that it's not reused.
+	Release()
+}
+
+type connectionPoolMetrics struct {
+	dials               tally.Counter
+	dialErrors          tally.Counter
+	saturated           tally.Counter
+	idleEvictions       tally.Counter
+	healthCheckFailures tally.Counter
+	openConnections     tally.Gauge
+}
+
+func newConnectionPoolMetrics(scope tally.Scope) connectionPoolMetrics {
+	return connectionPoolMetrics{
+		dials:               scope.Counter("dials"),
+		dialErrors:          scope.Counter("dial-errors"),
+		saturated:           scope.Counter("saturated"),
+		idleEvictions:       scope.Counter("idle-evictions"),
+		healthCheckFailures: scope.Counter("health-check-failures"),
+		openConnections:     scope.Gauge("open-connections"),
+	}
+}
+
+type connectionPool struct {
+	sync.Mutex
+
+	opts      ConnectionPoolOptions
+	dialer    net.Dialer
+	retryOpts retry.Options
+	log       log.Logger
+	metrics   connectionPoolMetrics
+	nowFn     func() time.Time
+
+	closed    bool
+	closedCh  chan struct{}
+	endpoints map[string]*endpointPool
+	numConns  int64
+}
+
+// NewConnectionPool creates a new client connection pool.
+func NewConnectionPool(opts ConnectionPoolOptions) ConnectionPool {
+	instrumentOpts := opts.InstrumentOptions()
+	p := &connectionPool{
+		opts:      opts,
+		dialer:    net.Dialer{Timeout: opts.DialTimeout()},
+		retryOpts: opts.RetryOptions(),
+		log:       instrumentOpts.Logger(),
+		metrics:   newConnectionPoolMetrics(instrumentOpts.MetricsScope()),
+		nowFn:     time.Now,
+		closedCh:  make(chan struct{}),
+		endpoints: make(map[string]*endpointPool),
+	}
+	if interval := opts.CheckInterval(); interval > 0 {
+		go p.checkLoop(interval)
+	}
+	return p
+}
+
+func (p *connectionPool) Get(ctx context.Context, endpoint string) (PooledConn, error) {
+	for {
+		p.Lock()
+		if p.closed {
+			p.Unlock()
+			return nil, errConnectionPoolClosed
+		}
+		ep, ok := p.endpoints[endpoint]
+		if !ok {
+			ep = newEndpointPool(p, endpoint)
+			p.endpoints[endpoint] = ep
+		}
+		p.Unlock()
+
+		conn, err := ep.get(ctx)
+		if err == errEndpointPoolRemoved {
+			// The endpoint pool was removed concurrently, use a new one.
+			continue
+		}
+		return conn, err
+	}
+}
+
+func (p *connectionPool) Close() error {
+	p.Lock()
+	if p.closed {
+		p.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.closedCh)
+	endpoints := make([]*endpointPool, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		endpoints = append(endpoints, ep)
+	}
+	p.Unlock()
+
+	for _, ep := range endpoints {
+		ep.close()
+	}
+	return nil
+}
+
+// dial dials an endpoint retrying with backoff, it returns the context error
+// as soon as the context is done, including while backing off.
+func (p *connectionPool) dial(ctx context.Context, endpoint string) (net.Conn, error) {
+	var conn net.Conn
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if attempt > 0 {
+			if err := p.waitBackoff(ctx, attempt); err != nil {
+				return nil, err
+			}
+		}
+
+		p.metrics.dials.Inc(1)
+		var err error
+		conn, err = p.dialer.DialContext(ctx, "tcp", endpoint)
+		if err == nil {
+			break
+		}
+		p.metrics.dialErrors.Inc(1)
+		if !p.retryOpts.Forever() && attempt >= p.retryOpts.MaxRetries() {
+			return nil, err
+		}
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(p.opts.TCPConnectionKeepAlive())
+		if period := p.opts.TCPConnectionKeepAlivePeriod(); period != 0 {
+			tcpConn.SetKeepAlivePeriod(period)
+		}
+	}
+	p.metrics.openConnections.Update(float64(atomic.AddInt64(&p.numConns, 1)))
+	return conn, nil
+}
+
+// waitBackoff waits for the backoff before the given retry of a dial.
+func (p *connectionPool) waitBackoff(ctx context.Context, retryNum int) error {
+	backoff := time.Duration(retry.BackoffNanos(
+		retryNum,
+		p.retryOpts.Jitter(),
+		p.retryOpts.BackoffFactor(),
+		p.retryOpts.InitialBackoff(),
+		p.retryOpts.MaxBackoff(),
+		p.retryOpts.RngFn(),
+	))
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *connectionPool) closeConn(conn net.Conn) error {
+	err := conn.Close()
+	p.metrics.openConnections.Update(float64(atomic.AddInt64(&p.numConns, -1)))
+	return err
+}
+
+func (p *connectionPool) checkLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.check()
+		case <-p.closedCh:
+			return
+		}
+	}
+}
+
+// check evicts and health checks the idle connections of each endpoint,
+// opens connections up to the minimum and removes the pools of endpoints
+// left without connections.
+func (p *connectionPool) check() {
+	p.Lock()
+	endpoints := make([]*endpointPool, 0, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		endpoints = append(endpoints, ep)
+	}
+	p.Unlock()
+
+	for _, ep := range endpoints {
+		ep.evictIdle()
+		ep.healthCheck()
+		ep.fill()
+		p.removeIfEmpty(ep)
+	}
+}
+
+// removeIfEmpty removes the pool of an endpoint without any connections,
+// e.g. because the endpoint went away, so that the endpoints do not grow
+// without bound.
+func (p *connectionPool) removeIfEmpty(ep *endpointPool) {
+	p.Lock()
+	defer p.Unlock()
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.closed || ep.numConns > 0 || p.endpoints[ep.endpoint] != ep {
+		return
+	}
+	ep.closed, ep.removed = true, true
+	ep.notifyWithLock()
+	delete(p.endpoints, ep.endpoint)
+}
+
+// endpointPool is the pool of connections to a single endpoint.
+type endpointPool struct {
+	sync.Mutex
+
+	pool     *connectionPool
+	endpoint string
+	min      int
+	max      int
+
+	idle     []*pooledConn
+	numConns int
+	changed  chan struct{}
+	closed   bool
+	removed  bool
+}
+
+func newEndpointPool(p *connectionPool, endpoint string) *endpointPool {
+	return &endpointPool{
+		pool:     p,
+		endpoint: endpoint,
+		min:      p.opts.MinConnections(),
+		max:      p.opts.MaxConnections(),
+		changed:  make(chan struct{}),
+	}
+}
+
+func (ep *endpointPool) get(ctx context.Context) (PooledConn, error) {
+	saturated := false
+	for {
+		ep.Lock()
+		if ep.removed {
+			ep.Unlock()
+			return nil, errEndpointPoolRemoved
+		}
+		if ep.closed {
+			ep.Unlock()
+			return nil, errConnectionPoolClosed
+		}
+		if n := len(ep.idle); n > 0 {
+			conn := ep.idle[n-1]
+			ep.idle = ep.idle[:n-1]
+			ep.Unlock()
+			conn.acquire()
+			return conn, nil
+		}
+		if ep.max <= 0 || ep.numConns < ep.max {
+			ep.numConns++
+			ep.Unlock()
+			return ep.dial(ctx)
+		}
+		changed := ep.changed
+		ep.Unlock()
+
+		if !saturated {
+			saturated = true
+			ep.pool.metrics.saturated.Inc(1)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (ep *endpointPool) dial(ctx context.Context) (*pooledConn, error) {
+	conn, err := ep.pool.dial(ctx, ep.endpoint)
+	if err != nil {
+		ep.Lock()
+		ep.numConns--
+		ep.notifyWithLock()
+		ep.Unlock()
+		return nil, err
+	}
+	c := &pooledConn{Conn: conn, pool: ep}
+	c.acquire()
+	return c, nil
+}
+
+// put returns a connection to the idle connections.
+func (ep *endpointPool) put(c *pooledConn) {
+	c.lastUsed = ep.pool.nowFn()
+	ep.putIdle(c)
+}
+
+// putIdle returns a connection to the idle connections without marking it
+// as used.
+func (ep *endpointPool) putIdle(c *pooledConn) {
+	ep.Lock()
+	if ep.closed {
+		ep.Unlock()
+		ep.remove(c)
+		return
+	}
+	ep.idle = append(ep.idle, c)
+	ep.notifyWithLock()
+	ep.Unlock()
+}
+
+// remove closes a connection and frees its slot.
+func (ep *endpointPool) remove(c *pooledConn) error {
+	err := ep.pool.closeConn(c.Conn)
+
+	ep.Lock()
+	ep.numConns--
+	ep.notifyWithLock()
+	ep.Unlock()
+	return err
+}
+
+func (ep *endpointPool) notifyWithLock() {
+	close(ep.changed)
+	ep.changed = make(chan struct{})
+}
+
+func (ep *endpointPool) evictIdle() {
+	idleTimeout := ep.pool.opts.IdleTimeout()
+	if idleTimeout <= 0 {
+		return
+	}
+
+	var (
+		now     = ep.pool.nowFn()
+		evicted []*pooledConn
+	)
+	ep.Lock()
+	remaining := ep.idle[:0]
+	for _, c := range ep.idle {
+		if ep.numConns-len(evicted) > ep.min && now.Sub(c.lastUsed) >= idleTimeout {
+			evicted = append(evicted, c)
+			continue
+		}
+		remaining = append(remaining, c)
+	}
+	ep.idle = remaining
+	ep.Unlock()
+
+	for _, c := range evicted {
+		ep.pool.metrics.idleEvictions.Inc(1)
+		ep.remove(c)
+	}
+}
+
+func (ep *endpointPool) healthCheck() {
+	healthCheckFn := ep.pool.opts.HealthCheckFn()
+	if healthCheckFn == nil {
+		return
+	}
+
+	// Take the idle connections out of the pool while they are checked so
+	// that they are not used concurrently.
+	ep.Lock()
+	idle := ep.idle
+	ep.idle = nil
+	ep.Unlock()
+
+	for _, c := range idle {
+		if err := healthCheckFn(c.Conn); err != nil {
+			ep.pool.metrics.healthCheckFailures.Inc(1)
+			ep.pool.log.WithFields(
+				log.NewField("endpoint", ep.endpoint),
+				log.NewErrField(err),
+			).Debug("connection failed health check")
+			ep.remove(c)
+			continue
+		}
+		// A health check is not a use, keep the connection eligible for
+		// eviction once idle.
+		ep.putIdle(c)
+	}
+}
+
+func (ep *endpointPool) fill() {
+	for {
+		ep.Lock()
+		if ep.closed || ep.numConns >= ep.min {
+			ep.Unlock()
+			return
+		}
+		ep.numConns++
+		ep.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), ep.pool.opts.DialTimeout())
+		c, err := ep.dial(ctx)
+		cancel()
+		if err != nil {
+			ep.pool.log.WithFields(
+				log.NewField("endpoint", ep.endpoint),
+				log.NewErrField(err),
+			).Debug("unable to open minimum connections")
+			return
+		}
+		c.Release()
+	}
+}
+
+func (ep *endpointPool) close() {
+	ep.Lock()
+	ep.closed = true
+	idle := ep.idle
+	ep.idle = nil
+	ep.notifyWithLock()
+	ep.Unlock()
+
+	for _, c := range idle {
+		ep.remove(c)
+	}
+}
+
+const (
+	pooledConnIdle int32 = iota
+	pooledConnInUse
+)
+
+type pooledConn struct {
+	net.Conn
+
+	pool     *endpointPool
+	state    int32
+	lastUsed time.Time
+}
+
+func (c *pooledConn) acquire() {
+	atomic.StoreInt32(&c.state, pooledConnInUse)
+}
+
+func (c *pooledConn) Release() {
+	if atomic.CompareAndSwapInt32(&c.state, pooledConnInUse, pooledConnIdle) {
+		c.pool.put(c)
+	}
+}
+
+func (c *pooledConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.state, pooledConnInUse, pooledConnIdle) {
+		return c.pool.remove(c)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	xnet "github.com/m3db/m3x/net"
+	"github.com/m3db/m3x/server"
+
+	"github.com/stretchr/testify/require"
+)
+
+type echoHandler struct{}
+
+func (h echoHandler) Handle(conn net.Conn) { io.Copy(conn, conn) }
+func (h echoHandler) Close()               {}
+
+func TestConnectionPoolWithServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := server.NewServer("", echoHandler{}, server.NewOptions())
+	require.NoError(t, s.Serve(l))
+	defer s.Close()
+
+	p := xnet.NewConnectionPool(xnet.NewConnectionPoolOptions().SetMaxConnections(2))
+	defer p.Close()
+
+	for i := 0; i < 10; i++ {
+		conn, err := p.Get(context.Background(), l.Addr().String())
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		b := make([]byte, 4)
+		_, err = io.ReadFull(conn, b)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(b))
+		conn.Release()
+	}
+}
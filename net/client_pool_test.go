@@ -0,0 +1,404 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/retry"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+// testEchoServer echoes data back on each accepted connection.
+type testEchoServer struct {
+	listener net.Listener
+	accepted int32
+	wg       sync.WaitGroup
+}
+
+func newTestEchoServer(t *testing.T) *testEchoServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testEchoServer{listener: l}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&s.accepted, 1)
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return s
+}
+
+func (s *testEchoServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *testEchoServer) numAccepted() int {
+	return int(atomic.LoadInt32(&s.accepted))
+}
+
+func (s *testEchoServer) close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func newTestConnectionPool(
+	opts ConnectionPoolOptions,
+) (*connectionPool, tally.TestScope) {
+	scope := tally.NewTestScope("", nil)
+	opts = opts.
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetRetryOptions(retry.NewOptions().
+			SetInitialBackoff(time.Millisecond).
+			SetMaxRetries(1)).
+		SetCheckInterval(0)
+	return NewConnectionPool(opts).(*connectionPool), scope
+}
+
+func requireEcho(t *testing.T, conn net.Conn, msg string) {
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+	b := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, msg, string(b))
+}
+
+func TestConnectionPoolReusesConnections(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions())
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	requireEcho(t, conn, "hello")
+	conn.Release()
+
+	// Releasing twice is a no-op.
+	conn.Release()
+
+	reused, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	require.Equal(t, conn, reused)
+	requireEcho(t, reused, "world")
+	reused.Release()
+
+	require.Equal(t, 1, s.numAccepted())
+	require.Equal(t, int64(1), scope.Snapshot().Counters()["dials+"].Value())
+	require.Equal(t, float64(1), scope.Snapshot().Gauges()["open-connections+"].Value())
+}
+
+func TestConnectionPoolClosedConnectionsAreNotReused(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions().SetMaxConnections(1))
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	conn, err = p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	defer conn.Release()
+	requireEcho(t, conn, "hello")
+	require.Equal(t, int64(2), scope.Snapshot().Counters()["dials+"].Value())
+	require.Equal(t, float64(1), scope.Snapshot().Gauges()["open-connections+"].Value())
+}
+
+func TestConnectionPoolSaturated(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions().SetMaxConnections(1))
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.Get(ctx, s.addr())
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// A waiting get receives the released connection.
+	resultCh := make(chan PooledConn)
+	go func() {
+		c, err := p.Get(context.Background(), s.addr())
+		assert.NoError(t, err)
+		resultCh <- c
+	}()
+	for scope.Snapshot().Counters()["saturated+"].Value() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.Release()
+	require.Equal(t, conn, <-resultCh)
+	conn.Release()
+	require.Equal(t, int64(1), scope.Snapshot().Counters()["dials+"].Value())
+}
+
+func TestConnectionPoolDialErrors(t *testing.T) {
+	s := newTestEchoServer(t)
+	addr := s.addr()
+	s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions())
+	defer p.Close()
+
+	_, err := p.Get(context.Background(), addr)
+	require.Error(t, err)
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(2), counters["dials+"].Value())
+	require.Equal(t, int64(2), counters["dial-errors+"].Value())
+
+	// The failed dial does not take a slot.
+	p.Lock()
+	require.Equal(t, 0, p.endpoints[addr].numConns)
+	p.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = p.Get(ctx, addr)
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestConnectionPoolDialBackoffCancelled(t *testing.T) {
+	s := newTestEchoServer(t)
+	addr := s.addr()
+	s.close()
+
+	p, _ := newTestConnectionPool(NewConnectionPoolOptions())
+	p.retryOpts = retry.NewOptions().
+		SetInitialBackoff(time.Hour).
+		SetForever(true)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := p.Get(ctx, addr)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestConnectionPoolRemovesEmptyEndpoints(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+	addr := s.addr()
+
+	p, _ := newTestConnectionPool(NewConnectionPoolOptions().
+		SetIdleTimeout(time.Minute))
+	defer p.Close()
+	now := time.Now()
+	p.nowFn = func() time.Time { return now }
+
+	conn, err := p.Get(context.Background(), addr)
+	require.NoError(t, err)
+	conn.Release()
+
+	// Endpoints with connections are kept.
+	p.check()
+	p.Lock()
+	ep, ok := p.endpoints[addr]
+	p.Unlock()
+	require.True(t, ok)
+
+	// Endpoints left without connections are removed.
+	now = now.Add(time.Minute)
+	p.check()
+	p.Lock()
+	_, ok = p.endpoints[addr]
+	p.Unlock()
+	require.False(t, ok)
+
+	// A stale endpoint pool is replaced on the next get.
+	_, err = ep.get(context.Background())
+	require.Equal(t, errEndpointPoolRemoved, err)
+	conn, err = p.Get(context.Background(), addr)
+	require.NoError(t, err)
+	requireEcho(t, conn, "foo")
+	conn.Release()
+}
+
+func TestConnectionPoolIdleEviction(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions().
+		SetMinConnections(1).
+		SetIdleTimeout(time.Minute))
+	defer p.Close()
+	now := time.Now()
+	p.nowFn = func() time.Time { return now }
+
+	conns := make([]PooledConn, 0, 3)
+	for i := 0; i < 3; i++ {
+		conn, err := p.Get(context.Background(), s.addr())
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Release()
+	}
+
+	p.check()
+	require.Equal(t, int64(0), scope.Snapshot().Counters()["idle-evictions+"].Value())
+
+	// Idle connections above the minimum are evicted.
+	now = now.Add(time.Minute)
+	p.check()
+	require.Equal(t, int64(2), scope.Snapshot().Counters()["idle-evictions+"].Value())
+	require.Equal(t, float64(1), scope.Snapshot().Gauges()["open-connections+"].Value())
+}
+
+func TestConnectionPoolIdleEvictionWithHealthCheck(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions().
+		SetMinConnections(1).
+		SetIdleTimeout(time.Minute).
+		SetHealthCheckFn(func(conn net.Conn) error {
+			return nil
+		}))
+	defer p.Close()
+	now := time.Now()
+	p.nowFn = func() time.Time { return now }
+
+	conns := make([]PooledConn, 0, 3)
+	for i := 0; i < 3; i++ {
+		conn, err := p.Get(context.Background(), s.addr())
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns {
+		conn.Release()
+	}
+
+	// Health checks do not count as a use of the connections.
+	now = now.Add(30 * time.Second)
+	p.check()
+	require.Equal(t, int64(0), scope.Snapshot().Counters()["idle-evictions+"].Value())
+
+	now = now.Add(30 * time.Second)
+	p.check()
+	require.Equal(t, int64(2), scope.Snapshot().Counters()["idle-evictions+"].Value())
+	require.Equal(t, float64(1), scope.Snapshot().Gauges()["open-connections+"].Value())
+}
+
+func TestConnectionPoolHealthCheck(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	var healthy int32 = 1
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions().
+		SetMinConnections(2).
+		SetHealthCheckFn(func(conn net.Conn) error {
+			if atomic.LoadInt32(&healthy) == 1 {
+				return nil
+			}
+			return errors.New("unhealthy")
+		}))
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	conn.Release()
+
+	// The minimum number of connections is opened for the endpoint.
+	p.check()
+	require.Equal(t, int64(2), scope.Snapshot().Counters()["dials+"].Value())
+	require.Equal(t, int64(0), scope.Snapshot().Counters()["health-check-failures+"].Value())
+
+	// Unhealthy connections are replaced.
+	atomic.StoreInt32(&healthy, 0)
+	p.check()
+	require.Equal(t, int64(2), scope.Snapshot().Counters()["health-check-failures+"].Value())
+	require.Equal(t, int64(4), scope.Snapshot().Counters()["dials+"].Value())
+	require.Equal(t, float64(2), scope.Snapshot().Gauges()["open-connections+"].Value())
+}
+
+func TestConnectionPoolClose(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p, scope := newTestConnectionPool(NewConnectionPoolOptions())
+
+	idle, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	inUse, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	idle.Release()
+
+	require.NoError(t, p.Close())
+	require.NoError(t, p.Close())
+	require.Equal(t, float64(1), scope.Snapshot().Gauges()["open-connections+"].Value())
+
+	_, err = p.Get(context.Background(), s.addr())
+	require.Equal(t, errConnectionPoolClosed, err)
+
+	// Connections in use are closed when released.
+	inUse.Release()
+	require.Equal(t, float64(0), scope.Snapshot().Gauges()["open-connections+"].Value())
+}
+
+func TestConnectionPoolCheckLoop(t *testing.T) {
+	s := newTestEchoServer(t)
+	defer s.close()
+
+	p := NewConnectionPool(NewConnectionPoolOptions().
+		SetMinConnections(1).
+		SetCheckInterval(10 * time.Millisecond))
+	defer p.Close()
+
+	conn, err := p.Get(context.Background(), s.addr())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	for s.numAccepted() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
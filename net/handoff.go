@@ -0,0 +1,214 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// The environment variables used by systemd socket activation, listeners
+	// passed to child processes use the same variables so that processes
+	// can be started by either systemd or a parent process.
+	envListenFDs     = "LISTEN_FDS"
+	envListenPID     = "LISTEN_PID"
+	envListenFDNames = "LISTEN_FDNAMES"
+
+	// listenFDsStart is the first file descriptor of passed listeners.
+	listenFDsStart = 3
+
+	// defaultListenerName is the name of listeners passed without a name.
+	defaultListenerName = "unknown"
+
+	listenerNamesSeparator = ":"
+)
+
+var (
+	errListenerNotFile   = errors.New("listener does not support retrieving its file")
+	errInvalidListenFDs  = errors.New("invalid " + envListenFDs)
+	errListenFDNamesSize = errors.New(envListenFDNames + " does not match " + envListenFDs)
+)
+
+// NamedListener is a listener with a name identifying it when it's passed
+// between processes.
+type NamedListener struct {
+	Name     string
+	Listener net.Listener
+}
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+type unlinkOnCloseListener interface {
+	SetUnlinkOnClose(unlink bool)
+}
+
+// StartProcessWithListeners starts a command passing it the listeners so
+// that it can resume serving them, the listeners are described in the
+// environment of the command the same way as systemd socket activation
+// and can be retrieved by the command with InheritedListeners. The
+// listeners keep serving in this process until closed, unix socket
+// listeners no longer remove their socket file when closed so that the
+// command can keep serving it.
+func StartProcessWithListeners(cmd *exec.Cmd, listeners []NamedListener) error {
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		// The command has its own copies of the files once started.
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	names := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		if strings.Contains(l.Name, listenerNamesSeparator) {
+			return fmt.Errorf("invalid listener name: %s", l.Name)
+		}
+		fl, ok := l.Listener.(fileListener)
+		if !ok {
+			return errListenerNotFile
+		}
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+
+		name := l.Name
+		if name == "" {
+			name = defaultListenerName
+		}
+		names = append(names, name)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(withoutListenEnv(env),
+		envListenFDs+"="+strconv.Itoa(len(files)),
+		envListenFDNames+"="+strings.Join(names, listenerNamesSeparator),
+	)
+	cmd.ExtraFiles = append(files, cmd.ExtraFiles...)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	for _, l := range listeners {
+		if ul, ok := l.Listener.(unlinkOnCloseListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
+
+func withoutListenEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+	for _, kv := range env {
+		if strings.HasPrefix(kv, envListenFDs+"=") ||
+			strings.HasPrefix(kv, envListenPID+"=") ||
+			strings.HasPrefix(kv, envListenFDNames+"=") {
+			continue
+		}
+		result = append(result, kv)
+	}
+	return result
+}
+
+// InheritedListeners returns the listeners passed to this process by a
+// parent process with StartProcessWithListeners or by systemd socket
+// activation, in the order they were passed. It returns no listeners if
+// none were passed or they were passed to a different process. The
+// environment variables describing the listeners are unset so that they
+// are not passed on to child processes.
+func InheritedListeners() ([]NamedListener, error) {
+	names, err := parseListenEnv(os.Getenv, os.Getpid())
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDNames)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make([]NamedListener, 0, len(names))
+	for i, name := range names {
+		// The listener uses a duplicate of the file descriptor so the passed
+		// one is closed.
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, nl := range listeners {
+				nl.Listener.Close()
+			}
+			return nil, fmt.Errorf("unable to inherit listener %s: %v", name, err)
+		}
+		listeners = append(listeners, NamedListener{Name: name, Listener: l})
+	}
+	return listeners, nil
+}
+
+// parseListenEnv returns the names of the listeners passed to the process.
+// Listeners are passed to the process if LISTEN_PID is its pid, or if
+// LISTEN_PID is not set since a parent process cannot know the pid of the
+// child before starting it.
+func parseListenEnv(getenv func(string) string, pid int) ([]string, error) {
+	fdsStr := getenv(envListenFDs)
+	if fdsStr == "" {
+		return nil, nil
+	}
+	if pidStr := getenv(envListenPID); pidStr != "" {
+		listenPID, err := strconv.Atoi(pidStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", envListenPID, pidStr)
+		}
+		if listenPID != pid {
+			return nil, nil
+		}
+	}
+
+	numFDs, err := strconv.Atoi(fdsStr)
+	if err != nil || numFDs < 0 {
+		return nil, errInvalidListenFDs
+	}
+
+	names := make([]string, numFDs)
+	if namesStr := getenv(envListenFDNames); namesStr != "" {
+		names = strings.Split(namesStr, listenerNamesSeparator)
+		if len(names) != numFDs {
+			return nil, errListenFDNamesSize
+		}
+	}
+	for i := range names {
+		if names[i] == "" {
+			names[i] = defaultListenerName
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"net"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListenEnv(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		expected []string
+		err      bool
+	}{
+		{env: map[string]string{}},
+		{
+			env:      map[string]string{"LISTEN_FDS": "2"},
+			expected: []string{"unknown", "unknown"},
+		},
+		{
+			env:      map[string]string{"LISTEN_FDS": "2", "LISTEN_FDNAMES": "a:b"},
+			expected: []string{"a", "b"},
+		},
+		{
+			env:      map[string]string{"LISTEN_FDS": "2", "LISTEN_FDNAMES": "a:", "LISTEN_PID": "42"},
+			expected: []string{"a", "unknown"},
+		},
+		// Listeners passed to another process.
+		{env: map[string]string{"LISTEN_FDS": "1", "LISTEN_PID": "43"}},
+		{env: map[string]string{"LISTEN_FDS": "1", "LISTEN_PID": "x"}, err: true},
+		{env: map[string]string{"LISTEN_FDS": "-1"}, err: true},
+		{env: map[string]string{"LISTEN_FDS": "x"}, err: true},
+		{env: map[string]string{"LISTEN_FDS": "1", "LISTEN_FDNAMES": "a:b"}, err: true},
+	}
+
+	for _, test := range tests {
+		getenv := func(key string) string { return test.env[key] }
+		names, err := parseListenEnv(getenv, 42)
+		if test.err {
+			assert.Error(t, err, "%v", test.env)
+			continue
+		}
+		require.NoError(t, err, "%v", test.env)
+		assert.Equal(t, test.expected, names, "%v", test.env)
+	}
+}
+
+func TestInheritedListenersNone(t *testing.T) {
+	listeners, err := InheritedListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+}
+
+func TestStartProcessWithListenersInvalid(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	cmd := exec.Command("true")
+	err = StartProcessWithListeners(cmd, []NamedListener{{Name: "a:b", Listener: l}})
+	assert.Error(t, err)
+
+	pl := NewProxyProtocolListener(l, NewProxyProtocolOptions())
+	defer pl.Close()
+	err = StartProcessWithListeners(cmd, []NamedListener{{Name: "a", Listener: pl}})
+	assert.Equal(t, errListenerNotFile, err)
+}
+
+func TestWithoutListenEnv(t *testing.T) {
+	env := []string{"A=1", "LISTEN_FDS=1", "LISTEN_PID=2", "LISTEN_FDNAMES=x", "LISTEN_FDSX=1"}
+	assert.Equal(t, []string{"A=1", "LISTEN_FDSX=1"}, withoutListenEnv(env))
+}
@@ -0,0 +1,649 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultProxyHeaderTimeout  = 5 * time.Second
+	defaultProxyHeaderRequired = true
+
+	// The longest possible v1 header including the trailing CRLF.
+	proxyV1MaxHeaderLength = 107
+	proxyV2HeaderLength    = 16
+
+	proxyV2CommandLocal = 0x0
+	proxyV2CommandProxy = 0x1
+
+	proxyV2FamilyUnspec = 0x0
+	proxyV2FamilyInet   = 0x1
+	proxyV2FamilyInet6  = 0x2
+	proxyV2FamilyUnix   = 0x3
+
+	proxyV2ProtocolUnspec = 0x0
+	proxyV2ProtocolStream = 0x1
+	proxyV2ProtocolDgram  = 0x2
+
+	proxyV2Inet4AddrsLength = 12
+	proxyV2Inet6AddrsLength = 36
+	proxyV2UnixAddrsLength  = 216
+	proxyV2TLVHeaderLength  = 3
+)
+
+var (
+	proxyV1Signature = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyListenerClosed      = errors.New("proxy protocol listener closed")
+	errProxyHeaderMissing       = errors.New("proxy protocol header missing")
+	errProxyV1HeaderTooLong     = errors.New("proxy protocol v1 header too long")
+	errProxyV1HeaderInvalid     = errors.New("invalid proxy protocol v1 header")
+	errProxyV2HeaderInvalid     = errors.New("invalid proxy protocol v2 header")
+	errProxyV2AddressesTooShort = errors.New("proxy protocol v2 addresses too short")
+	errProxyV2TLVInvalid        = errors.New("invalid proxy protocol v2 TLV")
+)
+
+// ProxyTLVType is the type of a PROXY protocol v2 TLV.
+type ProxyTLVType byte
+
+// A list of PROXY protocol v2 TLV types defined by the specification.
+const (
+	ProxyTLVTypeALPN      ProxyTLVType = 0x01
+	ProxyTLVTypeAuthority ProxyTLVType = 0x02
+	ProxyTLVTypeCRC32C    ProxyTLVType = 0x03
+	ProxyTLVTypeNoop      ProxyTLVType = 0x04
+	ProxyTLVTypeUniqueID  ProxyTLVType = 0x05
+	ProxyTLVTypeSSL       ProxyTLVType = 0x20
+	ProxyTLVTypeNetNS     ProxyTLVType = 0x30
+)
+
+// ProxyTLV is a type-length-value field of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  ProxyTLVType
+	Value []byte
+}
+
+// ProxyHeader is a PROXY protocol header sent by an upstream proxy.
+type ProxyHeader struct {
+	// Version is the version of the protocol, either 1 or 2.
+	Version int
+
+	// Local is true if the upstream did not proxy the connection, either
+	// with the v2 LOCAL command or the v1 UNKNOWN protocol, in which case
+	// the addresses are not set.
+	Local bool
+
+	// SourceAddr is the address of the client.
+	SourceAddr net.Addr
+
+	// DestinationAddr is the address the client connected to.
+	DestinationAddr net.Addr
+
+	// TLVs are the type-length-value fields of a v2 header.
+	TLVs []ProxyTLV
+}
+
+// TLV returns the value of the first TLV of a type.
+func (h ProxyHeader) TLV(t ProxyTLVType) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ProxyProtocolOptions provide a set of PROXY protocol listener options.
+type ProxyProtocolOptions interface {
+	// SetInstrumentOptions sets the instrument options.
+	SetInstrumentOptions(value instrument.Options) ProxyProtocolOptions
+
+	// InstrumentOptions returns the instrument options.
+	InstrumentOptions() instrument.Options
+
+	// SetHeaderTimeout sets the timeout for reading the header, connections
+	// that do not send a header in time are closed unless the header is not
+	// required and they have not sent anything.
+	SetHeaderTimeout(value time.Duration) ProxyProtocolOptions
+
+	// HeaderTimeout returns the timeout for reading the header.
+	HeaderTimeout() time.Duration
+
+	// SetHeaderRequired sets whether connections from allowed upstreams must
+	// send a header, if not required connections without a header are
+	// accepted with their own addresses, including connections that send
+	// nothing before the header timeout as with protocols where the server
+	// speaks first.
+	SetHeaderRequired(value bool) ProxyProtocolOptions
+
+	// HeaderRequired returns whether connections from allowed upstreams must
+	// send a header.
+	HeaderRequired() bool
+
+	// SetAllowedUpstreams sets the networks of the upstreams allowed to send
+	// headers, headers are not read from connections from other upstreams.
+	// All upstreams are allowed if empty.
+	SetAllowedUpstreams(value []*net.IPNet) ProxyProtocolOptions
+
+	// AllowedUpstreams returns the networks of the upstreams allowed to send
+	// headers.
+	AllowedUpstreams() []*net.IPNet
+}
+
+type proxyProtocolOptions struct {
+	instrumentOpts   instrument.Options
+	headerTimeout    time.Duration
+	headerRequired   bool
+	allowedUpstreams []*net.IPNet
+}
+
+// NewProxyProtocolOptions creates a new set of PROXY protocol listener options.
+func NewProxyProtocolOptions() ProxyProtocolOptions {
+	return &proxyProtocolOptions{
+		instrumentOpts: instrument.NewOptions(),
+		headerTimeout:  defaultProxyHeaderTimeout,
+		headerRequired: defaultProxyHeaderRequired,
+	}
+}
+
+func (o *proxyProtocolOptions) SetInstrumentOptions(value instrument.Options) ProxyProtocolOptions {
+	opts := *o
+	opts.instrumentOpts = value
+	return &opts
+}
+
+func (o *proxyProtocolOptions) InstrumentOptions() instrument.Options {
+	return o.instrumentOpts
+}
+
+func (o *proxyProtocolOptions) SetHeaderTimeout(value time.Duration) ProxyProtocolOptions {
+	opts := *o
+	opts.headerTimeout = value
+	return &opts
+}
+
+func (o *proxyProtocolOptions) HeaderTimeout() time.Duration {
+	return o.headerTimeout
+}
+
+func (o *proxyProtocolOptions) SetHeaderRequired(value bool) ProxyProtocolOptions {
+	opts := *o
+	opts.headerRequired = value
+	return &opts
+}
+
+func (o *proxyProtocolOptions) HeaderRequired() bool {
+	return o.headerRequired
+}
+
+func (o *proxyProtocolOptions) SetAllowedUpstreams(value []*net.IPNet) ProxyProtocolOptions {
+	opts := *o
+	opts.allowedUpstreams = value
+	return &opts
+}
+
+func (o *proxyProtocolOptions) AllowedUpstreams() []*net.IPNet {
+	return o.allowedUpstreams
+}
+
+// ParseCIDRs parses a list of CIDR notation networks.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+type proxyListenerMetrics struct {
+	headers           tally.Counter
+	headerErrors      tally.Counter
+	untrustedUpstream tally.Counter
+}
+
+func newProxyListenerMetrics(scope tally.Scope) proxyListenerMetrics {
+	return proxyListenerMetrics{
+		headers:           scope.Counter("proxy-protocol-headers"),
+		headerErrors:      scope.Counter("proxy-protocol-header-errors"),
+		untrustedUpstream: scope.Counter("proxy-protocol-untrusted-upstreams"),
+	}
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// proxyListener reads the PROXY protocol header of accepted connections
+// before returning them, headers are read concurrently so that a slow
+// upstream does not block accepting other connections.
+type proxyListener struct {
+	net.Listener
+
+	headerTimeout    time.Duration
+	headerRequired   bool
+	allowedUpstreams []*net.IPNet
+	log              log.Logger
+	metrics          proxyListenerMetrics
+
+	closeOnce sync.Once
+	resultCh  chan acceptResult
+	closedCh  chan struct{}
+}
+
+// NewProxyProtocolListener returns a listener that reads the PROXY protocol
+// v1 or v2 header sent by an upstream proxy on each accepted connection.
+// The accepted connections return the client and destination addresses
+// from the header as their remote and local addresses, and the header can
+// be retrieved with ProxyHeaderFromConn.
+func NewProxyProtocolListener(l net.Listener, opts ProxyProtocolOptions) net.Listener {
+	instrumentOpts := opts.InstrumentOptions()
+	pl := &proxyListener{
+		Listener:         l,
+		headerTimeout:    opts.HeaderTimeout(),
+		headerRequired:   opts.HeaderRequired(),
+		allowedUpstreams: opts.AllowedUpstreams(),
+		log:              instrumentOpts.Logger(),
+		metrics:          newProxyListenerMetrics(instrumentOpts.MetricsScope()),
+		resultCh:         make(chan acceptResult),
+		closedCh:         make(chan struct{}),
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-l.resultCh:
+		return r.conn, r.err
+	case <-l.closedCh:
+		return nil, errProxyListenerClosed
+	}
+}
+
+func (l *proxyListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closedCh)
+		err = l.Listener.Close()
+	})
+	return err
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if !l.send(acceptResult{err: err}) {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.readHeader(conn)
+	}
+}
+
+func (l *proxyListener) send(r acceptResult) bool {
+	select {
+	case l.resultCh <- r:
+		return true
+	case <-l.closedCh:
+		if r.conn != nil {
+			r.conn.Close()
+		}
+		return false
+	}
+}
+
+func (l *proxyListener) readHeader(conn net.Conn) {
+	if !l.allowed(conn.RemoteAddr()) {
+		l.metrics.untrustedUpstream.Inc(1)
+		l.send(acceptResult{conn: conn})
+		return
+	}
+
+	if l.headerTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.headerTimeout))
+	}
+	r := bufio.NewReader(conn)
+	header, err := ReadProxyHeader(r)
+	if !l.headerRequired && (err == errProxyHeaderMissing || isSilentTimeout(err, r)) {
+		err = nil
+	}
+	if err != nil {
+		l.metrics.headerErrors.Inc(1)
+		l.log.WithFields(
+			log.NewField("remoteAddress", conn.RemoteAddr().String()),
+			log.NewErrField(err),
+		).Debug("unable to read proxy protocol header")
+		conn.Close()
+		return
+	}
+	if header != nil {
+		l.metrics.headers.Inc(1)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	l.send(acceptResult{conn: &proxyConn{Conn: conn, reader: r, header: header}})
+}
+
+// isSilentTimeout returns whether reading the header timed out before the
+// connection sent anything.
+func isSilentTimeout(err error, r *bufio.Reader) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout() && r.Buffered() == 0
+}
+
+func (l *proxyListener) allowed(addr net.Addr) bool {
+	if len(l.allowedUpstreams) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range l.allowedUpstreams {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+	header *ProxyHeader
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.header != nil && c.header.SourceAddr != nil {
+		return c.header.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.header != nil && c.header.DestinationAddr != nil {
+		return c.header.DestinationAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) ProxyHeader() (ProxyHeader, bool) {
+	if c.header == nil {
+		return ProxyHeader{}, false
+	}
+	return *c.header, true
+}
+
+// NetConn returns the underlying connection.
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+type proxyHeaderConn interface {
+	ProxyHeader() (ProxyHeader, bool)
+}
+
+type wrappedConn interface {
+	NetConn() net.Conn
+}
+
+// ProxyHeaderFromConn returns the PROXY protocol header of a connection
+// accepted by a PROXY protocol listener, connections wrapping it are
+// unwrapped if they implement a NetConn() net.Conn method. It returns
+// false if the connection did not send a header.
+func ProxyHeaderFromConn(conn net.Conn) (ProxyHeader, bool) {
+	for conn != nil {
+		if c, ok := conn.(proxyHeaderConn); ok {
+			return c.ProxyHeader()
+		}
+		c, ok := conn.(wrappedConn)
+		if !ok {
+			break
+		}
+		conn = c.NetConn()
+	}
+	return ProxyHeader{}, false
+}
+
+// ReadProxyHeader reads a PROXY protocol v1 or v2 header from a reader,
+// nothing is consumed from the reader if it does not start with a header.
+func ReadProxyHeader(r *bufio.Reader) (*ProxyHeader, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case proxyV1Signature[0]:
+		if err := expectSignature(r, proxyV1Signature); err != nil {
+			return nil, err
+		}
+		return readProxyV1Header(r)
+	case proxyV2Signature[0]:
+		if err := expectSignature(r, proxyV2Signature); err != nil {
+			return nil, err
+		}
+		return readProxyV2Header(r)
+	default:
+		return nil, errProxyHeaderMissing
+	}
+}
+
+func expectSignature(r *bufio.Reader, signature []byte) error {
+	b, err := r.Peek(len(signature))
+	if err == io.EOF || (err == nil && !bytes.Equal(b, signature)) {
+		return errProxyHeaderMissing
+	}
+	return err
+}
+
+func readProxyV1Header(r *bufio.Reader) (*ProxyHeader, error) {
+	var line []byte
+	for len(line) < proxyV1MaxHeaderLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyV1HeaderTooLong
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errProxyV1HeaderInvalid
+	}
+	header := &ProxyHeader{Version: 1}
+	switch fields[1] {
+	case "UNKNOWN":
+		header.Local = true
+		return header, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errProxyV1HeaderInvalid
+	}
+	if len(fields) != 6 {
+		return nil, errProxyV1HeaderInvalid
+	}
+
+	ipv4 := fields[1] == "TCP4"
+	src, err := parseProxyV1Addr(fields[2], fields[4], ipv4)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseProxyV1Addr(fields[3], fields[5], ipv4)
+	if err != nil {
+		return nil, err
+	}
+	header.SourceAddr, header.DestinationAddr = src, dst
+	return header, nil
+}
+
+func parseProxyV1Addr(ipStr, portStr string, ipv4 bool) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil || (ip.To4() != nil) != ipv4 {
+		return nil, fmt.Errorf("invalid proxy protocol v1 address: %s", ipStr)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy protocol v1 port: %s", portStr)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2Header(r *bufio.Reader) (*ProxyHeader, error) {
+	var fixed [proxyV2HeaderLength]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	version, command := fixed[12]>>4, fixed[12]&0xf
+	family, protocol := fixed[13]>>4, fixed[13]&0xf
+	if version != 2 || (command != proxyV2CommandLocal && command != proxyV2CommandProxy) {
+		return nil, errProxyV2HeaderInvalid
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &ProxyHeader{Version: 2, Local: command == proxyV2CommandLocal}
+	var addrsLength int
+	switch family {
+	case proxyV2FamilyUnspec:
+	case proxyV2FamilyInet:
+		addrsLength = proxyV2Inet4AddrsLength
+	case proxyV2FamilyInet6:
+		addrsLength = proxyV2Inet6AddrsLength
+	case proxyV2FamilyUnix:
+		addrsLength = proxyV2UnixAddrsLength
+	default:
+		return nil, errProxyV2HeaderInvalid
+	}
+	if protocol > proxyV2ProtocolDgram {
+		return nil, errProxyV2HeaderInvalid
+	}
+	if len(payload) < addrsLength {
+		return nil, errProxyV2AddressesTooShort
+	}
+
+	// Addresses are ignored for the LOCAL command and the unspecified family
+	// and protocol as required by the specification.
+	if !header.Local && family != proxyV2FamilyUnspec && protocol != proxyV2ProtocolUnspec {
+		header.SourceAddr, header.DestinationAddr = parseProxyV2Addrs(
+			family, protocol, payload[:addrsLength])
+	}
+
+	tlvs, err := parseProxyV2TLVs(payload[addrsLength:])
+	if err != nil {
+		return nil, err
+	}
+	header.TLVs = tlvs
+	return header, nil
+}
+
+func parseProxyV2Addrs(family, protocol byte, b []byte) (net.Addr, net.Addr) {
+	var ipLength int
+	switch family {
+	case proxyV2FamilyInet:
+		ipLength = net.IPv4len
+	case proxyV2FamilyInet6:
+		ipLength = net.IPv6len
+	case proxyV2FamilyUnix:
+		network := "unix"
+		if protocol == proxyV2ProtocolDgram {
+			network = "unixgram"
+		}
+		src := &net.UnixAddr{Name: unixAddrName(b[:108]), Net: network}
+		dst := &net.UnixAddr{Name: unixAddrName(b[108:216]), Net: network}
+		return src, dst
+	}
+
+	srcIP := net.IP(append([]byte(nil), b[:ipLength]...))
+	dstIP := net.IP(append([]byte(nil), b[ipLength:2*ipLength]...))
+	srcPort := int(binary.BigEndian.Uint16(b[2*ipLength:]))
+	dstPort := int(binary.BigEndian.Uint16(b[2*ipLength+2:]))
+	if protocol == proxyV2ProtocolDgram {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+func unixAddrName(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func parseProxyV2TLVs(b []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+	for len(b) > 0 {
+		if len(b) < proxyV2TLVHeaderLength {
+			return nil, errProxyV2TLVInvalid
+		}
+		length := int(binary.BigEndian.Uint16(b[1:]))
+		if len(b) < proxyV2TLVHeaderLength+length {
+			return nil, errProxyV2TLVInvalid
+		}
+		tlvs = append(tlvs, ProxyTLV{
+			Type:  ProxyTLVType(b[0]),
+			Value: b[proxyV2TLVHeaderLength : proxyV2TLVHeaderLength+length],
+		})
+		b = b[proxyV2TLVHeaderLength+length:]
+	}
+	return tlvs, nil
+}
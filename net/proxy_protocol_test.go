@@ -0,0 +1,396 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/retry"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func proxyV2Header(command, family byte, addrs []byte, tlvs ...ProxyTLV) []byte {
+	var payload []byte
+	payload = append(payload, addrs...)
+	for _, tlv := range tlvs {
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(tlv.Value)))
+		payload = append(payload, byte(tlv.Type), length[0], length[1])
+		payload = append(payload, tlv.Value...)
+	}
+
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func proxyV2Inet4Addrs(src, dst string, srcPort, dstPort uint16) []byte {
+	b := append([]byte(nil), net.ParseIP(src).To4()...)
+	b = append(b, net.ParseIP(dst).To4()...)
+	var ports [4]byte
+	binary.BigEndian.PutUint16(ports[:], srcPort)
+	binary.BigEndian.PutUint16(ports[2:], dstPort)
+	return append(b, ports[:]...)
+}
+
+func TestReadProxyV1Header(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nhello"))
+	header, err := ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, 1, header.Version)
+	assert.False(t, header.Local)
+	assert.Equal(t, "192.168.0.1:56324", header.SourceAddr.String())
+	assert.Equal(t, "10.0.0.1:443", header.DestinationAddr.String())
+
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(rest))
+
+	r = bufio.NewReader(strings.NewReader("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"))
+	header, err = ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1", header.SourceAddr.String())
+
+	r = bufio.NewReader(strings.NewReader("PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n"))
+	header, err = ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.True(t, header.Local)
+	assert.Nil(t, header.SourceAddr)
+}
+
+func TestReadProxyV1HeaderInvalid(t *testing.T) {
+	inputs := []string{
+		"PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 10.0.0.1 1 2\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 1 65536\r\n",
+		"PROXY UDP4 192.168.0.1 10.0.0.1 1 2\r\n",
+		"PROXY TCP4 192.168.0.1 10.0.0.1 1 2\n",
+		"PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n",
+		"PROXY TCP4 192.168.0.1",
+	}
+	for _, input := range inputs {
+		_, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(input)))
+		assert.Error(t, err, input)
+	}
+}
+
+func TestReadProxyHeaderMissing(t *testing.T) {
+	for _, input := range []string{"hello", "PROXIMITY", "\r\n\r\nhello world"} {
+		r := bufio.NewReader(strings.NewReader(input))
+		_, err := ReadProxyHeader(r)
+		assert.Equal(t, errProxyHeaderMissing, err)
+
+		// Nothing is consumed from the reader.
+		rest, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, input, string(rest))
+	}
+}
+
+func TestReadProxyV2Header(t *testing.T) {
+	data := proxyV2Header(proxyV2CommandProxy, 0x11,
+		proxyV2Inet4Addrs("192.168.0.1", "10.0.0.1", 56324, 443),
+		ProxyTLV{Type: ProxyTLVTypeAuthority, Value: []byte("example.com")},
+		ProxyTLV{Type: ProxyTLVTypeUniqueID, Value: []byte{1, 2, 3}},
+	)
+	r := bufio.NewReader(bytes.NewReader(append(data, "hello"...)))
+	header, err := ReadProxyHeader(r)
+	require.NoError(t, err)
+	assert.Equal(t, 2, header.Version)
+	assert.False(t, header.Local)
+	assert.Equal(t, &net.TCPAddr{IP: net.ParseIP("192.168.0.1").To4(), Port: 56324}, header.SourceAddr)
+	assert.Equal(t, "10.0.0.1:443", header.DestinationAddr.String())
+	assert.Len(t, header.TLVs, 2)
+
+	authority, ok := header.TLV(ProxyTLVTypeAuthority)
+	require.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	_, ok = header.TLV(ProxyTLVTypeALPN)
+	assert.False(t, ok)
+
+	rest, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(rest))
+}
+
+func TestReadProxyV2HeaderAddressFamilies(t *testing.T) {
+	inet6 := append(append([]byte(nil), net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...)
+	inet6 = append(inet6, 0, 1, 0, 2)
+	header, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(
+		proxyV2Header(proxyV2CommandProxy, 0x22, inet6))))
+	require.NoError(t, err)
+	assert.Equal(t, &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1}, header.SourceAddr)
+
+	unix := make([]byte, proxyV2UnixAddrsLength)
+	copy(unix, "/tmp/src.sock")
+	copy(unix[108:], "/tmp/dst.sock")
+	header, err = ReadProxyHeader(bufio.NewReader(bytes.NewReader(
+		proxyV2Header(proxyV2CommandProxy, 0x31, unix))))
+	require.NoError(t, err)
+	assert.Equal(t, &net.UnixAddr{Name: "/tmp/src.sock", Net: "unix"}, header.SourceAddr)
+	assert.Equal(t, &net.UnixAddr{Name: "/tmp/dst.sock", Net: "unix"}, header.DestinationAddr)
+
+	// Addresses are ignored for the LOCAL command.
+	header, err = ReadProxyHeader(bufio.NewReader(bytes.NewReader(
+		proxyV2Header(proxyV2CommandLocal, 0x11, proxyV2Inet4Addrs("1.1.1.1", "2.2.2.2", 1, 2)))))
+	require.NoError(t, err)
+	assert.True(t, header.Local)
+	assert.Nil(t, header.SourceAddr)
+}
+
+func TestReadProxyV2HeaderInvalid(t *testing.T) {
+	addrs := proxyV2Inet4Addrs("1.1.1.1", "2.2.2.2", 1, 2)
+	inputs := [][]byte{
+		proxyV2Header(0x2, 0x11, addrs),
+		proxyV2Header(proxyV2CommandProxy, 0x41, addrs),
+		proxyV2Header(proxyV2CommandProxy, 0x13, addrs),
+		proxyV2Header(proxyV2CommandProxy, 0x21, addrs),
+		// Truncated TLV header and value.
+		proxyV2Header(proxyV2CommandProxy, 0x11, append(addrs[:len(addrs):len(addrs)], 0x01)),
+		proxyV2Header(proxyV2CommandProxy, 0x11, append(addrs[:len(addrs):len(addrs)], 0x01, 0, 5, 'a')),
+		// Addresses too short.
+		proxyV2Header(proxyV2CommandProxy, 0x11, addrs[:8]),
+	}
+	// Wrong version.
+	wrongVersion := proxyV2Header(proxyV2CommandProxy, 0x11, addrs)
+	wrongVersion[12] = 0x11
+	inputs = append(inputs, wrongVersion)
+	// Truncated payload.
+	full := proxyV2Header(proxyV2CommandProxy, 0x11, addrs)
+	inputs = append(inputs, full[:len(full)-1])
+
+	for i, input := range inputs {
+		_, err := ReadProxyHeader(bufio.NewReader(bytes.NewReader(input)))
+		assert.Error(t, err, "input %d", i)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8", "2001:db8::/32"})
+	require.NoError(t, err)
+	require.Len(t, networks, 2)
+	assert.True(t, networks[0].Contains(net.ParseIP("10.1.2.3")))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0"})
+	assert.Error(t, err)
+}
+
+func newTestProxyListener(t *testing.T, opts ProxyProtocolOptions) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	return NewProxyProtocolListener(l, opts)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	l := newTestProxyListener(t, NewProxyProtocolOptions())
+	defer l.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		data := proxyV2Header(proxyV2CommandProxy, 0x11,
+			proxyV2Inet4Addrs("192.168.0.1", "10.0.0.1", 1234, 443),
+			ProxyTLV{Type: ProxyTLVTypeAuthority, Value: []byte("example.com")})
+		conn.Write(append(data, "hello"...))
+	}()
+
+	// The listener works with the accept loop.
+	connCh, _ := StartAcceptLoop(l, retry.NewOptions())
+	conn := <-connCh
+	defer conn.Close()
+
+	assert.Equal(t, "192.168.0.1:1234", conn.RemoteAddr().String())
+	assert.Equal(t, "10.0.0.1:443", conn.LocalAddr().String())
+	header, ok := ProxyHeaderFromConn(conn)
+	require.True(t, ok)
+	authority, ok := header.TLV(ProxyTLVTypeAuthority)
+	require.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+
+	data, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+}
+
+func TestProxyProtocolListenerHeaderRequired(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	l := newTestProxyListener(t, NewProxyProtocolOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)))
+	defer l.Close()
+
+	// Connections without a header are closed.
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	conn.Close()
+
+	for scope.Snapshot().Counters()["proxy-protocol-header-errors+"].Value() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyProtocolListenerHeaderOptional(t *testing.T) {
+	l := newTestProxyListener(t, NewProxyProtocolOptions().SetHeaderRequired(false))
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	_, ok := ProxyHeaderFromConn(conn)
+	assert.False(t, ok)
+
+	b := make([]byte, 5)
+	_, err = conn.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestProxyProtocolListenerHeaderOptionalServerSpeaksFirst(t *testing.T) {
+	l := newTestProxyListener(t, NewProxyProtocolOptions().
+		SetHeaderRequired(false).
+		SetHeaderTimeout(50*time.Millisecond))
+	defer l.Close()
+
+	// A client that waits for the server to speak first is passed through
+	// once the header timeout expires.
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	_, ok := ProxyHeaderFromConn(conn)
+	assert.False(t, ok)
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(client, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// The header timeout no longer applies once accepted.
+	time.Sleep(100 * time.Millisecond)
+	_, err = client.Write([]byte("world"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+}
+
+func TestProxyProtocolListenerHeaderTimeout(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	l := newTestProxyListener(t, NewProxyProtocolOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetHeaderTimeout(50*time.Millisecond))
+	defer l.Close()
+
+	// A client that does not send a header in time does not block
+	// accepting other connections.
+	slow, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer slow.Close()
+
+	fast, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer fast.Close()
+	_, err = fast.Write([]byte("PROXY TCP4 1.2.3.4 5.6.7.8 1 2\r\n"))
+	require.NoError(t, err)
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "1.2.3.4:1", conn.RemoteAddr().String())
+
+	require.NoError(t, slow.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = ioutil.ReadAll(slow)
+	require.NoError(t, err)
+	for scope.Snapshot().Counters()["proxy-protocol-header-errors+"].Value() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyProtocolListenerUntrustedUpstream(t *testing.T) {
+	networks, err := ParseCIDRs([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+	l := newTestProxyListener(t, NewProxyProtocolOptions().SetAllowedUpstreams(networks))
+	defer l.Close()
+
+	// Headers from untrusted upstreams are passed through as data.
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	header := "PROXY TCP4 1.2.3.4 5.6.7.8 1 2\r\n"
+	_, err = client.Write([]byte(header))
+	require.NoError(t, err)
+	client.Close()
+
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	data, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, header, string(data))
+}
+
+func TestProxyProtocolListenerClose(t *testing.T) {
+	l := newTestProxyListener(t, NewProxyProtocolOptions())
+
+	errCh := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		errCh <- err
+	}()
+
+	require.NoError(t, l.Close())
+	assert.Error(t, <-errCh)
+	_, err := l.Accept()
+	assert.Error(t, err)
+}
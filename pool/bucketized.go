@@ -38,11 +38,16 @@ type bucketizedObjectPool struct {
 	maxBucketCapacity int
 	opts              ObjectPoolOptions
 	alloc             BucketizedAllocator
+	onDropFn          func(obj interface{}, capacity int)
 	maxAlloc          tally.Counter
 }
 
 // NewBucketizedObjectPool creates a bucketized object pool
 func NewBucketizedObjectPool(sizes []Bucket, opts ObjectPoolOptions) BucketizedObjectPool {
+	return newBucketizedObjectPool(sizes, opts)
+}
+
+func newBucketizedObjectPool(sizes []Bucket, opts ObjectPoolOptions) *bucketizedObjectPool {
 	if opts == nil {
 		opts = NewObjectPoolOptions()
 	}
@@ -88,7 +93,13 @@ func (p *bucketizedObjectPool) Init(alloc BucketizedAllocator) {
 		}
 
 		buckets[i].capacity = capacity
-		buckets[i].pool = NewObjectPool(opts)
+		pool := NewObjectPool(opts).(*objectPool)
+		if onDropFn := p.onDropFn; onDropFn != nil {
+			pool.onDropFn = func(obj interface{}) {
+				onDropFn(obj, capacity)
+			}
+		}
+		buckets[i].pool = pool
 		buckets[i].pool.Init(func() interface{} {
 			return alloc(capacity)
 		})
@@ -110,15 +121,38 @@ func (p *bucketizedObjectPool) Get(capacity int) interface{} {
 	return p.alloc(capacity)
 }
 
+// bucketCapacity returns the capacity of the bucket an object of the given
+// capacity is returned to, or zero if no bucket would retain it.
+func (p *bucketizedObjectPool) bucketCapacity(capacity int) int {
+	if capacity > p.maxBucketCapacity {
+		return 0
+	}
+
+	for i := len(p.sizesAsc) - 1; i >= 0; i-- {
+		if capacity >= p.sizesAsc[i].Capacity {
+			return p.sizesAsc[i].Capacity
+		}
+	}
+
+	return 0
+}
+
 func (p *bucketizedObjectPool) Put(obj interface{}, capacity int) {
+	p.tryPut(obj, capacity)
+}
+
+// tryPut returns an object to the pool and returns whether the object
+// was retained by one of the buckets.
+func (p *bucketizedObjectPool) tryPut(obj interface{}, capacity int) bool {
 	if capacity > p.maxBucketCapacity {
-		return
+		return false
 	}
 
 	for i := len(p.buckets) - 1; i >= 0; i-- {
 		if capacity >= p.buckets[i].capacity {
-			p.buckets[i].pool.Put(obj)
-			return
+			return p.buckets[i].pool.(*objectPool).tryPut(obj)
 		}
 	}
+
+	return false
 }
@@ -0,0 +1,208 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pool
+
+import (
+	"sync/atomic"
+
+	"github.com/m3db/m3x/instrument"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	// TODO(r): Use tally sampling when available
+	sampleMemoryBudgetEvery = 100
+)
+
+type memoryBudget struct {
+	limit       int64
+	pooled      int64
+	outstanding int64
+	metrics     memoryBudgetMetrics
+}
+
+type memoryBudgetMetrics struct {
+	limit       tally.Gauge
+	pooled      tally.Gauge
+	outstanding tally.Gauge
+	utilization tally.Gauge
+}
+
+func newMemoryBudgetMetrics(scope tally.Scope) memoryBudgetMetrics {
+	return memoryBudgetMetrics{
+		limit:       scope.Gauge("limit-bytes"),
+		pooled:      scope.Gauge("pooled-bytes"),
+		outstanding: scope.Gauge("outstanding-bytes"),
+		utilization: scope.Gauge("utilization"),
+	}
+}
+
+// NewMemoryBudget creates a new memory budget that allows the pools
+// registered against it to retain at most limit bytes in total.
+func NewMemoryBudget(limit int64, opts instrument.Options) MemoryBudget {
+	if opts == nil {
+		opts = instrument.NewOptions()
+	}
+
+	scope := opts.MetricsScope().SubScope("memory-budget")
+	b := &memoryBudget{
+		limit:   limit,
+		metrics: newMemoryBudgetMetrics(scope),
+	}
+
+	b.setGauges()
+
+	return b
+}
+
+func (b *memoryBudget) Register(scope tally.Scope) MemoryBudgetAccount {
+	a := &memoryBudgetAccount{
+		budget:  b,
+		metrics: newMemoryBudgetAccountMetrics(scope.SubScope("memory-budget")),
+	}
+
+	a.setGauges()
+
+	return a
+}
+
+func (b *memoryBudget) Limit() int64 {
+	return b.limit
+}
+
+func (b *memoryBudget) PooledBytes() int64 {
+	return atomic.LoadInt64(&b.pooled)
+}
+
+func (b *memoryBudget) OutstandingBytes() int64 {
+	return atomic.LoadInt64(&b.outstanding)
+}
+
+func (b *memoryBudget) tryReserve(size int64) bool {
+	for {
+		pooled := atomic.LoadInt64(&b.pooled)
+		if pooled+size > b.limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.pooled, pooled, pooled+size) {
+			return true
+		}
+	}
+}
+
+func (b *memoryBudget) setGauges() {
+	pooled := b.PooledBytes()
+	b.metrics.limit.Update(float64(b.limit))
+	b.metrics.pooled.Update(float64(pooled))
+	b.metrics.outstanding.Update(float64(b.OutstandingBytes()))
+	b.metrics.utilization.Update(utilization(pooled, b.limit))
+}
+
+type memoryBudgetAccount struct {
+	budget      *memoryBudget
+	pooled      int64
+	outstanding int64
+	dice        int32
+	metrics     memoryBudgetAccountMetrics
+}
+
+type memoryBudgetAccountMetrics struct {
+	memoryBudgetMetrics
+
+	putOverBudget tally.Counter
+}
+
+func newMemoryBudgetAccountMetrics(scope tally.Scope) memoryBudgetAccountMetrics {
+	return memoryBudgetAccountMetrics{
+		memoryBudgetMetrics: newMemoryBudgetMetrics(scope),
+		putOverBudget:       scope.Counter("put-over-budget"),
+	}
+}
+
+func (a *memoryBudgetAccount) PooledBytes() int64 {
+	return atomic.LoadInt64(&a.pooled)
+}
+
+func (a *memoryBudgetAccount) OutstandingBytes() int64 {
+	return atomic.LoadInt64(&a.outstanding)
+}
+
+func (a *memoryBudgetAccount) Alloc(size int) {
+	a.addPooled(int64(size))
+	a.trySetGauges()
+}
+
+func (a *memoryBudgetAccount) Get(size int) {
+	a.addPooled(-int64(size))
+	a.addOutstanding(int64(size))
+	a.trySetGauges()
+}
+
+func (a *memoryBudgetAccount) Put(size int) bool {
+	a.addOutstanding(-int64(size))
+	defer a.trySetGauges()
+
+	if !a.budget.tryReserve(int64(size)) {
+		a.metrics.putOverBudget.Inc(1)
+		return false
+	}
+
+	atomic.AddInt64(&a.pooled, int64(size))
+	return true
+}
+
+func (a *memoryBudgetAccount) Drop(size int) {
+	a.addPooled(-int64(size))
+	a.trySetGauges()
+}
+
+func (a *memoryBudgetAccount) addPooled(delta int64) {
+	atomic.AddInt64(&a.pooled, delta)
+	atomic.AddInt64(&a.budget.pooled, delta)
+}
+
+func (a *memoryBudgetAccount) addOutstanding(delta int64) {
+	atomic.AddInt64(&a.outstanding, delta)
+	atomic.AddInt64(&a.budget.outstanding, delta)
+}
+
+func (a *memoryBudgetAccount) trySetGauges() {
+	if atomic.AddInt32(&a.dice, 1)%sampleMemoryBudgetEvery == 0 {
+		a.setGauges()
+	}
+}
+
+func (a *memoryBudgetAccount) setGauges() {
+	pooled := a.PooledBytes()
+	a.metrics.limit.Update(float64(a.budget.limit))
+	a.metrics.pooled.Update(float64(pooled))
+	a.metrics.outstanding.Update(float64(a.OutstandingBytes()))
+	a.metrics.utilization.Update(utilization(pooled, a.budget.limit))
+	a.budget.setGauges()
+}
+
+func utilization(used, limit int64) float64 {
+	if limit <= 0 {
+		return 0
+	}
+	return float64(used) / float64(limit)
+}
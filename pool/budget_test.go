@@ -0,0 +1,192 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pool
+
+import (
+	"testing"
+
+	"github.com/m3db/m3x/instrument"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+)
+
+func TestMemoryBudgetBytesPoolAccounting(t *testing.T) {
+	budget := NewMemoryBudget(64, instrument.NewOptions())
+	p := getBudgetedBytesPool(budget, tally.NoopScope, 2, []int{8, 16})
+	p.Init()
+
+	assert.Equal(t, int64(48), budget.PooledBytes())
+	assert.Equal(t, int64(0), budget.OutstandingBytes())
+
+	b1 := p.Get(8)
+	b2 := p.Get(16)
+	assert.Equal(t, int64(24), budget.PooledBytes())
+	assert.Equal(t, int64(24), budget.OutstandingBytes())
+
+	p.Put(b1)
+	p.Put(b2)
+	assert.Equal(t, int64(48), budget.PooledBytes())
+	assert.Equal(t, int64(0), budget.OutstandingBytes())
+}
+
+func TestMemoryBudgetBytesPoolDropsOverBudget(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	budget := NewMemoryBudget(8, instrument.NewOptions())
+	p := getBudgetedBytesPool(budget, scope, 1, []int{8})
+	p.Init()
+
+	// Allocations beyond the pool size are checked out and returned.
+	b1 := p.Get(8)
+	b2 := p.Get(8)
+	b3 := p.Get(8)
+	assert.Equal(t, int64(0), budget.PooledBytes())
+	assert.Equal(t, int64(24), budget.OutstandingBytes())
+
+	p.Put(b1)
+	assert.Equal(t, int64(8), budget.PooledBytes())
+
+	// Buffers that would exceed the budget are dropped.
+	p.Put(b2)
+	assert.Equal(t, int64(8), budget.PooledBytes())
+	assert.Equal(t, int64(8), budget.OutstandingBytes())
+
+	// Buffers larger than the largest bucket are never accounted.
+	p.Put(p.Get(32))
+	assert.Equal(t, int64(8), budget.PooledBytes())
+	assert.Equal(t, int64(8), budget.OutstandingBytes())
+
+	b4 := p.Get(8)
+	p.Put(b3)
+	p.Put(b4)
+	assert.Equal(t, int64(8), budget.PooledBytes())
+	assert.Equal(t, int64(0), budget.OutstandingBytes())
+
+	counters := scope.Snapshot().Counters()
+	over, ok := counters["memory-budget.put-over-budget+"]
+	require.True(t, ok)
+	assert.Equal(t, int64(2), over.Value())
+}
+
+func TestMemoryBudgetBytesPoolGrownBuffers(t *testing.T) {
+	budget := NewMemoryBudget(128, instrument.NewOptions())
+	p := getBudgetedBytesPool(budget, tally.NoopScope, 1, []int{16, 64})
+	p.Init()
+	assert.Equal(t, int64(80), budget.PooledBytes())
+
+	// A buffer grown by append is credited at the bucket it is returned to
+	// rather than at its new capacity.
+	b1 := p.Get(16)
+	b1 = append(b1[:cap(b1)], 0)
+	require.True(t, cap(b1) > 16 && cap(b1) < 64)
+	p.Put(b1)
+	assert.Equal(t, int64(80), budget.PooledBytes())
+	assert.Equal(t, int64(0), budget.OutstandingBytes())
+
+	// A buffer grown past the largest bucket is dropped without credit.
+	b2 := p.Get(64)
+	b2 = append(b2[:cap(b2)], 0)
+	p.Put(b2)
+	assert.Equal(t, int64(16), budget.PooledBytes())
+	assert.Equal(t, int64(64), budget.OutstandingBytes())
+}
+
+func TestMemoryBudgetSharedAcrossPools(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	budget := NewMemoryBudget(16, instrument.NewOptions())
+	p1 := getBudgetedBytesPool(budget, scope.Tagged(map[string]string{"pool": "p1"}), 2, []int{8})
+	p2 := getBudgetedBytesPool(budget, scope.Tagged(map[string]string{"pool": "p2"}), 2, []int{8})
+	p1.Init()
+	p2.Init()
+
+	// Pre-allocated buffers always count against the budget.
+	assert.Equal(t, int64(32), budget.PooledBytes())
+
+	b1 := p1.Get(8)
+	b2 := p1.Get(8)
+	assert.Equal(t, int64(16), budget.PooledBytes())
+
+	// Budget is full from buffers retained by the second pool.
+	p1.Put(b1)
+	assert.Equal(t, int64(16), budget.PooledBytes())
+
+	b3 := p2.Get(8)
+	assert.Equal(t, int64(8), budget.PooledBytes())
+	p1.Put(b2)
+	assert.Equal(t, int64(16), budget.PooledBytes())
+	p2.Put(b3)
+	assert.Equal(t, int64(16), budget.PooledBytes())
+	assert.Equal(t, int64(0), budget.OutstandingBytes())
+
+	counters := scope.Snapshot().Counters()
+	p1Over, ok := counters["memory-budget.put-over-budget+pool=p1"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), p1Over.Value())
+	p2Over, ok := counters["memory-budget.put-over-budget+pool=p2"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), p2Over.Value())
+}
+
+func TestMemoryBudgetUtilizationGauges(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	iopts := instrument.NewOptions().SetMetricsScope(scope)
+	budget := NewMemoryBudget(32, iopts)
+	account := budget.Register(scope.Tagged(map[string]string{"pool": "test"}))
+	account.Alloc(8)
+	account.(*memoryBudgetAccount).setGauges()
+
+	gauges := scope.Snapshot().Gauges()
+	for _, test := range []struct {
+		name  string
+		value float64
+	}{
+		{name: "memory-budget.limit-bytes+", value: 32},
+		{name: "memory-budget.pooled-bytes+", value: 8},
+		{name: "memory-budget.utilization+", value: 0.25},
+		{name: "memory-budget.pooled-bytes+pool=test", value: 8},
+		{name: "memory-budget.utilization+pool=test", value: 0.25},
+	} {
+		g, ok := gauges[test.name]
+		require.True(t, ok, test.name)
+		assert.Equal(t, test.value, g.Value(), test.name)
+	}
+}
+
+func getBudgetedBytesPool(
+	budget MemoryBudget,
+	scope tally.Scope,
+	bucketSizes int,
+	bucketCaps []int,
+) *bytesPool {
+	buckets := make([]Bucket, len(bucketCaps))
+	for i, cap := range bucketCaps {
+		buckets[i] = Bucket{
+			Count:    bucketSizes,
+			Capacity: cap,
+		}
+	}
+
+	opts := NewObjectPoolOptions().
+		SetMemoryBudget(budget).
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	return NewBytesPool(buckets, opts).(*bytesPool)
+}
@@ -21,17 +21,43 @@
 package pool
 
 type bytesPool struct {
-	pool BucketizedObjectPool
+	pool   *bucketizedObjectPool
+	opts   ObjectPoolOptions
+	budget MemoryBudgetAccount
 }
 
-// NewBytesPool creates a new bytes pool
+// NewBytesPool creates a new bytes pool, if the options specify a memory
+// budget then the pool registers against it and drops buffers returned
+// to it while the budget is exceeded.
 func NewBytesPool(sizes []Bucket, opts ObjectPoolOptions) BytesPool {
-	return &bytesPool{pool: NewBucketizedObjectPool(sizes, opts)}
+	if opts == nil {
+		opts = NewObjectPoolOptions()
+	}
+
+	p := &bytesPool{
+		pool: newBucketizedObjectPool(sizes, opts),
+		opts: opts,
+	}
+	if budget := opts.MemoryBudget(); budget != nil {
+		p.budget = budget.Register(opts.InstrumentOptions().MetricsScope())
+		p.pool.onDropFn = func(_ interface{}, capacity int) {
+			p.budget.Drop(capacity)
+		}
+	}
+
+	return p
 }
 
 func (p *bytesPool) Init() {
 	p.pool.Init(func(capacity int) interface{} {
-		return make([]byte, 0, capacity)
+		if p.budget != nil {
+			p.budget.Alloc(p.pool.bucketCapacity(capacity))
+		}
+		value := make([]byte, 0, capacity)
+		if poisonBytesEnabled {
+			poisonBytes(value)
+		}
+		return value
 	})
 }
 
@@ -40,12 +66,41 @@ func (p *bytesPool) Get(capacity int) []byte {
 		return nil
 	}
 
-	return p.pool.Get(capacity).([]byte)
+	value := p.pool.Get(capacity).([]byte)
+	if poisonBytesEnabled && !isPoisonedBytes(value) {
+		fn := p.opts.OnPoolAccessErrorFn()
+		fn(errPoisonedBytesModified)
+	}
+	if p.budget != nil {
+		p.budget.Get(p.pool.bucketCapacity(cap(value)))
+	}
+
+	return value
 }
 
 func (p *bytesPool) Put(value []byte) {
 	value = value[:0]
-	p.pool.Put(value, cap(value))
+	if poisonBytesEnabled {
+		poisonBytes(value)
+	}
+	if p.budget == nil {
+		p.pool.Put(value, cap(value))
+		return
+	}
+
+	// Buffers are accounted at the capacity of their bucket so that one
+	// grown by append does not credit more than was charged for it, those
+	// grown past the largest bucket were never charged and are not retained.
+	size := p.pool.bucketCapacity(cap(value))
+	if size == 0 {
+		return
+	}
+	if !p.budget.Put(size) {
+		return
+	}
+	if !p.pool.tryPut(value, size) {
+		p.budget.Drop(size)
+	}
 }
 
 // AppendByte appends a byte to a byte slice getting a new slice from the
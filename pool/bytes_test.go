@@ -60,7 +60,7 @@ func TestBytesPoolGetLargerThanLargestBucket(t *testing.T) {
 	assert.Equal(t, 0, len(x))
 
 	// Assert not from pool
-	bucketed := p.pool.(*bucketizedObjectPool)
+	bucketed := p.pool
 	assert.Equal(t, 1, len(bucketed.buckets))
 	assert.Equal(t, 2, len(bucketed.buckets[0].pool.(*objectPool).values))
 }
@@ -43,6 +43,8 @@ type objectPool struct {
 	opts                ObjectPoolOptions
 	values              chan interface{}
 	alloc               Allocator
+	onPutFn             OnPutFn
+	onDropFn            func(obj interface{})
 	size                int
 	refillLowWatermark  int
 	refillHighWatermark int
@@ -57,6 +59,7 @@ type objectPoolMetrics struct {
 	total      tally.Gauge
 	getOnEmpty tally.Counter
 	putOnFull  tally.Counter
+	putInvalid tally.Counter
 }
 
 // NewObjectPool creates a new pool
@@ -68,9 +71,10 @@ func NewObjectPool(opts ObjectPoolOptions) ObjectPool {
 	m := opts.InstrumentOptions().MetricsScope()
 
 	p := &objectPool{
-		opts:   opts,
-		values: make(chan interface{}, opts.Size()),
-		size:   opts.Size(),
+		opts:    opts,
+		values:  make(chan interface{}, opts.Size()),
+		onPutFn: opts.OnPutFn(),
+		size:    opts.Size(),
 		refillLowWatermark: int(math.Ceil(
 			opts.RefillLowWatermark() * float64(opts.Size()))),
 		refillHighWatermark: int(math.Ceil(
@@ -80,6 +84,7 @@ func NewObjectPool(opts ObjectPoolOptions) ObjectPool {
 			total:      m.Gauge("total"),
 			getOnEmpty: m.Counter("get-on-empty"),
 			putOnFull:  m.Counter("put-on-full"),
+			putInvalid: m.Counter("put-invalid"),
 		},
 	}
 
@@ -129,19 +134,34 @@ func (p *objectPool) Get() interface{} {
 }
 
 func (p *objectPool) Put(obj interface{}) {
+	p.tryPut(obj)
+}
+
+// tryPut returns an object to the pool and returns whether the object
+// was retained by the pool.
+func (p *objectPool) tryPut(obj interface{}) bool {
 	if atomic.LoadInt32(&p.initialized) != 1 {
 		fn := p.opts.OnPoolAccessErrorFn()
 		fn(errPoolPutBeforeInitialized)
-		return
+		return false
 	}
 
+	if p.onPutFn != nil && !p.onPutFn(obj) {
+		p.metrics.putInvalid.Inc(1)
+		return false
+	}
+
+	retained := true
 	select {
 	case p.values <- obj:
 	default:
+		retained = false
 		p.metrics.putOnFull.Inc(1)
 	}
 
 	p.trySetGauges()
+
+	return retained
 }
 
 func (p *objectPool) trySetGauges() {
@@ -164,9 +184,15 @@ func (p *objectPool) tryFill() {
 		defer atomic.StoreInt32(&p.filling, 0)
 
 		for len(p.values) < p.refillHighWatermark {
+			v := p.alloc()
 			select {
-			case p.values <- p.alloc():
+			case p.values <- v:
 			default:
+				// Concurrent puts filled the pool, let the owner release
+				// anything it accounted for when the value was allocated.
+				if p.onDropFn != nil {
+					p.onDropFn(v)
+				}
 				return
 			}
 		}
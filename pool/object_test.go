@@ -24,8 +24,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/m3db/m3x/instrument"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 func TestObjectPoolRefillOnLowWaterMark(t *testing.T) {
@@ -62,6 +65,47 @@ func TestObjectPoolRefillOnLowWaterMark(t *testing.T) {
 	assert.Equal(t, 75, len(pool.values))
 }
 
+func TestObjectPoolRefillDropsWhenFull(t *testing.T) {
+	opts := NewObjectPoolOptions().
+		SetSize(4).
+		SetRefillLowWatermark(0.25).
+		SetRefillHighWatermark(0.75)
+
+	var (
+		filling bool
+		dropped = make(chan interface{}, 1)
+	)
+	pool := NewObjectPool(opts).(*objectPool)
+	pool.onDropFn = func(obj interface{}) {
+		dropped <- obj
+	}
+	pool.Init(func() interface{} {
+		if filling {
+			// Simulate puts racing with the refill.
+			for len(pool.values) < cap(pool.values) {
+				pool.Put(0)
+			}
+		}
+		return 1
+	})
+
+	for i := 0; i < 2; i++ {
+		pool.Get()
+	}
+
+	// This should trigger a refill that finds the pool full.
+	filling = true
+	pool.Get()
+
+	select {
+	case obj := <-dropped:
+		assert.Equal(t, 1, obj)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "refill did not drop allocated value")
+	}
+	assert.Equal(t, 4, len(pool.values))
+}
+
 func TestObjectPoolInitTwiceError(t *testing.T) {
 	var accessErr error
 	opts := NewObjectPoolOptions().SetOnPoolAccessErrorFn(func(err error) {
@@ -117,6 +161,49 @@ func TestObjectPoolPutBeforeInitError(t *testing.T) {
 	assert.Equal(t, errPoolPutBeforeInitialized, accessErr)
 }
 
+func TestObjectPoolOnPutFn(t *testing.T) {
+	type obj struct {
+		value int
+	}
+
+	scope := tally.NewTestScope("", nil)
+	opts := NewObjectPoolOptions().
+		SetSize(2).
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetOnPutFn(func(v interface{}) bool {
+			o := v.(*obj)
+			if o.value < 0 {
+				return false
+			}
+			o.value = 0
+			return true
+		})
+
+	pool := NewObjectPool(opts).(*objectPool)
+	pool.Init(func() interface{} {
+		return &obj{}
+	})
+
+	o1 := pool.Get().(*obj)
+	o1.value = 42
+	pool.Put(o1)
+	assert.Equal(t, 2, len(pool.values))
+
+	o2 := pool.Get().(*obj)
+	o2.value = -1
+	pool.Put(o2)
+	assert.Equal(t, 1, len(pool.values))
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 0, pool.Get().(*obj).value)
+	}
+
+	counters := scope.Snapshot().Counters()
+	invalid, ok := counters["put-invalid+"]
+	require.True(t, ok)
+	assert.Equal(t, int64(1), invalid.Value())
+}
+
 func BenchmarkObjectPoolGetPut(b *testing.B) {
 	opts := NewObjectPoolOptions().SetSize(1)
 	pool := NewObjectPool(opts)
@@ -34,6 +34,8 @@ type objectPoolOptions struct {
 	refillHighWatermark float64
 	instrumentOpts      instrument.Options
 	onPoolAccessErrorFn OnPoolAccessErrorFn
+	onPutFn             OnPutFn
+	memoryBudget        MemoryBudget
 }
 
 // NewObjectPoolOptions creates a new set of object pool options
@@ -96,3 +98,23 @@ func (o *objectPoolOptions) SetOnPoolAccessErrorFn(value OnPoolAccessErrorFn) Ob
 func (o *objectPoolOptions) OnPoolAccessErrorFn() OnPoolAccessErrorFn {
 	return o.onPoolAccessErrorFn
 }
+
+func (o *objectPoolOptions) SetOnPutFn(value OnPutFn) ObjectPoolOptions {
+	opts := *o
+	opts.onPutFn = value
+	return &opts
+}
+
+func (o *objectPoolOptions) OnPutFn() OnPutFn {
+	return o.onPutFn
+}
+
+func (o *objectPoolOptions) SetMemoryBudget(value MemoryBudget) ObjectPoolOptions {
+	opts := *o
+	opts.memoryBudget = value
+	return &opts
+}
+
+func (o *objectPoolOptions) MemoryBudget() MemoryBudget {
+	return o.memoryBudget
+}
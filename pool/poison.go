@@ -0,0 +1,53 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pool
+
+import (
+	"errors"
+)
+
+var (
+	errPoisonedBytesModified = errors.New("bytes pool buffer modified after being returned to pool")
+)
+
+// poisonPattern is the pattern written into buffers returned to a bytes pool
+// when poisoning is enabled, chosen to be easily recognizable in a dump.
+var poisonPattern = [...]byte{0xDE, 0xAD, 0xBE, 0xEF}
+
+// poisonBytes writes the poison pattern into the full capacity of a buffer.
+func poisonBytes(value []byte) {
+	value = value[:cap(value)]
+	for i := range value {
+		value[i] = poisonPattern[i%len(poisonPattern)]
+	}
+}
+
+// isPoisonedBytes returns whether the full capacity of a buffer still holds
+// the poison pattern.
+func isPoisonedBytes(value []byte) bool {
+	value = value[:cap(value)]
+	for i := range value {
+		if value[i] != poisonPattern[i%len(poisonPattern)] {
+			return false
+		}
+	}
+	return true
+}
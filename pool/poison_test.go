@@ -0,0 +1,68 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pool
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoisonBytes(t *testing.T) {
+	b := make([]byte, 2, 7)
+	assert.False(t, isPoisonedBytes(b))
+
+	poisonBytes(b)
+	assert.Equal(t, []byte{0xDE, 0xAD, 0xBE, 0xEF, 0xDE, 0xAD, 0xBE}, b[:cap(b)])
+	assert.True(t, isPoisonedBytes(b[:0]))
+
+	b[:cap(b)][6] = 0
+	assert.False(t, isPoisonedBytes(b))
+}
+
+func TestBytesPoolPoisonDetectsWriteAfterPut(t *testing.T) {
+	defer func(enabled bool) {
+		poisonBytesEnabled = enabled
+	}(poisonBytesEnabled)
+	poisonBytesEnabled = true
+
+	var accessErr error
+	opts := NewObjectPoolOptions().SetOnPoolAccessErrorFn(func(err error) {
+		accessErr = err
+	})
+	p := NewBytesPool([]Bucket{{Capacity: 4, Count: 1}}, opts)
+	p.Init()
+
+	b := p.Get(4)
+	require.NoError(t, accessErr)
+	b = append(b, 'a', 'b')
+	p.Put(b)
+
+	b = p.Get(4)
+	require.NoError(t, accessErr)
+	p.Put(b)
+
+	// Write to the buffer after it has been returned to the pool.
+	b = append(b, 'c')
+	p.Get(4)
+	assert.Equal(t, errPoisonedBytesModified, accessErr)
+}
@@ -24,6 +24,8 @@ package pool
 import (
 	"github.com/m3db/m3x/checked"
 	"github.com/m3db/m3x/instrument"
+
+	"github.com/uber-go/tally"
 )
 
 // Allocator allocates an object for a pool.
@@ -61,6 +63,11 @@ type CheckedObjectPool interface {
 // such as get or put before the pool is initialized.
 type OnPoolAccessErrorFn func(err error)
 
+// OnPutFn is a function called with an object as it is returned to a pool,
+// it should reset the object and return false if the object is invalid and
+// should be dropped rather than retained by the pool.
+type OnPutFn func(obj interface{}) bool
+
 // ObjectPoolOptions provides options for an object pool.
 type ObjectPoolOptions interface {
 	// SetSize sets the size of the object pool.
@@ -98,6 +105,22 @@ type ObjectPoolOptions interface {
 	// OnPoolAccessErrorFn returns the on pool access error callback, by
 	// default this is a panic.
 	OnPoolAccessErrorFn() OnPoolAccessErrorFn
+
+	// SetOnPutFn sets the on put callback used to reset and validate objects
+	// returned to the pool, if nil then objects are retained as is.
+	SetOnPutFn(value OnPutFn) ObjectPoolOptions
+
+	// OnPutFn returns the on put callback used to reset and validate objects
+	// returned to the pool, if nil then objects are retained as is.
+	OnPutFn() OnPutFn
+
+	// SetMemoryBudget sets the memory budget that bytes pools register
+	// against, if nil then the pool does not account for its memory.
+	SetMemoryBudget(value MemoryBudget) ObjectPoolOptions
+
+	// MemoryBudget returns the memory budget that bytes pools register
+	// against, if nil then the pool does not account for its memory.
+	MemoryBudget() MemoryBudget
 }
 
 // Bucket specifies a pool bucket.
@@ -184,3 +207,44 @@ type FloatsPool interface {
 	// Put returns an float64 slice to the pool.
 	Put(value []float64)
 }
+
+// MemoryBudget is a memory budget shared by a set of pools that bounds the
+// total number of bytes retained across all of them.
+type MemoryBudget interface {
+	// Register registers a pool against the budget, the utilization of the
+	// budget by the pool is reported to the given metrics scope.
+	Register(scope tally.Scope) MemoryBudgetAccount
+
+	// Limit returns the maximum number of bytes pools may retain.
+	Limit() int64
+
+	// PooledBytes returns the number of bytes retained across all pools.
+	PooledBytes() int64
+
+	// OutstandingBytes returns the number of bytes checked out across all pools.
+	OutstandingBytes() int64
+}
+
+// MemoryBudgetAccount accounts for the bytes of a single pool registered
+// against a memory budget.
+type MemoryBudgetAccount interface {
+	// PooledBytes returns the number of bytes retained by the pool.
+	PooledBytes() int64
+
+	// OutstandingBytes returns the number of bytes checked out of the pool.
+	OutstandingBytes() int64
+
+	// Alloc accounts for a newly allocated buffer retained by the pool,
+	// allocations are always accounted for even if they exceed the budget.
+	Alloc(size int)
+
+	// Get accounts for a buffer being checked out of the pool.
+	Get(size int)
+
+	// Put accounts for a buffer being returned to the pool and returns
+	// whether the pool may retain it, if false the buffer must be dropped.
+	Put(size int) bool
+
+	// Drop accounts for a retained buffer that was discarded by the pool.
+	Drop(size int)
+}
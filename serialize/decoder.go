@@ -0,0 +1,226 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+)
+
+var (
+	errIncompleteHeader = errors.New("incomplete header")
+	errIncompleteTag    = errors.New("incomplete tag")
+	errTrailingBytes    = errors.New("trailing bytes after last tag")
+)
+
+type decoder struct {
+	opts TagDecoderOptions
+	pool TagDecoderPool
+
+	data      checked.Bytes
+	sorted    bool
+	length    int
+	remaining int
+	offset    int
+	current   ident.Tag
+	err       error
+}
+
+// NewTagDecoder returns a new TagDecoder.
+func NewTagDecoder(opts TagDecoderOptions, pool TagDecoderPool) TagDecoder {
+	return &decoder{
+		opts: opts,
+		pool: pool,
+	}
+}
+
+func (d *decoder) Reset(b checked.Bytes) {
+	d.release()
+	b.IncRef()
+	d.data = b
+
+	data := b.Bytes()
+	if len(data) < headerLength {
+		d.err = errIncompleteHeader
+		return
+	}
+	if magic := byteOrder.Uint16(data); magic != headerMagicNumber {
+		d.err = fmt.Errorf("invalid header magic number: %d", magic)
+		return
+	}
+	if version := data[2]; version != headerVersion {
+		d.err = fmt.Errorf("unsupported version: %d", version)
+		return
+	}
+
+	numTags := byteOrder.Uint16(data[headerNumTagOffset:])
+	if limit := d.opts.TagSerializationLimits().MaxNumberTags(); numTags > limit {
+		d.err = fmt.Errorf("too many tags to decode (%d), limit is: %d",
+			numTags, limit)
+		return
+	}
+
+	d.sorted = data[headerFlagsOffset]&flagSorted != 0
+	d.length = int(numTags)
+	d.remaining = int(numTags)
+	d.offset = headerLength
+}
+
+func (d *decoder) Next() bool {
+	d.releaseCurrent()
+	if d.err != nil || d.data == nil {
+		return false
+	}
+
+	if d.remaining == 0 {
+		if d.offset != d.data.Len() {
+			d.err = errTrailingBytes
+		}
+		return false
+	}
+
+	nameStart, nameEnd, err := d.decodeLiteral()
+	if err != nil {
+		d.err = err
+		return false
+	}
+	if nameStart == nameEnd {
+		d.err = errEmptyTagName
+		return false
+	}
+
+	valueStart, valueEnd, err := d.decodeLiteral()
+	if err != nil {
+		d.err = err
+		return false
+	}
+
+	d.remaining--
+	d.current = ident.Tag{
+		Name:  ident.BinaryID(d.data.Slice(nameStart, nameEnd)),
+		Value: ident.BinaryID(d.data.Slice(valueStart, valueEnd)),
+	}
+	return true
+}
+
+// decodeLiteral returns the bounds of the next literal in the encoded bytes.
+func (d *decoder) decodeLiteral() (int, int, error) {
+	data := d.data.Bytes()
+	if len(data)-d.offset < lengthPrefixLength {
+		return 0, 0, errIncompleteTag
+	}
+
+	length := byteOrder.Uint16(data[d.offset:])
+	if limit := d.opts.TagSerializationLimits().MaxTagLiteralLength(); length > limit {
+		return 0, 0, fmt.Errorf("tag literal too long (%d), limit is: %d",
+			length, limit)
+	}
+
+	start := d.offset + lengthPrefixLength
+	end := start + int(length)
+	if end > len(data) {
+		return 0, 0, errIncompleteTag
+	}
+
+	d.offset = end
+	return start, end, nil
+}
+
+func (d *decoder) Current() ident.Tag {
+	return d.current
+}
+
+func (d *decoder) CurrentIndex() int {
+	if idx := d.length - d.remaining - 1; idx >= 0 {
+		return idx
+	}
+	return 0
+}
+
+func (d *decoder) Err() error {
+	return d.err
+}
+
+func (d *decoder) Close() {
+	d.release()
+	if d.pool == nil {
+		return
+	}
+	d.pool.Put(d)
+}
+
+func (d *decoder) Len() int {
+	return d.length
+}
+
+func (d *decoder) Remaining() int {
+	return d.remaining
+}
+
+func (d *decoder) Sorted() bool {
+	return d.sorted
+}
+
+func (d *decoder) Duplicate() ident.TagIterator {
+	var dupe TagDecoder
+	if d.pool != nil {
+		dupe = d.pool.Get()
+	} else {
+		dupe = NewTagDecoder(d.opts, nil)
+	}
+	if d.data == nil {
+		return dupe
+	}
+
+	dupe.Reset(d.data)
+	for dupe.Remaining() > d.remaining {
+		if !dupe.Next() {
+			break
+		}
+	}
+	return dupe
+}
+
+func (d *decoder) releaseCurrent() {
+	if d.current.Name != nil {
+		d.current.Name.Finalize()
+	}
+	if d.current.Value != nil {
+		d.current.Value.Finalize()
+	}
+	d.current = ident.Tag{}
+}
+
+func (d *decoder) release() {
+	d.releaseCurrent()
+	if d.data != nil {
+		d.data.DecRef()
+		d.data = nil
+	}
+	d.sorted = false
+	d.length = 0
+	d.remaining = 0
+	d.offset = 0
+	d.err = nil
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import (
+	"testing"
+
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+	"github.com/m3db/m3x/pool"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestTags(t *testing.T, tags ...string) checked.Bytes {
+	e := newTestTagEncoder()
+	require.NoError(t, e.Encode(ident.MustNewTagStringsIterator(tags...)))
+	data, ok := e.Data()
+	require.True(t, ok)
+	data.IncRef()
+	e.Reset()
+	data.DecRef()
+	return data
+}
+
+func TestDecodeRoundTrip(t *testing.T) {
+	data := encodeTestTags(t, "abc", "def", "ghi", "", "jkl", "mno")
+
+	d := NewTagDecoder(NewTagDecoderOptions(), nil)
+	d.Reset(data)
+	assert.Equal(t, 3, d.Len())
+	assert.Equal(t, 3, d.Remaining())
+	assert.True(t, d.Sorted())
+
+	var decoded []string
+	for d.Next() {
+		tag := d.Current()
+		decoded = append(decoded, tag.Name.String(), tag.Value.String())
+		assert.Equal(t, len(decoded)/2-1, d.CurrentIndex())
+	}
+	require.NoError(t, d.Err())
+	assert.Equal(t, []string{"abc", "def", "ghi", "", "jkl", "mno"}, decoded)
+	assert.Equal(t, 0, d.Remaining())
+	d.Close()
+	assert.Equal(t, 0, data.NumRef())
+
+	d.Reset(data)
+	assert.True(t, ident.NewTagIterMatcher(
+		ident.MustNewTagStringsIterator("abc", "def", "ghi", "", "jkl", "mno")).
+		Matches(d))
+}
+
+func TestDecodeZeroCopy(t *testing.T) {
+	data := encodeTestTags(t, "abc", "def")
+
+	d := NewTagDecoder(NewTagDecoderOptions(), nil)
+	d.Reset(data)
+	require.True(t, d.Next())
+
+	name := d.Current().Name.Bytes()
+	assert.True(t, &name[0] == &data.Bytes()[headerLength+lengthPrefixLength])
+	d.Close()
+	assert.Equal(t, 0, data.NumRef())
+}
+
+func TestDecodeHoldsRefToCurrentTag(t *testing.T) {
+	data := encodeTestTags(t, "abc", "def")
+
+	d := NewTagDecoder(NewTagDecoderOptions(), nil)
+	d.Reset(data)
+	require.True(t, d.Next())
+
+	// The decoder holds a ref and each ID of the current tag a ref.
+	assert.Equal(t, 3, data.NumRef())
+	require.False(t, d.Next())
+	assert.Equal(t, 1, data.NumRef())
+	d.Close()
+}
+
+func TestDecodeErrors(t *testing.T) {
+	encoded := encodeTestTags(t, "abc", "def")
+	encoded.IncRef()
+	valid := append([]byte(nil), encoded.Bytes()...)
+	encoded.DecRef()
+
+	withByte := func(idx int, v byte) []byte {
+		b := append([]byte(nil), valid...)
+		b[idx] = v
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "incomplete header", data: valid[:headerLength-1]},
+		{name: "bad magic", data: withByte(0, 0)},
+		{name: "bad version", data: withByte(2, 2)},
+		{name: "too many tags", data: withByte(headerNumTagOffset, 2)},
+		{name: "incomplete name length", data: valid[:headerLength+1]},
+		{name: "incomplete name", data: valid[:headerLength+3]},
+		{name: "incomplete value", data: valid[:len(valid)-1]},
+		{name: "empty name", data: withByte(headerLength, 0)},
+		{name: "trailing bytes", data: append(append([]byte(nil), valid...), 0)},
+	}
+
+	for _, test := range tests {
+		d := NewTagDecoder(NewTagDecoderOptions(), nil)
+		d.Reset(checked.NewBytes(test.data, nil))
+		for d.Next() {
+		}
+		assert.Error(t, d.Err(), test.name)
+		d.Close()
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	data := encodeTestTags(t, "abc", "def", "ghi", "jkl")
+
+	limits := NewTagSerializationLimits().SetMaxNumberTags(1)
+	d := NewTagDecoder(NewTagDecoderOptions().SetTagSerializationLimits(limits), nil)
+	d.Reset(data)
+	assert.False(t, d.Next())
+	assert.Error(t, d.Err())
+	d.Close()
+
+	limits = NewTagSerializationLimits().SetMaxTagLiteralLength(2)
+	d = NewTagDecoder(NewTagDecoderOptions().SetTagSerializationLimits(limits), nil)
+	d.Reset(data)
+	assert.False(t, d.Next())
+	assert.Error(t, d.Err())
+	d.Close()
+}
+
+func TestDecoderDuplicate(t *testing.T) {
+	data := encodeTestTags(t, "a", "1", "b", "2", "c", "3")
+
+	p := NewTagDecoderPool(NewTagDecoderOptions(), pool.NewObjectPoolOptions())
+	p.Init()
+
+	d := p.Get()
+	d.Reset(data)
+	require.True(t, d.Next())
+
+	dupe := d.Duplicate()
+	assert.Equal(t, d.Remaining(), dupe.Remaining())
+	assert.Equal(t, d.CurrentIndex(), dupe.CurrentIndex())
+	assert.True(t, d.Current().Equal(dupe.Current()))
+
+	require.True(t, dupe.Next())
+	assert.Equal(t, "b", dupe.Current().Name.String())
+	assert.Equal(t, "a", d.Current().Name.String())
+
+	dupe.Close()
+	d.Close()
+	assert.Equal(t, 0, data.NumRef())
+}
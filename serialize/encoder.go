@@ -0,0 +1,189 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+	"github.com/m3db/m3x/pool"
+)
+
+const (
+	headerMagicNumber uint16 = 0x7467
+	headerVersion     uint8  = 1
+
+	// flagSorted is set when tags are in ascending order of name with
+	// no duplicate names.
+	flagSorted uint8 = 1 << 0
+
+	headerLength       = 6
+	headerFlagsOffset  = 3
+	headerNumTagOffset = 4
+	lengthPrefixLength = 2
+)
+
+var (
+	byteOrder = binary.LittleEndian
+
+	errEncoderInUse = errors.New("encoder already in use")
+	errEmptyTagName = errors.New("tag name cannot be empty")
+)
+
+type encoder struct {
+	bytesPool pool.CheckedBytesPool
+	opts      TagEncoderOptions
+	pool      TagEncoderPool
+	data      checked.Bytes
+	buf       [lengthPrefixLength]byte
+}
+
+// NewTagEncoder returns a new TagEncoder.
+func NewTagEncoder(
+	bytesPool pool.CheckedBytesPool,
+	opts TagEncoderOptions,
+	pool TagEncoderPool,
+) TagEncoder {
+	return &encoder{
+		bytesPool: bytesPool,
+		opts:      opts,
+		pool:      pool,
+	}
+}
+
+func (e *encoder) Encode(srcTags ident.TagIterator) error {
+	if e.data != nil {
+		return errEncoderInUse
+	}
+
+	tags := srcTags.Duplicate()
+	defer tags.Close()
+
+	limits := e.opts.TagSerializationLimits()
+	numTags := tags.Remaining()
+	if numTags > int(limits.MaxNumberTags()) {
+		return fmt.Errorf("too many tags to encode (%d), limit is: %d",
+			numTags, limits.MaxNumberTags())
+	}
+
+	e.data = e.bytesPool.Get(e.opts.InitialCapacity())
+	e.data.IncRef()
+	e.data.Resize(0)
+
+	var header [headerLength]byte
+	byteOrder.PutUint16(header[:], headerMagicNumber)
+	header[2] = headerVersion
+	e.data.AppendAll(header[:])
+
+	var (
+		sorted        = true
+		encoded       = 0
+		prevNameStart = -1
+		prevNameEnd   = -1
+	)
+	for tags.Next() {
+		tag := tags.Current()
+		name, value := tag.Name.Bytes(), tag.Value.Bytes()
+		if len(name) == 0 {
+			e.Reset()
+			return errEmptyTagName
+		}
+
+		nameStart := e.data.Len() + lengthPrefixLength
+		if err := e.encodeLiteral(name, limits); err != nil {
+			e.Reset()
+			return err
+		}
+		if err := e.encodeLiteral(value, limits); err != nil {
+			e.Reset()
+			return err
+		}
+
+		if prevNameStart >= 0 {
+			prevName := e.data.Bytes()[prevNameStart:prevNameEnd]
+			if bytes.Compare(prevName, name) >= 0 {
+				sorted = false
+			}
+		}
+		prevNameStart, prevNameEnd = nameStart, nameStart+len(name)
+		encoded++
+	}
+
+	if err := tags.Err(); err != nil {
+		e.Reset()
+		return err
+	}
+	if encoded != numTags {
+		e.Reset()
+		return fmt.Errorf("tags iterator returned %d tags, expected: %d",
+			encoded, numTags)
+	}
+
+	data := e.data.Bytes()
+	byteOrder.PutUint16(data[headerNumTagOffset:], uint16(encoded))
+	if sorted {
+		data[headerFlagsOffset] |= flagSorted
+	}
+	return nil
+}
+
+func (e *encoder) encodeLiteral(v []byte, limits TagSerializationLimits) error {
+	if len(v) > int(limits.MaxTagLiteralLength()) {
+		return fmt.Errorf("tag literal too long (%d), limit is: %d",
+			len(v), limits.MaxTagLiteralLength())
+	}
+	byteOrder.PutUint16(e.buf[:], uint16(len(v)))
+	e.data.AppendAll(e.buf[:])
+	e.data.AppendAll(v)
+	return nil
+}
+
+func (e *encoder) Data() (checked.Bytes, bool) {
+	if e.data == nil {
+		return nil, false
+	}
+	return e.data, true
+}
+
+func (e *encoder) Reset() {
+	if e.data == nil {
+		return
+	}
+	e.data.DecRef()
+	// Only return the bytes to the pool if the caller did not
+	// take a reference to them.
+	if e.data.NumRef() == 0 {
+		e.data.Finalize()
+	}
+	e.data = nil
+}
+
+func (e *encoder) Finalize() {
+	e.Reset()
+	if e.pool == nil {
+		return
+	}
+	e.pool.Put(e)
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import (
+	"testing"
+
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+	"github.com/m3db/m3x/pool"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCheckedBytesPool() pool.CheckedBytesPool {
+	bytesPool := pool.NewCheckedBytesPool(nil, nil,
+		func(s []pool.Bucket) pool.BytesPool {
+			return pool.NewBytesPool(s, nil)
+		})
+	bytesPool.Init()
+	return bytesPool
+}
+
+func newTestTagEncoder() TagEncoder {
+	return NewTagEncoder(newTestCheckedBytesPool(), NewTagEncoderOptions(), nil)
+}
+
+func TestEncodeGolden(t *testing.T) {
+	e := newTestTagEncoder()
+	require.NoError(t, e.Encode(ident.MustNewTagStringsIterator("a", "bc", "d", "")))
+
+	data, ok := e.Data()
+	require.True(t, ok)
+	assert.Equal(t, []byte{
+		0x67, 0x74, // magic
+		0x01,       // version
+		0x01,       // flags, sorted
+		0x02, 0x00, // number of tags
+		0x01, 0x00, 'a',
+		0x02, 0x00, 'b', 'c',
+		0x01, 0x00, 'd',
+		0x00, 0x00,
+	}, data.Bytes())
+}
+
+func TestEncodeSortedFlag(t *testing.T) {
+	tests := []struct {
+		tags   []string
+		sorted bool
+	}{
+		{tags: nil, sorted: true},
+		{tags: []string{"a", "1"}, sorted: true},
+		{tags: []string{"a", "1", "b", "2", "c", "3"}, sorted: true},
+		{tags: []string{"b", "1", "a", "2"}, sorted: false},
+		{tags: []string{"a", "1", "a", "2"}, sorted: false},
+	}
+
+	for _, test := range tests {
+		e := newTestTagEncoder()
+		require.NoError(t, e.Encode(ident.MustNewTagStringsIterator(test.tags...)))
+		data, ok := e.Data()
+		require.True(t, ok)
+
+		d := NewTagDecoder(NewTagDecoderOptions(), nil)
+		d.Reset(data)
+		assert.Equal(t, test.sorted, d.Sorted(), "%v", test.tags)
+		d.Close()
+	}
+}
+
+func TestEncodeDoesNotAdvanceIterator(t *testing.T) {
+	iter := ident.MustNewTagStringsIterator("a", "1", "b", "2")
+	require.NoError(t, newTestTagEncoder().Encode(iter))
+	assert.Equal(t, 2, iter.Remaining())
+}
+
+func TestEncodeErrors(t *testing.T) {
+	limits := NewTagSerializationLimits().
+		SetMaxNumberTags(2).
+		SetMaxTagLiteralLength(3)
+	opts := NewTagEncoderOptions().SetTagSerializationLimits(limits)
+
+	tests := []struct {
+		name string
+		tags []string
+	}{
+		{name: "empty name", tags: []string{"", "1"}},
+		{name: "name too long", tags: []string{"abcd", "1"}},
+		{name: "value too long", tags: []string{"a", "1234"}},
+		{name: "too many tags", tags: []string{"a", "1", "b", "2", "c", "3"}},
+	}
+
+	for _, test := range tests {
+		e := NewTagEncoder(newTestCheckedBytesPool(), opts, nil)
+		err := e.Encode(ident.MustNewTagStringsIterator(test.tags...))
+		assert.Error(t, err, test.name)
+		_, ok := e.Data()
+		assert.False(t, ok, test.name)
+	}
+}
+
+func TestEncoderInUse(t *testing.T) {
+	e := newTestTagEncoder()
+	require.NoError(t, e.Encode(ident.EmptyTagIterator))
+	assert.Equal(t, errEncoderInUse, e.Encode(ident.EmptyTagIterator))
+
+	e.Reset()
+	assert.NoError(t, e.Encode(ident.EmptyTagIterator))
+}
+
+func TestEncoderResetKeepsReferencedData(t *testing.T) {
+	e := newTestTagEncoder()
+	require.NoError(t, e.Encode(ident.MustNewTagStringsIterator("a", "1")))
+
+	data, ok := e.Data()
+	require.True(t, ok)
+	data.IncRef()
+	e.Reset()
+
+	assert.Equal(t, 1, data.NumRef())
+	d := NewTagDecoder(NewTagDecoderOptions(), nil)
+	d.Reset(data)
+	require.True(t, d.Next())
+	assert.Equal(t, "a", d.Current().Name.String())
+	d.Close()
+	data.DecRef()
+}
+
+func TestEncoderPool(t *testing.T) {
+	p := NewTagEncoderPool(newTestCheckedBytesPool(), NewTagEncoderOptions(),
+		pool.NewObjectPoolOptions().SetSize(1))
+	p.Init()
+
+	e := p.Get()
+	require.NoError(t, e.Encode(ident.MustNewTagStringsIterator("a", "1")))
+	e.Finalize()
+
+	e = p.Get()
+	_, ok := e.Data()
+	assert.False(t, ok)
+}
+
+func TestEncodeBinaryTags(t *testing.T) {
+	name := checked.NewBytes([]byte{0x00, 0xff}, nil)
+	value := checked.NewBytes([]byte{0x01}, nil)
+	tags := ident.NewTags(ident.Tag{
+		Name:  ident.BinaryID(name),
+		Value: ident.BinaryID(value),
+	})
+
+	e := newTestTagEncoder()
+	require.NoError(t, e.Encode(ident.NewTagsIterator(tags)))
+	data, _ := e.Data()
+
+	d := NewTagDecoder(NewTagDecoderOptions(), nil)
+	d.Reset(data)
+	require.True(t, d.Next())
+	assert.Equal(t, []byte{0x00, 0xff}, d.Current().Name.Bytes())
+	assert.Equal(t, []byte{0x01}, d.Current().Value.Bytes())
+	d.Close()
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import (
+	"os"
+	"testing"
+
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func TestDecodeFuzzMalformed(t *testing.T) {
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 2000
+	props := gopter.NewProperties(parameters)
+
+	props.Property("decoding arbitrary bytes never panics", prop.ForAll(
+		func(data []byte) bool {
+			return decodeWithoutPanic(data)
+		},
+		gen.SliceOf(gen.UInt8()),
+	))
+
+	props.Property("decoding corrupted encodings never panics", prop.ForAll(
+		func(tags []string, idx int, v uint8, truncate int) bool {
+			data := encodeFuzzTags(tags)
+			if len(data) == 0 {
+				return true
+			}
+			data[idx%len(data)] = v
+			return decodeWithoutPanic(data[:len(data)-truncate%len(data)])
+		},
+		gen.SliceOfN(6, gen.AlphaString()),
+		gen.IntRange(0, 1<<16),
+		gen.UInt8(),
+		gen.IntRange(0, 1<<16),
+	))
+
+	props.Property("encoded tags round trip", prop.ForAll(
+		func(tags []string) bool {
+			data := encodeFuzzTags(tags)
+			if data == nil {
+				// Only encodings with empty names fail.
+				return true
+			}
+
+			d := NewTagDecoder(NewTagDecoderOptions(), nil)
+			defer d.Close()
+			d.Reset(checked.NewBytes(data, nil))
+			for i := 0; i+1 < len(tags); i += 2 {
+				if !d.Next() {
+					return false
+				}
+				current := d.Current()
+				if current.Name.String() != tags[i] ||
+					current.Value.String() != tags[i+1] {
+					return false
+				}
+			}
+			return !d.Next() && d.Err() == nil
+		},
+		gen.SliceOf(gen.AlphaString()),
+	))
+
+	reporter := gopter.NewFormatedReporter(true, 160, os.Stdout)
+	if !props.Run(reporter) {
+		t.Errorf("failed with initial seed: %d", parameters.Seed())
+	}
+}
+
+func encodeFuzzTags(tags []string) []byte {
+	if len(tags)%2 != 0 {
+		tags = tags[:len(tags)-1]
+	}
+
+	e := newTestTagEncoder()
+	defer e.Reset()
+	if err := e.Encode(ident.MustNewTagStringsIterator(tags...)); err != nil {
+		return nil
+	}
+	data, _ := e.Data()
+	return append([]byte(nil), data.Bytes()...)
+}
+
+func decodeWithoutPanic(data []byte) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	d := NewTagDecoder(NewTagDecoderOptions(), nil)
+	d.Reset(checked.NewBytes(data, nil))
+	for d.Next() {
+		d.Current()
+	}
+	d.Err()
+	d.Close()
+	return true
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import "math"
+
+const (
+	defaultInitialCapacity     = 1024
+	defaultMaxNumberTags       = math.MaxUint16
+	defaultMaxTagLiteralLength = 4096
+)
+
+type encodeOpts struct {
+	initialCapacity int
+	limits          TagSerializationLimits
+}
+
+// NewTagEncoderOptions returns a new TagEncoderOptions.
+func NewTagEncoderOptions() TagEncoderOptions {
+	return &encodeOpts{
+		initialCapacity: defaultInitialCapacity,
+		limits:          NewTagSerializationLimits(),
+	}
+}
+
+func (o *encodeOpts) SetInitialCapacity(v int) TagEncoderOptions {
+	opts := *o
+	opts.initialCapacity = v
+	return &opts
+}
+
+func (o *encodeOpts) InitialCapacity() int {
+	return o.initialCapacity
+}
+
+func (o *encodeOpts) SetTagSerializationLimits(v TagSerializationLimits) TagEncoderOptions {
+	opts := *o
+	opts.limits = v
+	return &opts
+}
+
+func (o *encodeOpts) TagSerializationLimits() TagSerializationLimits {
+	return o.limits
+}
+
+type decodeOpts struct {
+	limits TagSerializationLimits
+}
+
+// NewTagDecoderOptions returns a new TagDecoderOptions.
+func NewTagDecoderOptions() TagDecoderOptions {
+	return &decodeOpts{
+		limits: NewTagSerializationLimits(),
+	}
+}
+
+func (o *decodeOpts) SetTagSerializationLimits(v TagSerializationLimits) TagDecoderOptions {
+	opts := *o
+	opts.limits = v
+	return &opts
+}
+
+func (o *decodeOpts) TagSerializationLimits() TagSerializationLimits {
+	return o.limits
+}
+
+type limits struct {
+	maxNumberTags       uint16
+	maxTagLiteralLength uint16
+}
+
+// NewTagSerializationLimits returns a new TagSerializationLimits.
+func NewTagSerializationLimits() TagSerializationLimits {
+	return &limits{
+		maxNumberTags:       defaultMaxNumberTags,
+		maxTagLiteralLength: defaultMaxTagLiteralLength,
+	}
+}
+
+func (l *limits) SetMaxNumberTags(v uint16) TagSerializationLimits {
+	lim := *l
+	lim.maxNumberTags = v
+	return &lim
+}
+
+func (l *limits) MaxNumberTags() uint16 {
+	return l.maxNumberTags
+}
+
+func (l *limits) SetMaxTagLiteralLength(v uint16) TagSerializationLimits {
+	lim := *l
+	lim.maxTagLiteralLength = v
+	return &lim
+}
+
+func (l *limits) MaxTagLiteralLength() uint16 {
+	return l.maxTagLiteralLength
+}
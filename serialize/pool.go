@@ -0,0 +1,88 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package serialize
+
+import (
+	"github.com/m3db/m3x/pool"
+)
+
+type tagEncoderPool struct {
+	bytesPool pool.CheckedBytesPool
+	opts      TagEncoderOptions
+	pool      pool.ObjectPool
+}
+
+// NewTagEncoderPool returns a new TagEncoderPool.
+func NewTagEncoderPool(
+	bytesPool pool.CheckedBytesPool,
+	opts TagEncoderOptions,
+	poolOpts pool.ObjectPoolOptions,
+) TagEncoderPool {
+	return &tagEncoderPool{
+		bytesPool: bytesPool,
+		opts:      opts,
+		pool:      pool.NewObjectPool(poolOpts),
+	}
+}
+
+func (p *tagEncoderPool) Init() {
+	p.pool.Init(func() interface{} {
+		return NewTagEncoder(p.bytesPool, p.opts, p)
+	})
+}
+
+func (p *tagEncoderPool) Get() TagEncoder {
+	return p.pool.Get().(TagEncoder)
+}
+
+func (p *tagEncoderPool) Put(e TagEncoder) {
+	p.pool.Put(e)
+}
+
+type tagDecoderPool struct {
+	opts TagDecoderOptions
+	pool pool.ObjectPool
+}
+
+// NewTagDecoderPool returns a new TagDecoderPool.
+func NewTagDecoderPool(
+	opts TagDecoderOptions,
+	poolOpts pool.ObjectPoolOptions,
+) TagDecoderPool {
+	return &tagDecoderPool{
+		opts: opts,
+		pool: pool.NewObjectPool(poolOpts),
+	}
+}
+
+func (p *tagDecoderPool) Init() {
+	p.pool.Init(func() interface{} {
+		return NewTagDecoder(p.opts, p)
+	})
+}
+
+func (p *tagDecoderPool) Get() TagDecoder {
+	return p.pool.Get().(TagDecoder)
+}
+
+func (p *tagDecoderPool) Put(d TagDecoder) {
+	p.pool.Put(d)
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package serialize implements a compact binary format for tags.
+//
+// Encoded tags are a header followed by each tag name and value prefixed
+// with its length, all integers are little endian:
+//
+//	magic (uint16) | version (uint8) | flags (uint8) | number of tags (uint16)
+//	name length (uint16) | name | value length (uint16) | value | ...
+package serialize
+
+import (
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+)
+
+// TagEncoder encodes tags into checked bytes.
+type TagEncoder interface {
+	// Encode encodes the provided tags, the encoder must be reset before
+	// encoding again. The provided iterator is not advanced.
+	Encode(ident.TagIterator) error
+
+	// Data returns the encoded bytes and whether tags have been encoded,
+	// the bytes are only valid until the encoder is reset unless the
+	// caller takes a reference to them.
+	Data() (checked.Bytes, bool)
+
+	// Reset resets the encoder for reuse.
+	Reset()
+
+	// Finalize resets the encoder and returns it to the pool if any.
+	Finalize()
+}
+
+// TagEncoderPool pools TagEncoders.
+type TagEncoderPool interface {
+	// Init initializes the pool.
+	Init()
+
+	// Get returns an encoder.
+	Get() TagEncoder
+
+	// Put puts an encoder back into the pool.
+	Put(TagEncoder)
+}
+
+// TagEncoderOptions sets the knobs for TagEncoder limits.
+type TagEncoderOptions interface {
+	// SetInitialCapacity sets the initial capacity of the bytes encoded into.
+	SetInitialCapacity(v int) TagEncoderOptions
+
+	// InitialCapacity returns the initial capacity of the bytes encoded into.
+	InitialCapacity() int
+
+	// SetTagSerializationLimits sets the TagSerializationLimits.
+	SetTagSerializationLimits(v TagSerializationLimits) TagEncoderOptions
+
+	// TagSerializationLimits returns the TagSerializationLimits.
+	TagSerializationLimits() TagSerializationLimits
+}
+
+// TagDecoder decodes encoded tags, the IDs of the current tag reference
+// the encoded bytes without copying and are only valid until the decoder
+// is advanced or closed, callers must clone them to hold them longer.
+type TagDecoder interface {
+	ident.TagIterator
+
+	// Reset resets the decoder to decode the provided bytes, the decoder
+	// takes a reference to the bytes until reset again or closed.
+	Reset(checked.Bytes)
+
+	// Sorted returns whether the encoded tags are in ascending order of
+	// name with no duplicate names.
+	Sorted() bool
+}
+
+// TagDecoderPool pools TagDecoders.
+type TagDecoderPool interface {
+	// Init initializes the pool.
+	Init()
+
+	// Get returns a decoder.
+	Get() TagDecoder
+
+	// Put puts a decoder back into the pool.
+	Put(TagDecoder)
+}
+
+// TagDecoderOptions sets the knobs for TagDecoders.
+type TagDecoderOptions interface {
+	// SetTagSerializationLimits sets the TagSerializationLimits.
+	SetTagSerializationLimits(v TagSerializationLimits) TagDecoderOptions
+
+	// TagSerializationLimits returns the TagSerializationLimits.
+	TagSerializationLimits() TagSerializationLimits
+}
+
+// TagSerializationLimits sets the limits around tag serialization.
+type TagSerializationLimits interface {
+	// SetMaxNumberTags sets the maximum number of tags allowed.
+	SetMaxNumberTags(uint16) TagSerializationLimits
+
+	// MaxNumberTags returns the maximum number of tags allowed.
+	MaxNumberTags() uint16
+
+	// SetMaxTagLiteralLength sets the maximum length of a tag name or value.
+	SetMaxTagLiteralLength(uint16) TagSerializationLimits
+
+	// MaxTagLiteralLength returns the maximum length of a tag name or value.
+	MaxTagLiteralLength() uint16
+}
@@ -21,6 +21,7 @@
 package server
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/m3db/m3x/instrument"
@@ -40,10 +41,63 @@ type Configuration struct {
 
 	// KeepAlive period.
 	KeepAlivePeriod *time.Duration `yaml:"keepAlivePeriod"`
+
+	// Maximum number of open connections.
+	MaxConnections *int `yaml:"maxConnections"`
+
+	// Maximum number of open connections from a single remote IP.
+	MaxConnectionsPerIP *int `yaml:"maxConnectionsPerIP"`
+
+	// Maximum average number of connections accepted per second.
+	AcceptRateLimit *float64 `yaml:"acceptRateLimit"`
+
+	// Number of connections that can be accepted at once above the accept rate limit.
+	AcceptRateLimitBurst *int `yaml:"acceptRateLimitBurst"`
+
+	// TLS configuration, connections are served over TLS if it's set.
+	TLS *TLSConfiguration `yaml:"tls"`
+
+	// TLS handshake timeout.
+	TLSHandshakeTimeout *time.Duration `yaml:"tlsHandshakeTimeout"`
+
+	// Idle timeout after which connections are closed.
+	IdleTimeout *time.Duration `yaml:"idleTimeout"`
+
+	// Timeout of each read on a connection.
+	ReadTimeout *time.Duration `yaml:"readTimeout"`
+
+	// Timeout of each write on a connection.
+	WriteTimeout *time.Duration `yaml:"writeTimeout"`
+
+	// Number of listeners opened with SO_REUSEPORT, only available on linux.
+	ReusePortListeners *int `yaml:"reusePortListeners"`
 }
 
-// NewOptions creates server options.
+// NewOptions creates server options, it panics if the TLS configuration is
+// set but invalid, use NewOptionsWithTLS to handle the error instead.
 func (c Configuration) NewOptions(iOpts instrument.Options) Options {
+	opts, err := c.NewOptionsWithTLS(iOpts)
+	if err != nil {
+		panic(fmt.Errorf("invalid server TLS configuration: %v", err))
+	}
+	return opts
+}
+
+// NewOptionsWithTLS creates server options including the TLS configuration
+// if it's set.
+func (c Configuration) NewOptionsWithTLS(iOpts instrument.Options) (Options, error) {
+	opts := c.newOptions(iOpts)
+	if c.TLS == nil {
+		return opts, nil
+	}
+	tlsConfig, err := c.TLS.NewTLSConfig(iOpts)
+	if err != nil {
+		return nil, err
+	}
+	return opts.SetTLSConfig(tlsConfig), nil
+}
+
+func (c Configuration) newOptions(iOpts instrument.Options) Options {
 	opts := NewOptions().
 		SetRetryOptions(c.Retry.NewOptions(iOpts.MetricsScope())).
 		SetInstrumentOptions(iOpts)
@@ -53,10 +107,48 @@ func (c Configuration) NewOptions(iOpts instrument.Options) Options {
 	if c.KeepAlivePeriod != nil {
 		opts = opts.SetTCPConnectionKeepAlivePeriod(*c.KeepAlivePeriod)
 	}
+	if c.MaxConnections != nil {
+		opts = opts.SetMaxConnections(*c.MaxConnections)
+	}
+	if c.MaxConnectionsPerIP != nil {
+		opts = opts.SetMaxConnectionsPerIP(*c.MaxConnectionsPerIP)
+	}
+	if c.AcceptRateLimit != nil {
+		opts = opts.SetAcceptRateLimit(*c.AcceptRateLimit)
+	}
+	if c.AcceptRateLimitBurst != nil {
+		opts = opts.SetAcceptRateLimitBurst(*c.AcceptRateLimitBurst)
+	}
+	if c.TLSHandshakeTimeout != nil {
+		opts = opts.SetTLSHandshakeTimeout(*c.TLSHandshakeTimeout)
+	}
+	if c.IdleTimeout != nil {
+		opts = opts.SetIdleTimeout(*c.IdleTimeout)
+	}
+	if c.ReadTimeout != nil {
+		opts = opts.SetReadTimeout(*c.ReadTimeout)
+	}
+	if c.WriteTimeout != nil {
+		opts = opts.SetWriteTimeout(*c.WriteTimeout)
+	}
+	if c.ReusePortListeners != nil {
+		opts = opts.SetReusePortListeners(*c.ReusePortListeners)
+	}
 	return opts
 }
 
-// NewServer creates a new server.
+// NewServer creates a new server, it panics if the TLS configuration is set
+// but invalid, use NewServerWithTLS to handle the error instead.
 func (c Configuration) NewServer(handler Handler, iOpts instrument.Options) Server {
 	return NewServer(c.ListenAddress, handler, c.NewOptions(iOpts))
 }
+
+// NewServerWithTLS creates a new server that serves connections over TLS if
+// the TLS configuration is set, returning an error if it's invalid.
+func (c Configuration) NewServerWithTLS(handler Handler, iOpts instrument.Options) (Server, error) {
+	opts, err := c.NewOptionsWithTLS(iOpts)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(c.ListenAddress, handler, opts), nil
+}
@@ -35,6 +35,14 @@ func TestServerConfiguration(t *testing.T) {
 listenAddress: addr
 keepAliveEnabled: true
 keepAlivePeriod: 5s
+maxConnections: 100
+maxConnectionsPerIP: 10
+acceptRateLimit: 50.5
+acceptRateLimitBurst: 20
+idleTimeout: 1m
+readTimeout: 10s
+writeTimeout: 20s
+reusePortListeners: 4
 `
 
 	var cfg Configuration
@@ -46,6 +54,22 @@ keepAlivePeriod: 5s
 	opts := cfg.NewOptions(instrument.NewOptions())
 	require.Equal(t, 5*time.Second, opts.TCPConnectionKeepAlivePeriod())
 	require.True(t, opts.TCPConnectionKeepAlive())
+	require.Equal(t, 100, opts.MaxConnections())
+	require.Equal(t, 10, opts.MaxConnectionsPerIP())
+	require.Equal(t, 50.5, opts.AcceptRateLimit())
+	require.Equal(t, 20, opts.AcceptRateLimitBurst())
+	require.Equal(t, time.Minute, opts.IdleTimeout())
+	require.Equal(t, 10*time.Second, opts.ReadTimeout())
+	require.Equal(t, 20*time.Second, opts.WriteTimeout())
+	require.Equal(t, 4, opts.ReusePortListeners())
 
 	require.NotNil(t, cfg.NewServer(nil, instrument.NewOptions()))
+
+	// Without a TLS configuration the TLS variants match.
+	opts, err := cfg.NewOptionsWithTLS(instrument.NewOptions())
+	require.NoError(t, err)
+	require.Nil(t, opts.TLSConfig())
+	s, err := cfg.NewServerWithTLS(nil, instrument.NewOptions())
+	require.NoError(t, err)
+	require.NotNil(t, s)
 }
@@ -0,0 +1,143 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// conn wraps a connection passed to Handler.Handle, it applies the read
+// and write deadlines, closes the connection when it has been idle for too
+// long and counts the bytes read and written.
+type conn struct {
+	net.Conn
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
+	onIdle       func()
+
+	bytesRead    int64
+	bytesWritten int64
+
+	idleLock   sync.Mutex
+	idleTimer  *time.Timer
+	idleClosed bool
+}
+
+func newConn(
+	c net.Conn,
+	readTimeout time.Duration,
+	writeTimeout time.Duration,
+	idleTimeout time.Duration,
+	onIdle func(),
+) *conn {
+	wrapped := &conn{
+		Conn:         c,
+		readTimeout:  readTimeout,
+		writeTimeout: writeTimeout,
+		idleTimeout:  idleTimeout,
+		onIdle:       onIdle,
+	}
+	if idleTimeout > 0 {
+		wrapped.idleTimer = time.AfterFunc(idleTimeout, wrapped.closeIdle)
+	}
+	return wrapped
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddInt64(&c.bytesRead, int64(n))
+		c.resetIdle()
+	}
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		atomic.AddInt64(&c.bytesWritten, int64(n))
+		c.resetIdle()
+	}
+	return n, err
+}
+
+func (c *conn) Close() error {
+	c.idleLock.Lock()
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	c.idleLock.Unlock()
+	return c.Conn.Close()
+}
+
+// ConnectionState returns the TLS connection state of the underlying
+// connection so that the peer identity can be retrieved by the handler.
+func (c *conn) ConnectionState() tls.ConnectionState {
+	if s, ok := c.Conn.(connectionStater); ok {
+		return s.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+// NetConn returns the wrapped connection.
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *conn) resetIdle() {
+	if c.idleTimer == nil {
+		return
+	}
+	c.idleLock.Lock()
+	if !c.idleClosed {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+	c.idleLock.Unlock()
+}
+
+func (c *conn) closeIdle() {
+	c.idleLock.Lock()
+	c.idleClosed = true
+	c.idleLock.Unlock()
+
+	c.onIdle()
+	c.Conn.Close()
+}
+
+func (c *conn) numBytesRead() int64 {
+	return atomic.LoadInt64(&c.bytesRead)
+}
+
+func (c *conn) numBytesWritten() int64 {
+	return atomic.LoadInt64(&c.bytesWritten)
+}
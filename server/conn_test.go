@@ -0,0 +1,83 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnCountsBytes(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	c := newConn(server, 0, 0, 0, nil)
+	defer c.Close()
+
+	go func() {
+		client.Write([]byte("hello"))
+		client.Read(make([]byte, 16))
+	}()
+
+	n, err := c.Read(make([]byte, 16))
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+	_, err = c.Write([]byte("hi"))
+	require.NoError(t, err)
+
+	require.Equal(t, int64(5), c.numBytesRead())
+	require.Equal(t, int64(2), c.numBytesWritten())
+	require.Equal(t, tls.ConnectionState{}, c.ConnectionState())
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var numIdle int32
+	c := newConn(server, 0, 0, 50*time.Millisecond, func() {
+		atomic.AddInt32(&numIdle, 1)
+	})
+
+	_, err := c.Read(make([]byte, 16))
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&numIdle))
+	c.Close()
+}
+
+func TestConnCloseStopsIdleTimer(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	var numIdle int32
+	c := newConn(server, 0, 0, 20*time.Millisecond, func() {
+		atomic.AddInt32(&numIdle, 1)
+	})
+	require.NoError(t, c.Close())
+
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&numIdle))
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	xnet "github.com/m3db/m3x/net"
+	"github.com/m3db/m3x/tcp"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	handoffChildEnv = "M3X_SERVER_HANDOFF_CHILD"
+)
+
+// TestHandoffChildProcess is run in a child process started by
+// TestServerListenerHandoff, it serves the inherited listeners until its
+// stdin is closed.
+func TestHandoffChildProcess(t *testing.T) {
+	if os.Getenv(handoffChildEnv) != "1" {
+		return
+	}
+
+	listeners, err := xnet.InheritedListeners()
+	if err != nil || len(listeners) != 1 || listeners[0].Name != "tcp" {
+		os.Exit(1)
+	}
+	l, err := tcp.NewTCPKeepAliveListener(listeners[0].Listener, time.Minute)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	s := NewServer("", &funcHandler{handle: func(conn net.Conn) {
+		conn.Write([]byte("child"))
+	}}, NewOptions())
+	if err := s.Serve(l); err != nil {
+		os.Exit(1)
+	}
+
+	ioutil.ReadAll(os.Stdin)
+	s.Close()
+	os.Exit(0)
+}
+
+func TestServerListenerHandoff(t *testing.T) {
+	l, err := tcp.NewTCPListener(testListenAddress, time.Minute)
+	require.NoError(t, err)
+
+	s := NewServer(testListenAddress, &funcHandler{handle: func(conn net.Conn) {
+		conn.Write([]byte("parent"))
+	}}, NewOptions())
+	require.NoError(t, s.Serve(l))
+	address := l.Addr().String()
+	requireServedBy(t, address, "parent")
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChildProcess$")
+	cmd.Env = append(os.Environ(), handoffChildEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+
+	listeners := s.Listeners()
+	require.Len(t, listeners, 1)
+	require.NoError(t, xnet.StartProcessWithListeners(cmd, []xnet.NamedListener{
+		{Name: "tcp", Listener: listeners[0]},
+	}))
+
+	// The parent drains and stops serving, connections are then served by
+	// the child without being refused in between.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	for i := 0; i < 5; i++ {
+		requireServedBy(t, address, "child")
+	}
+
+	require.NoError(t, stdin.Close())
+	require.NoError(t, cmd.Wait())
+}
+
+func requireServedBy(t *testing.T, address string, expected string) {
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Second)))
+	b, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(b))
+}
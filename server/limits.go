@@ -0,0 +1,117 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package server
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// rejectionTimeout bounds how long writing a rejection message to a
+	// rejected connection can take, including completing a TLS handshake.
+	rejectionTimeout = time.Second
+)
+
+// RejectReason is the reason a connection is rejected by the server.
+type RejectReason string
+
+// A list of supported reject reasons.
+const (
+	// RejectReasonMaxConnections is the reason when the maximum number of
+	// connections is reached.
+	RejectReasonMaxConnections RejectReason = "max-connections"
+
+	// RejectReasonMaxConnectionsPerIP is the reason when the maximum number
+	// of connections from a single remote IP is reached.
+	RejectReasonMaxConnectionsPerIP RejectReason = "max-connections-per-ip"
+
+	// RejectReasonAcceptRateLimit is the reason when connections are being
+	// accepted faster than the accept rate limit.
+	RejectReasonAcceptRateLimit RejectReason = "accept-rate-limit"
+)
+
+var rejectReasons = []RejectReason{
+	RejectReasonMaxConnections,
+	RejectReasonMaxConnectionsPerIP,
+	RejectReasonAcceptRateLimit,
+}
+
+// RejectingHandler is a handler that provides the message written to
+// connections rejected by the server before they are closed.
+type RejectingHandler interface {
+	Handler
+
+	// RejectionMessage returns the message written to a connection rejected
+	// for the reason, no message is written if it returns nil.
+	RejectionMessage(reason RejectReason) []byte
+}
+
+// rateLimiter is a token bucket that allows limit events per second on
+// average with bursts of up to burst events.
+type rateLimiter struct {
+	limit  float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit float64, burst int, now time.Time) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		limit:  limit,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// allow returns true and takes a token if one is available at now.
+func (l *rateLimiter) allow(now time.Time) bool {
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.limit
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// remoteIP returns the IP of the remote address of a connection, or the
+// whole address if it does not have a port.
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -21,6 +21,7 @@
 package server
 
 import (
+	"crypto/tls"
 	"time"
 
 	"github.com/m3db/m3x/instrument"
@@ -34,6 +35,25 @@ const (
 	// By default the keep alive period is not set and the actual keep alive
 	// period is determined by the OS and the platform.
 	defaultTCPConnectionKeepAlivePeriod = 0
+
+	// By default there is no limit on the number of connections.
+	defaultMaxConnections      = 0
+	defaultMaxConnectionsPerIP = 0
+
+	// By default connections are not rate limited.
+	defaultAcceptRateLimit      = 0
+	defaultAcceptRateLimitBurst = 1
+
+	// By default TLS handshakes must complete within this period.
+	defaultTLSHandshakeTimeout = 10 * time.Second
+
+	// By default connections do not time out.
+	defaultIdleTimeout  = 0
+	defaultReadTimeout  = 0
+	defaultWriteTimeout = 0
+
+	// By default a single listener is opened without SO_REUSEPORT.
+	defaultReusePortListeners = 0
 )
 
 // Options provide a set of server options
@@ -64,6 +84,84 @@ type Options interface {
 
 	// TCPConnectionKeepAlivePeriod returns the keep alive period for tcp connections.
 	TCPConnectionKeepAlivePeriod() time.Duration
+
+	// SetMaxConnections sets the maximum number of open connections, new
+	// connections are rejected once it's reached. Zero means unlimited.
+	SetMaxConnections(value int) Options
+
+	// MaxConnections returns the maximum number of open connections.
+	MaxConnections() int
+
+	// SetMaxConnectionsPerIP sets the maximum number of open connections from
+	// a single remote IP, new connections from the IP are rejected once it's
+	// reached. Zero means unlimited.
+	SetMaxConnectionsPerIP(value int) Options
+
+	// MaxConnectionsPerIP returns the maximum number of open connections from
+	// a single remote IP.
+	MaxConnectionsPerIP() int
+
+	// SetAcceptRateLimit sets the maximum average number of connections accepted
+	// per second, connections above the rate are rejected. Zero means unlimited.
+	SetAcceptRateLimit(value float64) Options
+
+	// AcceptRateLimit returns the maximum average number of connections accepted
+	// per second.
+	AcceptRateLimit() float64
+
+	// SetAcceptRateLimitBurst sets the number of connections that can be accepted
+	// at once above the accept rate limit.
+	SetAcceptRateLimitBurst(value int) Options
+
+	// AcceptRateLimitBurst returns the number of connections that can be accepted
+	// at once above the accept rate limit.
+	AcceptRateLimitBurst() int
+
+	// SetTLSConfig sets the TLS config, connections are served over TLS if
+	// it's set.
+	SetTLSConfig(value *tls.Config) Options
+
+	// TLSConfig returns the TLS config.
+	TLSConfig() *tls.Config
+
+	// SetTLSHandshakeTimeout sets the timeout for TLS handshakes, connections
+	// that do not complete the handshake in time are closed.
+	SetTLSHandshakeTimeout(value time.Duration) Options
+
+	// TLSHandshakeTimeout returns the timeout for TLS handshakes.
+	TLSHandshakeTimeout() time.Duration
+
+	// SetIdleTimeout sets the idle timeout, connections that have not read or
+	// written any data for the timeout are closed. Zero means no timeout.
+	SetIdleTimeout(value time.Duration) Options
+
+	// IdleTimeout returns the idle timeout.
+	IdleTimeout() time.Duration
+
+	// SetReadTimeout sets the read timeout, each read on a connection passed
+	// to the handler fails if it does not complete within the timeout. Zero
+	// means no timeout.
+	SetReadTimeout(value time.Duration) Options
+
+	// ReadTimeout returns the read timeout.
+	ReadTimeout() time.Duration
+
+	// SetWriteTimeout sets the write timeout, each write on a connection passed
+	// to the handler fails if it does not complete within the timeout. Zero
+	// means no timeout.
+	SetWriteTimeout(value time.Duration) Options
+
+	// WriteTimeout returns the write timeout.
+	WriteTimeout() time.Duration
+
+	// SetReusePortListeners sets the number of listeners opened with
+	// SO_REUSEPORT by ListenAndServe, each with its own accept loop. Only
+	// available on linux, a single listener is opened if it's less than two.
+	SetReusePortListeners(value int) Options
+
+	// ReusePortListeners returns the number of listeners opened with
+	// SO_REUSEPORT by ListenAndServe.
+	ReusePortListeners() int
 }
 
 type options struct {
@@ -71,6 +169,16 @@ type options struct {
 	retryOpts                    retry.Options
 	tcpConnectionKeepAlive       bool
 	tcpConnectionKeepAlivePeriod time.Duration
+	maxConnections               int
+	maxConnectionsPerIP          int
+	acceptRateLimit              float64
+	acceptRateLimitBurst         int
+	tlsConfig                    *tls.Config
+	tlsHandshakeTimeout          time.Duration
+	idleTimeout                  time.Duration
+	readTimeout                  time.Duration
+	writeTimeout                 time.Duration
+	reusePortListeners           int
 }
 
 // NewOptions creates a new set of server options
@@ -80,6 +188,15 @@ func NewOptions() Options {
 		retryOpts:                    retry.NewOptions(),
 		tcpConnectionKeepAlive:       defaultTCPConnectionKeepAlive,
 		tcpConnectionKeepAlivePeriod: defaultTCPConnectionKeepAlivePeriod,
+		maxConnections:               defaultMaxConnections,
+		maxConnectionsPerIP:          defaultMaxConnectionsPerIP,
+		acceptRateLimit:              defaultAcceptRateLimit,
+		acceptRateLimitBurst:         defaultAcceptRateLimitBurst,
+		tlsHandshakeTimeout:          defaultTLSHandshakeTimeout,
+		idleTimeout:                  defaultIdleTimeout,
+		readTimeout:                  defaultReadTimeout,
+		writeTimeout:                 defaultWriteTimeout,
+		reusePortListeners:           defaultReusePortListeners,
 	}
 }
 
@@ -122,3 +239,103 @@ func (o *options) SetTCPConnectionKeepAlivePeriod(value time.Duration) Options {
 func (o *options) TCPConnectionKeepAlivePeriod() time.Duration {
 	return o.tcpConnectionKeepAlivePeriod
 }
+
+func (o *options) SetMaxConnections(value int) Options {
+	opts := *o
+	opts.maxConnections = value
+	return &opts
+}
+
+func (o *options) MaxConnections() int {
+	return o.maxConnections
+}
+
+func (o *options) SetMaxConnectionsPerIP(value int) Options {
+	opts := *o
+	opts.maxConnectionsPerIP = value
+	return &opts
+}
+
+func (o *options) MaxConnectionsPerIP() int {
+	return o.maxConnectionsPerIP
+}
+
+func (o *options) SetAcceptRateLimit(value float64) Options {
+	opts := *o
+	opts.acceptRateLimit = value
+	return &opts
+}
+
+func (o *options) AcceptRateLimit() float64 {
+	return o.acceptRateLimit
+}
+
+func (o *options) SetAcceptRateLimitBurst(value int) Options {
+	opts := *o
+	opts.acceptRateLimitBurst = value
+	return &opts
+}
+
+func (o *options) AcceptRateLimitBurst() int {
+	return o.acceptRateLimitBurst
+}
+
+func (o *options) SetTLSConfig(value *tls.Config) Options {
+	opts := *o
+	opts.tlsConfig = value
+	return &opts
+}
+
+func (o *options) TLSConfig() *tls.Config {
+	return o.tlsConfig
+}
+
+func (o *options) SetTLSHandshakeTimeout(value time.Duration) Options {
+	opts := *o
+	opts.tlsHandshakeTimeout = value
+	return &opts
+}
+
+func (o *options) TLSHandshakeTimeout() time.Duration {
+	return o.tlsHandshakeTimeout
+}
+
+func (o *options) SetIdleTimeout(value time.Duration) Options {
+	opts := *o
+	opts.idleTimeout = value
+	return &opts
+}
+
+func (o *options) IdleTimeout() time.Duration {
+	return o.idleTimeout
+}
+
+func (o *options) SetReadTimeout(value time.Duration) Options {
+	opts := *o
+	opts.readTimeout = value
+	return &opts
+}
+
+func (o *options) ReadTimeout() time.Duration {
+	return o.readTimeout
+}
+
+func (o *options) SetWriteTimeout(value time.Duration) Options {
+	opts := *o
+	opts.writeTimeout = value
+	return &opts
+}
+
+func (o *options) WriteTimeout() time.Duration {
+	return o.writeTimeout
+}
+
+func (o *options) SetReusePortListeners(value int) Options {
+	opts := *o
+	opts.reusePortListeners = value
+	return &opts
+}
+
+func (o *options) ReusePortListeners() int {
+	return o.reusePortListeners
+}
@@ -22,11 +22,16 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
+	"runtime/debug"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/m3db/m3x/clock"
 	"github.com/m3db/m3x/log"
 	xnet "github.com/m3db/m3x/net"
 	"github.com/m3db/m3x/retry"
@@ -41,15 +46,31 @@ type Server interface {
 	// handles data from those connections.
 	ListenAndServe() error
 
-	// Serve accepts and handles incoming connections on the listener l forever.
+	// Serve accepts and handles incoming connections on the listener l forever,
+	// it can be called multiple times to serve several listeners concurrently
+	// with the connections sharing the same limits.
 	Serve(l net.Listener) error
 
-	// Close closes the server.
+	// Listeners returns the listeners served by the server, for instance to
+	// pass them to a new process with xnet.StartProcessWithListeners.
+	Listeners() []net.Listener
+
+	// Close closes the server, open connections are closed immediately.
 	Close()
+
+	// Shutdown gracefully shuts down the server. The server stops accepting
+	// new connections, signals the handler to drain if it is a
+	// DrainableHandler and waits for all connections to end. If the context
+	// is done before then, the remaining connections are closed and the
+	// context error is returned.
+	Shutdown(ctx context.Context) error
 }
 
 // Handler can handle the data received on connection.
-// It's used in Server once a connection was established.
+// It's used in Server once a connection was established. The connection is
+// wrapped to count the bytes read and written and to apply any configured
+// read, write or idle timeouts, the accepted connection, e.g. a *net.TCPConn
+// or a *tls.Conn, is returned by its NetConn() net.Conn method.
 type Handler interface {
 	// Handle handles the data received on the connection, this function
 	// should be blocking until the connection is closed or received error.
@@ -59,16 +80,84 @@ type Handler interface {
 	Close()
 }
 
+// DrainableHandler is a handler that can finish the work in flight on its
+// connections when the server is shutting down.
+type DrainableHandler interface {
+	Handler
+
+	// Drain signals the handler to finish the current work on each of its
+	// connections and return from Handle without starting any new work.
+	Drain()
+}
+
+var (
+	connectionLifetimeBuckets = tally.MustMakeExponentialDurationBuckets(10*time.Millisecond, 4, 12)
+	connectionBytesBuckets    = tally.MustMakeExponentialValueBuckets(64, 4, 14)
+)
+
 type serverMetrics struct {
-	openConnections tally.Gauge
+	openConnections        tally.Gauge
+	acceptedConnections    tally.Counter
+	closedConnections      tally.Counter
+	idleTimeouts           tally.Counter
+	handlerPanics          tally.Counter
+	connectionLifetime     tally.Histogram
+	connectionBytesRead    tally.Histogram
+	connectionBytesWritten tally.Histogram
+	drainedConnections     tally.Counter
+	forceClosedConnections tally.Counter
+	drainDuration          tally.Timer
+	tlsHandshakeErrors     tally.Counter
+	rejectedConnections    map[RejectReason]tally.Counter
 }
 
 func newServerMetrics(scope tally.Scope) serverMetrics {
+	rejectedConnections := make(map[RejectReason]tally.Counter, len(rejectReasons))
+	for _, reason := range rejectReasons {
+		rejectedConnections[reason] = scope.Tagged(map[string]string{
+			"reason": string(reason),
+		}).Counter("rejected-connections")
+	}
 	return serverMetrics{
-		openConnections: scope.Gauge("open-connections"),
+		openConnections:        scope.Gauge("open-connections"),
+		acceptedConnections:    scope.Counter("accepted-connections"),
+		closedConnections:      scope.Counter("closed-connections"),
+		idleTimeouts:           scope.Counter("idle-timeouts"),
+		handlerPanics:          scope.Counter("handler-panics"),
+		connectionLifetime:     scope.Histogram("connection-lifetime", connectionLifetimeBuckets),
+		connectionBytesRead:    scope.Histogram("connection-bytes-read", connectionBytesBuckets),
+		connectionBytesWritten: scope.Histogram("connection-bytes-written", connectionBytesBuckets),
+		drainedConnections:     scope.Counter("drained-connections"),
+		forceClosedConnections: scope.Counter("force-closed-connections"),
+		drainDuration:          scope.Timer("drain-duration"),
+		tlsHandshakeErrors:     scope.Counter("tls-handshake-errors"),
+		rejectedConnections:    rejectedConnections,
+	}
+}
+
+var errServerClosed = errors.New("server is closed")
+
+type listenerMetrics struct {
+	acceptedConnections tally.Counter
+	openConnections     tally.Gauge
+}
+
+func newListenerMetrics(scope tally.Scope, address string) listenerMetrics {
+	scope = scope.Tagged(map[string]string{"listener": address})
+	return listenerMetrics{
+		acceptedConnections: scope.Counter("listener-accepted-connections"),
+		openConnections:     scope.Gauge("listener-open-connections"),
 	}
 }
 
+// serverListener is a listener served by the server.
+type serverListener struct {
+	net.Listener
+
+	numConns int32
+	metrics  listenerMetrics
+}
+
 type addConnectionFn func(conn net.Conn) bool
 type removeConnectionFn func(conn net.Conn)
 
@@ -76,17 +165,29 @@ type server struct {
 	sync.Mutex
 
 	address                      string
-	listener                     net.Listener
+	scope                        tally.Scope
 	log                          log.Logger
 	retryOpts                    retry.Options
 	reportInterval               time.Duration
 	tcpConnectionKeepAlive       bool
 	tcpConnectionKeepAlivePeriod time.Duration
-
+	reusePortListeners           int
+	maxConnections               int
+	maxConnectionsPerIP          int
+	acceptRateLimiter            *rateLimiter
+	tlsConfig                    *tls.Config
+	tlsHandshakeTimeout          time.Duration
+	idleTimeout                  time.Duration
+	readTimeout                  time.Duration
+	writeTimeout                 time.Duration
+	nowFn                        clock.NowFn
+
+	listeners  []*serverListener
 	closed     bool
 	closedChan chan struct{}
 	numConns   int32
 	conns      []net.Conn
+	connsPerIP map[string]int
 	wgConns    sync.WaitGroup
 	metrics    serverMetrics
 	handler    Handler
@@ -102,16 +203,31 @@ func NewServer(address string, handler Handler, opts Options) Server {
 
 	s := &server{
 		address:                      address,
+		scope:                        scope,
 		log:                          instrumentOpts.Logger(),
 		retryOpts:                    opts.RetryOptions(),
 		reportInterval:               instrumentOpts.ReportInterval(),
 		tcpConnectionKeepAlive:       opts.TCPConnectionKeepAlive(),
 		tcpConnectionKeepAlivePeriod: opts.TCPConnectionKeepAlivePeriod(),
+		reusePortListeners:           opts.ReusePortListeners(),
+		maxConnections:               opts.MaxConnections(),
+		maxConnectionsPerIP:          opts.MaxConnectionsPerIP(),
+		tlsConfig:                    opts.TLSConfig(),
+		tlsHandshakeTimeout:          opts.TLSHandshakeTimeout(),
+		idleTimeout:                  opts.IdleTimeout(),
+		readTimeout:                  opts.ReadTimeout(),
+		writeTimeout:                 opts.WriteTimeout(),
+		nowFn:                        time.Now,
 		closedChan:                   make(chan struct{}),
+		connsPerIP:                   make(map[string]int),
 		metrics:                      newServerMetrics(scope),
 		handler:                      handler,
 	}
 
+	if limit := opts.AcceptRateLimit(); limit > 0 {
+		s.acceptRateLimiter = newRateLimiter(limit, opts.AcceptRateLimitBurst(), s.nowFn())
+	}
+
 	// Set up the connection functions.
 	s.addConnectionFn = s.addConnection
 	s.removeConnectionFn = s.removeConnection
@@ -123,71 +239,196 @@ func NewServer(address string, handler Handler, opts Options) Server {
 }
 
 func (s *server) ListenAndServe() error {
-	listener, err := net.Listen("tcp", s.address)
-	if err != nil {
-		return err
+	if s.reusePortListeners <= 1 {
+		listener, err := net.Listen("tcp", s.address)
+		if err != nil {
+			return err
+		}
+		return s.Serve(listener)
 	}
 
-	return s.Serve(listener)
+	// All listeners bind to the address of the first one so that they share
+	// the same port if the address does not specify one.
+	listeners := make([]net.Listener, 0, s.reusePortListeners)
+	address := s.address
+	for i := 0; i < s.reusePortListeners; i++ {
+		listener, err := listenReusePort("tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, listener)
+		address = listener.Addr().String()
+	}
+	for _, listener := range listeners {
+		if err := s.Serve(listener); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (s *server) Serve(l net.Listener) error {
-	s.address = l.Addr().String()
-	s.listener = l
-	go s.serve()
+	address := l.Addr().String()
+	sl := &serverListener{
+		Listener: l,
+		metrics:  newListenerMetrics(s.scope, address),
+	}
+
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return errServerClosed
+	}
+	if len(s.listeners) == 0 {
+		s.address = address
+	}
+	s.listeners = append(s.listeners, sl)
+	s.Unlock()
+
+	go s.serve(sl)
 	return nil
 }
 
-func (s *server) serve() {
-	connCh, errCh := xnet.StartForeverAcceptLoop(s.listener, s.retryOpts)
+func (s *server) Listeners() []net.Listener {
+	s.Lock()
+	defer s.Unlock()
+
+	listeners := make([]net.Listener, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		listeners = append(listeners, l.Listener)
+	}
+	return listeners
+}
+
+func (s *server) serve(l *serverListener) {
+	connCh, errCh := xnet.StartForeverAcceptLoop(l, s.retryOpts)
 	for conn := range connCh {
 		conn := conn
-		if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if tcpConn, ok := unwrapTCPConn(conn); ok {
 			tcpConn.SetKeepAlive(s.tcpConnectionKeepAlive)
 			if s.tcpConnectionKeepAlivePeriod != 0 {
 				tcpConn.SetKeepAlivePeriod(s.tcpConnectionKeepAlivePeriod)
 			}
 		}
-		if !s.addConnectionFn(conn) {
-			conn.Close()
-		} else {
+		if s.tlsConfig != nil {
+			conn = tls.Server(conn, s.tlsConfig)
+		}
+		if s.addConnectionFn(conn) {
+			s.metrics.acceptedConnections.Inc(1)
+			l.metrics.acceptedConnections.Inc(1)
+			atomic.AddInt32(&l.numConns, 1)
 			s.wgConns.Add(1)
 			go func() {
-				s.handler.Handle(conn)
+				start := time.Now()
+				if s.handshake(conn) {
+					s.handle(conn)
+				}
 
 				conn.Close()
 				s.removeConnectionFn(conn)
+				atomic.AddInt32(&l.numConns, -1)
+				s.metrics.closedConnections.Inc(1)
+				s.metrics.connectionLifetime.RecordDuration(time.Since(start))
 				s.wgConns.Done()
 			}()
 		}
 	}
 	err := <-errCh
-	s.log.WithFields(log.NewErrField(err)).
-		Error("server unexpectedly closed")
+	if s.isClosed() {
+		return
+	}
+	s.log.WithFields(
+		log.NewField("listener", l.Addr().String()),
+		log.NewErrField(err),
+	).Error("server unexpectedly closed")
 }
 
-func (s *server) Close() {
+func (s *server) isClosed() bool {
 	s.Lock()
-	if s.closed {
-		s.Unlock()
-		return
+	defer s.Unlock()
+
+	return s.closed
+}
+
+// unwrapTCPConn returns the TCP connection of a connection accepted by a
+// listener wrapping a TCP listener, such as a PROXY protocol listener.
+func unwrapTCPConn(conn net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c, true
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil, false
+		}
 	}
-	s.closed = true
+}
 
-	close(s.closedChan)
-	openConns := make([]net.Conn, len(s.conns))
-	copy(openConns, s.conns)
-	s.Unlock()
+// handle passes the connection wrapped with the configured timeouts to the
+// handler, a panic in the handler is recovered and logged so that it only
+// affects the connection.
+func (s *server) handle(c net.Conn) {
+	wrapped := newConn(c, s.readTimeout, s.writeTimeout, s.idleTimeout, func() {
+		s.metrics.idleTimeouts.Inc(1)
+	})
+	defer func() {
+		if r := recover(); r != nil {
+			s.metrics.handlerPanics.Inc(1)
+			s.log.WithFields(
+				log.NewField("remoteAddress", c.RemoteAddr().String()),
+				log.NewField("panic", r),
+				log.NewField("stack", string(debug.Stack())),
+			).Error("connection handler panicked")
+		}
+		wrapped.Close()
+		s.metrics.connectionBytesRead.RecordValue(float64(wrapped.numBytesRead()))
+		s.metrics.connectionBytesWritten.RecordValue(float64(wrapped.numBytesWritten()))
+	}()
+
+	s.handler.Handle(wrapped)
+}
+
+// handshake completes the TLS handshake of a TLS connection so that the
+// peer identity is available to the handler, it returns false if the
+// handshake failed.
+func (s *server) handshake(conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	if s.tlsHandshakeTimeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(s.tlsHandshakeTimeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		s.metrics.tlsHandshakeErrors.Inc(1)
+		s.log.WithFields(
+			log.NewField("remoteAddress", conn.RemoteAddr().String()),
+			log.NewErrField(err),
+		).Debug("TLS handshake failed")
+		return false
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return true
+}
+
+func (s *server) Close() {
+	openConns, ok := s.markClosed()
+	if !ok {
+		return
+	}
 
 	// Close all open connections.
 	for _, conn := range openConns {
 		conn.Close()
 	}
 
-	// Close the listener.
-	if s.listener != nil {
-		s.listener.Close()
-	}
+	// Close the listeners.
+	s.closeListeners()
 
 	// Wait for all connection handlers to finish.
 	s.wgConns.Wait()
@@ -196,18 +437,156 @@ func (s *server) Close() {
 	s.handler.Close()
 }
 
-func (s *server) addConnection(conn net.Conn) bool {
+func (s *server) Shutdown(ctx context.Context) error {
+	openConns, ok := s.markClosed()
+	if !ok {
+		return nil
+	}
+
+	start := time.Now()
+
+	// Stop accepting new connections.
+	s.closeListeners()
+
+	// Signal the handler to finish the work in flight.
+	if h, ok := s.handler.(DrainableHandler); ok {
+		h.Drain()
+	}
+
+	doneCh := make(chan struct{})
+	go func() {
+		s.wgConns.Wait()
+		close(doneCh)
+	}()
+
+	var (
+		numDrained = len(openConns)
+		err        error
+	)
+	select {
+	case <-doneCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+
+		// Close the connections that did not end in time.
+		s.Lock()
+		remaining := make([]net.Conn, len(s.conns))
+		copy(remaining, s.conns)
+		s.Unlock()
+		for _, conn := range remaining {
+			conn.Close()
+		}
+		s.metrics.forceClosedConnections.Inc(int64(len(remaining)))
+		numDrained -= len(remaining)
+
+		<-doneCh
+	}
+
+	s.metrics.drainedConnections.Inc(int64(numDrained))
+	s.metrics.drainDuration.Record(time.Since(start))
+
+	// Close the handler.
+	s.handler.Close()
+
+	return err
+}
+
+func (s *server) closeListeners() {
+	s.Lock()
+	listeners := s.listeners
+	s.Unlock()
+
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// markClosed marks the server as closed and returns the connections open at
+// the time, it returns false if the server was already closed.
+func (s *server) markClosed() ([]net.Conn, bool) {
 	s.Lock()
 	defer s.Unlock()
 
 	if s.closed {
+		return nil, false
+	}
+	s.closed = true
+
+	close(s.closedChan)
+	openConns := make([]net.Conn, len(s.conns))
+	copy(openConns, s.conns)
+	return openConns, true
+}
+
+// addConnection adds an accepted connection, it returns false and closes
+// the connection if the server is closed or the connection is rejected.
+func (s *server) addConnection(conn net.Conn) bool {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		conn.Close()
+		return false
+	}
+	ip := remoteIP(conn)
+	if reason, rejected := s.checkLimitsWithLock(ip); rejected {
+		// Track the rejection while holding the lock so that closing the
+		// server waits for the rejection message to be written.
+		s.wgConns.Add(1)
+		s.Unlock()
+		s.reject(conn, reason)
 		return false
 	}
 	s.conns = append(s.conns, conn)
+	s.connsPerIP[ip]++
 	atomic.AddInt32(&s.numConns, 1)
+	s.Unlock()
 	return true
 }
 
+func (s *server) checkLimitsWithLock(ip string) (RejectReason, bool) {
+	if s.maxConnections > 0 && len(s.conns) >= s.maxConnections {
+		return RejectReasonMaxConnections, true
+	}
+	if s.maxConnectionsPerIP > 0 && s.connsPerIP[ip] >= s.maxConnectionsPerIP {
+		return RejectReasonMaxConnectionsPerIP, true
+	}
+	if s.acceptRateLimiter != nil && !s.acceptRateLimiter.allow(s.nowFn()) {
+		return RejectReasonAcceptRateLimit, true
+	}
+	return "", false
+}
+
+// reject counts a rejected connection and closes it, the rejection message
+// of the handler if there is one is written before closing the connection
+// without blocking the accept loop since a TLS connection may have to
+// complete its handshake first.
+func (s *server) reject(conn net.Conn, reason RejectReason) {
+	s.metrics.rejectedConnections[reason].Inc(1)
+
+	var msg []byte
+	if h, ok := s.handler.(RejectingHandler); ok {
+		msg = h.RejectionMessage(reason)
+	}
+	if len(msg) == 0 {
+		conn.Close()
+		s.wgConns.Done()
+		return
+	}
+
+	go func() {
+		defer s.wgConns.Done()
+
+		conn.SetDeadline(time.Now().Add(rejectionTimeout))
+		if _, err := conn.Write(msg); err != nil {
+			s.log.WithFields(
+				log.NewField("reason", string(reason)),
+				log.NewErrField(err),
+			).Debug("unable to write rejection message")
+		}
+		conn.Close()
+	}()
+}
+
 func (s *server) removeConnection(conn net.Conn) {
 	s.Lock()
 	defer s.Unlock()
@@ -219,6 +598,13 @@ func (s *server) removeConnection(conn net.Conn) {
 			s.conns[i] = s.conns[numConns-1]
 			s.conns = s.conns[:numConns-1]
 			atomic.AddInt32(&s.numConns, -1)
+
+			ip := remoteIP(conn)
+			if s.connsPerIP[ip] <= 1 {
+				delete(s.connsPerIP, ip)
+			} else {
+				s.connsPerIP[ip]--
+			}
 			return
 		}
 	}
@@ -231,6 +617,12 @@ func (s *server) reportMetrics() {
 		select {
 		case <-t.C:
 			s.metrics.openConnections.Update(float64(atomic.LoadInt32(&s.numConns)))
+			s.Lock()
+			listeners := s.listeners
+			s.Unlock()
+			for _, l := range listeners {
+				l.metrics.openConnections.Update(float64(atomic.LoadInt32(&l.numConns)))
+			}
 		case <-s.closedChan:
 			t.Stop()
 			return
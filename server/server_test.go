@@ -21,17 +21,26 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/m3db/m3x/instrument"
+	xnet "github.com/m3db/m3x/net"
 	"github.com/m3db/m3x/retry"
 
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 const (
@@ -75,7 +84,7 @@ func TestServerListenAndClose(t *testing.T) {
 
 	err := s.ListenAndServe()
 	require.NoError(t, err)
-	listenAddr := s.listener.Addr().String()
+	listenAddr := s.listeners[0].Addr().String()
 
 	for i := 0; i < numClients; i++ {
 		conn, err := net.Dial("tcp", listenAddr)
@@ -111,12 +120,544 @@ func TestServe(t *testing.T) {
 
 	err = s.Serve(l)
 	require.NoError(t, err)
-	require.Equal(t, l, s.listener)
+	require.Equal(t, l, s.listeners[0].Listener)
 	require.Equal(t, l.Addr().String(), s.address)
 
 	s.Close()
 }
 
+func TestServerShutdownDrainsConnections(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	h := newDrainHandler()
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+
+	numClients := 3
+	for i := 0; i < numClients; i++ {
+		conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	for h.numConns() < numClients {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	require.True(t, h.isDrained())
+	require.True(t, h.isClosed())
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(numClients), counters["drained-connections+"].Value())
+	require.Equal(t, int64(0), counters["force-closed-connections+"].Value())
+	require.Len(t, scope.Snapshot().Timers()["drain-duration+"].Values(), 1)
+
+	// Shutting down or closing again is a no-op.
+	require.NoError(t, s.Shutdown(ctx))
+	s.Close()
+}
+
+func TestServerShutdownForceClosesConnections(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	h := newMockHandler()
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+
+	// The mock handler blocks reading until the connection is closed.
+	numClients := 2
+	for i := 0; i < numClients; i++ {
+		conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	for atomic.LoadInt32(&s.numConns) < int32(numClients) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, s.Shutdown(ctx))
+	require.True(t, h.isClosed())
+	require.Equal(t, numClients, h.called())
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(0), counters["drained-connections+"].Value())
+	require.Equal(t, int64(numClients), counters["force-closed-connections+"].Value())
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetMaxConnections(2)
+	h := &rejectingHandler{drainHandler: newDrainHandler()}
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	for h.numConns() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	requireRejected(t, s, string(RejectReasonMaxConnections))
+	require.Equal(t, int64(1),
+		scope.Snapshot().Counters()["rejected-connections+reason=max-connections"].Value())
+}
+
+func TestServerMaxConnectionsPerIP(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetMaxConnectionsPerIP(1)
+	h := &rejectingHandler{drainHandler: newDrainHandler()}
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	for h.numConns() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	requireRejected(t, s, string(RejectReasonMaxConnectionsPerIP))
+	require.Equal(t, int64(1),
+		scope.Snapshot().Counters()["rejected-connections+reason=max-connections-per-ip"].Value())
+
+	// Connections from the IP are accepted again once the open one is closed.
+	conn.Close()
+	for atomic.LoadInt32(&s.numConns) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn, err = net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for h.numConns() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerAcceptRateLimit(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetAcceptRateLimit(1).
+		SetAcceptRateLimitBurst(2)
+	h := newMockHandler()
+	s := NewServer(testListenAddress, h, opts).(*server)
+	now := time.Now()
+	s.nowFn = func() time.Time { return now }
+	s.acceptRateLimiter = newRateLimiter(1, 2, now)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	for atomic.LoadInt32(&s.numConns) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Without a rejection message the connection is closed immediately.
+	requireRejected(t, s, "")
+	require.Equal(t, int64(1),
+		scope.Snapshot().Counters()["rejected-connections+reason=accept-rate-limit"].Value())
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope)).
+		SetIdleTimeout(200 * time.Millisecond)
+	h := newDrainHandler()
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Activity keeps the connection open past the idle timeout.
+	for i := 0; i < 6; i++ {
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&s.numConns))
+
+	// The connection is closed once idle.
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	for atomic.LoadInt32(&s.numConns) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	snapshot := scope.Snapshot()
+	require.Equal(t, int64(1), snapshot.Counters()["idle-timeouts+"].Value())
+	require.Equal(t, int64(1), snapshot.Counters()["accepted-connections+"].Value())
+	require.Equal(t, int64(1), snapshot.Counters()["closed-connections+"].Value())
+	require.Equal(t, int64(1), sumHistogram(snapshot.Histograms()["connection-lifetime+"].Durations()))
+	bytesRead := snapshot.Histograms()["connection-bytes-read+"].Values()
+	require.Equal(t, int64(1), sumHistogram(bytesRead))
+	require.Equal(t, int64(1), bytesRead[64])
+}
+
+func TestServerReadWriteTimeouts(t *testing.T) {
+	opts := NewOptions().
+		SetReadTimeout(50 * time.Millisecond).
+		SetWriteTimeout(time.Second)
+	errCh := make(chan error, 1)
+	h := &funcHandler{handle: func(conn net.Conn) {
+		_, err := conn.Read(make([]byte, 16))
+		errCh <- err
+	}}
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = <-errCh
+	require.Error(t, err)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+}
+
+func TestServerHandlerConnWithoutTimeouts(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	connCh := make(chan net.Conn, 1)
+	h := &funcHandler{handle: func(conn net.Conn) {
+		io.Copy(ioutil.Discard, conn)
+		connCh <- conn
+	}}
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	conn.Close()
+
+	// The accepted connection is available from the wrapped connection.
+	handled := <-connCh
+	wrapped, ok := handled.(interface{ NetConn() net.Conn })
+	require.True(t, ok)
+	_, ok = wrapped.NetConn().(*net.TCPConn)
+	require.True(t, ok)
+
+	// The bytes read are recorded without any timeouts configured.
+	for atomic.LoadInt32(&s.numConns) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	bytesRead := scope.Snapshot().Histograms()["connection-bytes-read+"].Values()
+	require.Equal(t, int64(1), sumHistogram(bytesRead))
+	require.Equal(t, int64(1), bytesRead[64])
+}
+
+func TestServerRecoversHandlerPanics(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().SetInstrumentOptions(instrument.NewOptions().SetMetricsScope(scope))
+	h := &funcHandler{handle: func(conn net.Conn) {
+		panic("handler error")
+	}}
+	s := NewServer(testListenAddress, h, opts).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+		require.NoError(t, err)
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, err = ioutil.ReadAll(conn)
+		require.NoError(t, err)
+		conn.Close()
+	}
+
+	for scope.Snapshot().Counters()["closed-connections+"].Value() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, int64(2), scope.Snapshot().Counters()["handler-panics+"].Value())
+}
+
+func sumHistogram(values interface{}) int64 {
+	var sum int64
+	switch v := values.(type) {
+	case map[float64]int64:
+		for _, n := range v {
+			sum += n
+		}
+	case map[time.Duration]int64:
+		for _, n := range v {
+			sum += n
+		}
+	}
+	return sum
+}
+
+type funcHandler struct {
+	handle func(conn net.Conn)
+}
+
+func (h *funcHandler) Handle(conn net.Conn) { h.handle(conn) }
+func (h *funcHandler) Close()               {}
+
+func TestServerMultipleListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "server")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	scope := tally.NewTestScope("", nil)
+	opts := NewOptions().
+		SetInstrumentOptions(instrument.NewOptions().
+			SetMetricsScope(scope).
+			SetReportInterval(10 * time.Millisecond)).
+		SetMaxConnections(2)
+	h := &rejectingHandler{drainHandler: newDrainHandler()}
+	s := NewServer(testListenAddress, h, opts).(*server)
+
+	tcpListener, err := net.Listen("tcp", testListenAddress)
+	require.NoError(t, err)
+	unixListener, err := net.Listen("unix", filepath.Join(dir, "server.sock"))
+	require.NoError(t, err)
+	require.NoError(t, s.Serve(tcpListener))
+	require.NoError(t, s.Serve(unixListener))
+	require.Equal(t, tcpListener.Addr().String(), s.address)
+
+	tcpConn, err := net.Dial("tcp", tcpListener.Addr().String())
+	require.NoError(t, err)
+	defer tcpConn.Close()
+	unixConn, err := net.Dial("unix", unixListener.Addr().String())
+	require.NoError(t, err)
+	defer unixConn.Close()
+	for h.numConns() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The limit is shared by the listeners.
+	conn, err := net.Dial("unix", unixListener.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	b, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, string(RejectReasonMaxConnections), string(b))
+	conn.Close()
+
+	tcpKey := "listener-open-connections+listener=" + tcpListener.Addr().String()
+	unixKey := "listener-open-connections+listener=" + unixListener.Addr().String()
+	for {
+		gauges := scope.Snapshot().Gauges()
+		if gauges[tcpKey] != nil && gauges[tcpKey].Value() == 1 &&
+			gauges[unixKey] != nil && gauges[unixKey].Value() == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1),
+		counters["listener-accepted-connections+listener="+tcpListener.Addr().String()].Value())
+	require.Equal(t, int64(1),
+		counters["listener-accepted-connections+listener="+unixListener.Addr().String()].Value())
+
+	// Closing the server closes all the listeners.
+	s.Close()
+	_, err = net.Dial("tcp", tcpListener.Addr().String())
+	require.Error(t, err)
+	_, err = net.Dial("unix", unixListener.Addr().String())
+	require.Error(t, err)
+
+	// Serving after close fails and closes the listener.
+	l, err := net.Listen("tcp", testListenAddress)
+	require.NoError(t, err)
+	require.Error(t, s.Serve(l))
+	_, err = net.Dial("tcp", l.Addr().String())
+	require.Error(t, err)
+}
+
+func TestServerReusePortListeners(t *testing.T) {
+	opts := NewOptions().SetReusePortListeners(4)
+	h := newDrainHandler()
+	s := NewServer(testListenAddress, h, opts).(*server)
+
+	err := s.ListenAndServe()
+	if runtime.GOOS != "linux" {
+		require.Error(t, err)
+		return
+	}
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.Len(t, s.listeners, 4)
+	for _, l := range s.listeners {
+		require.Equal(t, s.address, l.Addr().String())
+	}
+
+	numClients := 16
+	for i := 0; i < numClients; i++ {
+		conn, err := net.Dial("tcp", s.address)
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	for h.numConns() < numClients {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var total int32
+	for _, l := range s.listeners {
+		total += atomic.LoadInt32(&l.numConns)
+	}
+	require.Equal(t, int32(numClients), total)
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	type result struct {
+		remoteAddr string
+		header     xnet.ProxyHeader
+		ok         bool
+	}
+	resultCh := make(chan result, 1)
+	h := &funcHandler{handle: func(conn net.Conn) {
+		header, ok := xnet.ProxyHeaderFromConn(conn)
+		resultCh <- result{remoteAddr: conn.RemoteAddr().String(), header: header, ok: ok}
+	}}
+	opts := NewOptions().SetMaxConnectionsPerIP(1)
+	s := NewServer(testListenAddress, h, opts).(*server)
+
+	l, err := net.Listen("tcp", testListenAddress)
+	require.NoError(t, err)
+	require.NoError(t, s.Serve(xnet.NewProxyProtocolListener(l, xnet.NewProxyProtocolOptions())))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("PROXY TCP4 192.168.0.1 10.0.0.1 1234 443\r\n"))
+	require.NoError(t, err)
+
+	r := <-resultCh
+	require.Equal(t, "192.168.0.1:1234", r.remoteAddr)
+	require.True(t, r.ok)
+	require.Equal(t, 1, r.header.Version)
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(2, 3, now)
+	for i := 0; i < 3; i++ {
+		require.True(t, l.allow(now))
+	}
+	require.False(t, l.allow(now))
+
+	now = now.Add(500 * time.Millisecond)
+	require.True(t, l.allow(now))
+	require.False(t, l.allow(now))
+
+	// Tokens do not accumulate above the burst.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		require.True(t, l.allow(now))
+	}
+	require.False(t, l.allow(now))
+}
+
+// requireRejected dials the server and requires the connection to be closed
+// by the server after receiving the expected message.
+func requireRejected(t *testing.T, s *server, expected string) {
+	conn, err := net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	b, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(b))
+}
+
+type rejectingHandler struct {
+	*drainHandler
+}
+
+func (h *rejectingHandler) RejectionMessage(reason RejectReason) []byte {
+	return []byte(reason)
+}
+
+type drainHandler struct {
+	sync.Mutex
+
+	conns   []net.Conn
+	drained bool
+	closed  bool
+}
+
+func newDrainHandler() *drainHandler { return &drainHandler{} }
+
+func (h *drainHandler) Handle(conn net.Conn) {
+	h.Lock()
+	h.conns = append(h.conns, conn)
+	h.Unlock()
+
+	b := make([]byte, 16)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return
+		}
+	}
+}
+
+func (h *drainHandler) Drain() {
+	h.Lock()
+	defer h.Unlock()
+
+	h.drained = true
+	for _, conn := range h.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (h *drainHandler) Close() {
+	h.Lock()
+	h.closed = true
+	h.Unlock()
+}
+
+func (h *drainHandler) numConns() int {
+	h.Lock()
+	defer h.Unlock()
+
+	return len(h.conns)
+}
+
+func (h *drainHandler) isDrained() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.drained
+}
+
+func (h *drainHandler) isClosed() bool {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.closed
+}
+
 type mockHandler struct {
 	sync.Mutex
 
@@ -0,0 +1,358 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/m3db/m3x/clock"
+	"github.com/m3db/m3x/instrument"
+	"github.com/m3db/m3x/log"
+
+	"github.com/uber-go/tally"
+)
+
+const (
+	defaultTLSMinVersion          = tls.VersionTLS12
+	defaultTLSCertReloadInterval  = 10 * time.Second
+	tlsClientAuthNone             = "none"
+	tlsClientAuthRequest          = "request"
+	tlsClientAuthRequire          = "require"
+	tlsClientAuthVerifyIfGiven    = "verify-if-given"
+	tlsClientAuthRequireAndVerify = "require-and-verify"
+)
+
+var (
+	errNoCertificateInClientCAFile = errors.New("no certificate found in client CA file")
+	errClientCAFileRequired        = errors.New("client CA file is required to verify client certificates")
+
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	tlsClientAuthTypes = map[string]tls.ClientAuthType{
+		tlsClientAuthNone:             tls.NoClientCert,
+		tlsClientAuthRequest:          tls.RequestClientCert,
+		tlsClientAuthRequire:          tls.RequireAnyClientCert,
+		tlsClientAuthVerifyIfGiven:    tls.VerifyClientCertIfGiven,
+		tlsClientAuthRequireAndVerify: tls.RequireAndVerifyClientCert,
+	}
+
+	tlsCipherSuites = map[string]uint16{
+		"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	}
+)
+
+// TLSConfiguration configs TLS for a server.
+type TLSConfiguration struct {
+	// Certificate file in PEM format.
+	CertFile string `yaml:"certFile" validate:"nonzero"`
+
+	// Private key file in PEM format.
+	KeyFile string `yaml:"keyFile" validate:"nonzero"`
+
+	// CA certificates file in PEM format used to verify client certificates.
+	ClientCAFile string `yaml:"clientCAFile"`
+
+	// Client authentication mode, one of none, request, require,
+	// verify-if-given and require-and-verify. Defaults to require-and-verify
+	// if a client CA file is set and none otherwise.
+	ClientAuth string `yaml:"clientAuth"`
+
+	// Minimum TLS version, one of 1.0, 1.1, 1.2 and 1.3. Defaults to 1.2.
+	MinVersion string `yaml:"minVersion"`
+
+	// Cipher suites for TLS 1.2 and below, defaults to the Go defaults.
+	CipherSuites []string `yaml:"cipherSuites"`
+
+	// How often the files are checked for changes, the check happens
+	// during a handshake so no files are read while idle.
+	ReloadInterval *time.Duration `yaml:"reloadInterval"`
+}
+
+// NewTLSConfig creates a TLS config that reloads the certificate, key
+// and client CA files when they change, new connections use the reloaded
+// files while existing connections are unaffected.
+func (c TLSConfiguration) NewTLSConfig(iOpts instrument.Options) (*tls.Config, error) {
+	base := &tls.Config{
+		MinVersion: defaultTLSMinVersion,
+		ClientAuth: tls.NoClientCert,
+	}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS min version: %s", c.MinVersion)
+		}
+		base.MinVersion = v
+	}
+	for _, name := range c.CipherSuites {
+		suite, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS cipher suite: %s", name)
+		}
+		base.CipherSuites = append(base.CipherSuites, suite)
+	}
+	if c.ClientCAFile != "" {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if c.ClientAuth != "" {
+		clientAuth, ok := tlsClientAuthTypes[c.ClientAuth]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS client auth: %s", c.ClientAuth)
+		}
+		base.ClientAuth = clientAuth
+	}
+	if c.ClientCAFile == "" &&
+		(base.ClientAuth == tls.VerifyClientCertIfGiven ||
+			base.ClientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, errClientCAFileRequired
+	}
+
+	reloadInterval := defaultTLSCertReloadInterval
+	if c.ReloadInterval != nil {
+		reloadInterval = *c.ReloadInterval
+	}
+
+	r := newCertReloader(c.CertFile, c.KeyFile, c.ClientCAFile, reloadInterval, base, iOpts)
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{GetConfigForClient: r.configForClient}, nil
+}
+
+type certReloaderMetrics struct {
+	reloads      tally.Counter
+	reloadErrors tally.Counter
+	expiry       tally.Gauge
+}
+
+func newCertReloaderMetrics(scope tally.Scope) certReloaderMetrics {
+	return certReloaderMetrics{
+		reloads:      scope.Counter("tls-certificate-reloads"),
+		reloadErrors: scope.Counter("tls-certificate-reload-errors"),
+		expiry:       scope.Gauge("tls-certificate-expiry"),
+	}
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// certReloader serves TLS configs built from a set of files and reloads the
+// files when they change.
+type certReloader struct {
+	sync.Mutex
+
+	files          []string
+	certFile       string
+	keyFile        string
+	clientCAFile   string
+	reloadInterval time.Duration
+	base           *tls.Config
+	nowFn          clock.NowFn
+	log            log.Logger
+	metrics        certReloaderMetrics
+
+	config    *tls.Config
+	states    []fileState
+	lastCheck time.Time
+}
+
+func newCertReloader(
+	certFile, keyFile, clientCAFile string,
+	reloadInterval time.Duration,
+	base *tls.Config,
+	iOpts instrument.Options,
+) *certReloader {
+	files := []string{certFile, keyFile}
+	if clientCAFile != "" {
+		files = append(files, clientCAFile)
+	}
+	return &certReloader{
+		files:          files,
+		certFile:       certFile,
+		keyFile:        keyFile,
+		clientCAFile:   clientCAFile,
+		reloadInterval: reloadInterval,
+		base:           base,
+		nowFn:          time.Now,
+		log:            iOpts.Logger(),
+		metrics:        newCertReloaderMetrics(iOpts.MetricsScope()),
+	}
+}
+
+func (r *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := r.nowFn()
+	if now.Sub(r.lastCheck) < r.reloadInterval {
+		return r.config, nil
+	}
+	r.lastCheck = now
+
+	states, err := r.fileStates()
+	if err != nil || r.changed(states) {
+		if err = r.loadWithLock(); err != nil {
+			r.metrics.reloadErrors.Inc(1)
+			r.log.WithFields(log.NewErrField(err)).
+				Error("unable to reload TLS certificates, using previous certificates")
+		} else {
+			r.metrics.reloads.Inc(1)
+		}
+	}
+	return r.config, nil
+}
+
+func (r *certReloader) load() error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.lastCheck = r.nowFn()
+	return r.loadWithLock()
+}
+
+func (r *certReloader) loadWithLock() error {
+	// Read the states first so that a change while loading is picked up by
+	// the next check.
+	states, err := r.fileStates()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	config := r.base.Clone()
+	config.Certificates = []tls.Certificate{cert}
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errNoCertificateInClientCAFile
+		}
+		config.ClientCAs = pool
+	}
+
+	r.config = config
+	r.states = states
+	r.metrics.expiry.Update(float64(leaf.NotAfter.Unix()))
+	return nil
+}
+
+func (r *certReloader) fileStates() ([]fileState, error) {
+	states := make([]fileState, 0, len(r.files))
+	for _, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, fileState{modTime: info.ModTime(), size: info.Size()})
+	}
+	return states, nil
+}
+
+func (r *certReloader) changed(states []fileState) bool {
+	for i, state := range states {
+		if !state.modTime.Equal(r.states[i].modTime) || state.size != r.states[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerIdentity is the identity of a peer verified with TLS.
+type PeerIdentity struct {
+	// CommonName is the common name of the peer certificate subject.
+	CommonName string
+
+	// DNSNames are the DNS subject alternative names of the peer certificate.
+	DNSNames []string
+
+	// URIs are the URI subject alternative names of the peer certificate.
+	URIs []string
+
+	// Certificate is the verified peer certificate.
+	Certificate *x509.Certificate
+}
+
+type connectionStater interface {
+	ConnectionState() tls.ConnectionState
+}
+
+// PeerIdentityFromConn returns the verified identity of the peer of a
+// connection passed to Handler.Handle, it returns false if the connection
+// does not use TLS or the peer did not present a verified certificate.
+func PeerIdentityFromConn(conn net.Conn) (PeerIdentity, bool) {
+	c, ok := conn.(connectionStater)
+	if !ok {
+		return PeerIdentity{}, false
+	}
+	state := c.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return PeerIdentity{}, false
+	}
+	cert := state.VerifiedChains[0][0]
+	identity := PeerIdentity{
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		Certificate: cert,
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	return identity, true
+}
@@ -0,0 +1,475 @@
+// Copyright (c) 2019 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3x/instrument"
+
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour).Truncate(time.Second),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	require.NoError(t, err)
+	return cert
+}
+
+type testTLSFiles struct {
+	dir      string
+	ca       *testCert
+	server   *testCert
+	caPool   *x509.CertPool
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+func newTestTLSFiles(t *testing.T) *testTLSFiles {
+	dir, err := ioutil.TempDir("", "server-tls")
+	require.NoError(t, err)
+
+	ca := newTestCert(t, "ca", 1, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	f := &testTLSFiles{
+		dir:      dir,
+		ca:       ca,
+		caPool:   pool,
+		certFile: filepath.Join(dir, "cert.pem"),
+		keyFile:  filepath.Join(dir, "key.pem"),
+		caFile:   filepath.Join(dir, "ca.pem"),
+	}
+	require.NoError(t, ioutil.WriteFile(f.caFile, ca.certPEM, 0600))
+	f.writeServerCert(t, newTestCert(t, "server", 2, ca), time.Now())
+	return f
+}
+
+func (f *testTLSFiles) writeServerCert(t *testing.T, cert *testCert, modTime time.Time) {
+	f.server = cert
+	require.NoError(t, ioutil.WriteFile(f.certFile, cert.certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(f.keyFile, cert.keyPEM, 0600))
+	require.NoError(t, os.Chtimes(f.certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(f.keyFile, modTime, modTime))
+}
+
+func (f *testTLSFiles) close() {
+	os.RemoveAll(f.dir)
+}
+
+func (f *testTLSFiles) configuration() TLSConfiguration {
+	reloadInterval := time.Duration(0)
+	return TLSConfiguration{
+		CertFile:       f.certFile,
+		KeyFile:        f.keyFile,
+		ClientCAFile:   f.caFile,
+		ReloadInterval: &reloadInterval,
+	}
+}
+
+func TestTLSConfigurationUnmarshal(t *testing.T) {
+	str := `
+listenAddress: addr
+tlsHandshakeTimeout: 3s
+tls:
+  certFile: /cert.pem
+  keyFile: /key.pem
+  clientCAFile: /ca.pem
+  clientAuth: verify-if-given
+  minVersion: "1.3"
+  cipherSuites:
+    - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+  reloadInterval: 1m
+`
+
+	var cfg Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(str), &cfg))
+	require.Equal(t, 3*time.Second, *cfg.TLSHandshakeTimeout)
+	require.Equal(t, "/cert.pem", cfg.TLS.CertFile)
+	require.Equal(t, "/key.pem", cfg.TLS.KeyFile)
+	require.Equal(t, "/ca.pem", cfg.TLS.ClientCAFile)
+	require.Equal(t, "verify-if-given", cfg.TLS.ClientAuth)
+	require.Equal(t, "1.3", cfg.TLS.MinVersion)
+	require.Equal(t, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, cfg.TLS.CipherSuites)
+	require.Equal(t, time.Minute, *cfg.TLS.ReloadInterval)
+
+	// The files do not exist.
+	require.Panics(t, func() {
+		cfg.NewOptions(instrument.NewOptions())
+	})
+	require.Panics(t, func() {
+		cfg.NewServer(nil, instrument.NewOptions())
+	})
+	_, err := cfg.NewOptionsWithTLS(instrument.NewOptions())
+	require.Error(t, err)
+	_, err = cfg.NewServerWithTLS(nil, instrument.NewOptions())
+	require.Error(t, err)
+}
+
+func TestTLSConfigurationNewTLSConfig(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	cfg := files.configuration()
+	cfg.MinVersion = "1.1"
+	cfg.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}
+	config, err := cfg.NewTLSConfig(instrument.NewOptions())
+	require.NoError(t, err)
+
+	current, err := config.GetConfigForClient(nil)
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS11), current.MinVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, current.CipherSuites)
+	require.Equal(t, tls.RequireAndVerifyClientCert, current.ClientAuth)
+	require.Len(t, current.Certificates, 1)
+	require.NotNil(t, current.ClientCAs)
+}
+
+func TestConfigurationNewOptionsWithTLS(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	tlsCfg := files.configuration()
+	cfg := Configuration{ListenAddress: testListenAddress, TLS: &tlsCfg}
+	opts, err := cfg.NewOptionsWithTLS(instrument.NewOptions())
+	require.NoError(t, err)
+	require.NotNil(t, opts.TLSConfig())
+}
+
+func TestConfigurationNewServerAppliesTLS(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	str := fmt.Sprintf(`
+listenAddress: %s
+tls:
+  certFile: %s
+  keyFile: %s
+  clientCAFile: %s
+  reloadInterval: 0s
+`, testListenAddress, files.certFile, files.keyFile, files.caFile)
+
+	var cfg Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(str), &cfg))
+	require.NotNil(t, cfg.NewOptions(instrument.NewOptions()).TLSConfig())
+
+	s := cfg.NewServer(newIdentityHandler(), instrument.NewOptions())
+	require.NotNil(t, s.(*server).tlsConfig)
+}
+
+func TestTLSConfigurationNewTLSConfigErrors(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	tests := []func(cfg *TLSConfiguration){
+		func(cfg *TLSConfiguration) { cfg.MinVersion = "2.0" },
+		func(cfg *TLSConfiguration) { cfg.CipherSuites = []string{"unknown"} },
+		func(cfg *TLSConfiguration) { cfg.ClientAuth = "unknown" },
+		func(cfg *TLSConfiguration) {
+			cfg.ClientCAFile = ""
+			cfg.ClientAuth = tlsClientAuthRequireAndVerify
+		},
+		func(cfg *TLSConfiguration) { cfg.ClientCAFile = files.certFile + ".missing" },
+		func(cfg *TLSConfiguration) { cfg.KeyFile = files.caFile },
+	}
+	for _, fn := range tests {
+		cfg := files.configuration()
+		fn(&cfg)
+		_, err := cfg.NewTLSConfig(instrument.NewOptions())
+		require.Error(t, err)
+	}
+
+	// Without a client CA file clients are not verified by default.
+	cfg := files.configuration()
+	cfg.ClientCAFile = ""
+	cfg.ClientAuth = ""
+	config, err := cfg.NewTLSConfig(instrument.NewOptions())
+	require.NoError(t, err)
+	current, err := config.GetConfigForClient(nil)
+	require.NoError(t, err)
+	require.Equal(t, tls.NoClientCert, current.ClientAuth)
+}
+
+func TestServerMutualTLS(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	scope := tally.NewTestScope("", nil)
+	iOpts := instrument.NewOptions().SetMetricsScope(scope)
+	config, err := files.configuration().NewTLSConfig(iOpts)
+	require.NoError(t, err)
+
+	h := newIdentityHandler()
+	s := NewServer(testListenAddress, h, NewOptions().
+		SetInstrumentOptions(iOpts).
+		SetTLSConfig(config)).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	client := newTestCert(t, "client", 3, files.ca)
+	conn, err := tls.Dial("tcp", s.listeners[0].Addr().String(), &tls.Config{
+		RootCAs:      files.caPool,
+		ServerName:   "server",
+		Certificates: []tls.Certificate{client.tlsCertificate(t)},
+	})
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	identity := <-h.identities
+	require.Equal(t, "client", identity.CommonName)
+	require.Equal(t, []string{"client"}, identity.DNSNames)
+	require.Equal(t, client.cert.SerialNumber, identity.Certificate.SerialNumber)
+
+	gauges := scope.Snapshot().Gauges()
+	require.Equal(t, float64(files.server.cert.NotAfter.Unix()),
+		gauges["tls-certificate-expiry+"].Value())
+
+	// Clients without a certificate fail the handshake.
+	conn, err = tls.Dial("tcp", s.listeners[0].Addr().String(), &tls.Config{
+		RootCAs:    files.caPool,
+		ServerName: "server",
+	})
+	if err == nil {
+		// The client may only see the failure when reading.
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	require.Error(t, err)
+
+	// Plaintext clients fail the handshake.
+	plain, err := net.Dial("tcp", s.listeners[0].Addr().String())
+	require.NoError(t, err)
+	_, err = plain.Write([]byte("not a client hello\r\n\r\n"))
+	require.NoError(t, err)
+	plain.Close()
+
+	for scope.Snapshot().Counters()["tls-handshake-errors+"].Value() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 1, h.numHandled())
+}
+
+func TestServerTLSRejectsSilentClientWithoutBlocking(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	scope := tally.NewTestScope("", nil)
+	iOpts := instrument.NewOptions().SetMetricsScope(scope)
+	config, err := files.configuration().NewTLSConfig(iOpts)
+	require.NoError(t, err)
+
+	h := &rejectingHandler{drainHandler: newDrainHandler()}
+	s := NewServer(testListenAddress, h, NewOptions().
+		SetInstrumentOptions(iOpts).
+		SetTLSConfig(config).
+		SetMaxConnections(1)).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	addr := s.listeners[0].Addr().String()
+	client := newTestCert(t, "client", 3, files.ca)
+	clientConfig := &tls.Config{
+		RootCAs:      files.caPool,
+		ServerName:   "server",
+		Certificates: []tls.Certificate{client.tlsCertificate(t)},
+	}
+	conn, err := tls.Dial("tcp", addr, clientConfig)
+	require.NoError(t, err)
+	defer conn.Close()
+	for h.numConns() < 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A client past the connection limit that never sends a ClientHello.
+	silent, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer silent.Close()
+
+	// The accept loop still accepts and rejects further clients.
+	rejected, err := tls.Dial("tcp", addr, clientConfig)
+	require.NoError(t, err)
+	defer rejected.Close()
+	require.NoError(t, rejected.SetReadDeadline(time.Now().Add(5*time.Second)))
+	b, err := ioutil.ReadAll(rejected)
+	require.NoError(t, err)
+	require.Equal(t, string(RejectReasonMaxConnections), string(b))
+
+	// The silent client is closed once the rejection times out.
+	require.NoError(t, silent.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = ioutil.ReadAll(silent)
+	require.NoError(t, err)
+	require.Equal(t, int64(2),
+		scope.Snapshot().Counters()["rejected-connections+reason=max-connections"].Value())
+}
+
+func TestServerTLSCertificateReload(t *testing.T) {
+	files := newTestTLSFiles(t)
+	defer files.close()
+
+	scope := tally.NewTestScope("", nil)
+	iOpts := instrument.NewOptions().SetMetricsScope(scope)
+	cfg := files.configuration()
+	cfg.ClientAuth = tlsClientAuthNone
+	config, err := cfg.NewTLSConfig(iOpts)
+	require.NoError(t, err)
+
+	h := newDrainHandler()
+	s := NewServer(testListenAddress, h, NewOptions().
+		SetInstrumentOptions(iOpts).
+		SetTLSConfig(config)).(*server)
+	require.NoError(t, s.ListenAndServe())
+	defer s.Close()
+
+	dial := func() *tls.Conn {
+		conn, err := tls.Dial("tcp", s.listeners[0].Addr().String(), &tls.Config{
+			RootCAs:    files.caPool,
+			ServerName: "server",
+		})
+		require.NoError(t, err)
+		return conn
+	}
+
+	before := dial()
+	defer before.Close()
+	require.Equal(t, int64(2), before.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+
+	files.writeServerCert(t, newTestCert(t, "server", 4, files.ca), time.Now().Add(time.Minute))
+	after := dial()
+	defer after.Close()
+	require.Equal(t, int64(4), after.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	require.Equal(t, int64(1), scope.Snapshot().Counters()["tls-certificate-reloads+"].Value())
+
+	// The existing connection is still usable.
+	_, err = before.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	// Invalid files keep the previous certificate.
+	require.NoError(t, ioutil.WriteFile(files.keyFile, []byte("invalid"), 0600))
+	last := dial()
+	defer last.Close()
+	require.Equal(t, int64(4), last.ConnectionState().PeerCertificates[0].SerialNumber.Int64())
+	require.Equal(t, int64(1), scope.Snapshot().Counters()["tls-certificate-reload-errors+"].Value())
+}
+
+func TestPeerIdentityFromConnWithoutTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	_, ok := PeerIdentityFromConn(server)
+	require.False(t, ok)
+}
+
+type identityHandler struct {
+	sync.Mutex
+
+	handled    int
+	identities chan PeerIdentity
+}
+
+func newIdentityHandler() *identityHandler {
+	return &identityHandler{identities: make(chan PeerIdentity, 16)}
+}
+
+func (h *identityHandler) Handle(conn net.Conn) {
+	h.Lock()
+	h.handled++
+	h.Unlock()
+
+	if identity, ok := PeerIdentityFromConn(conn); ok {
+		h.identities <- identity
+	}
+	ioutil.ReadAll(conn)
+}
+
+func (h *identityHandler) Close() {}
+
+func (h *identityHandler) numHandled() int {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.handled
+}
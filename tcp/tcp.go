@@ -22,10 +22,14 @@
 package tcp
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
 	"time"
 )
 
+var errNotTCPListener = errors.New("listener is not a TCP listener")
+
 // NewTCPListener is Listener specifically for TCP
 //
 // TODO(jeromefroe): Move this into the net package which covers network I/O.
@@ -37,6 +41,34 @@ func NewTCPListener(listenAddress string, keepAlivePeriod time.Duration) (net.Li
 	return tcpKeepAliveListener{l.(*net.TCPListener), keepAlivePeriod}, err
 }
 
+// NewTCPKeepAliveListener wraps a TCP listener, such as one inherited from a
+// parent process, so that it sets keep-alives on accepted connections the
+// same way as listeners created with NewTCPListener.
+func NewTCPKeepAliveListener(l net.Listener, keepAlivePeriod time.Duration) (net.Listener, error) {
+	switch tl := l.(type) {
+	case tcpKeepAliveListener:
+		return tcpKeepAliveListener{tl.TCPListener, keepAlivePeriod}, nil
+	case *net.TCPListener:
+		return tcpKeepAliveListener{tl, keepAlivePeriod}, nil
+	default:
+		return nil, errNotTCPListener
+	}
+}
+
+// NewTLSListener is a TCP Listener that serves connections over TLS with the
+// config, keep-alives are set on the underlying TCP connections.
+func NewTLSListener(
+	listenAddress string,
+	keepAlivePeriod time.Duration,
+	config *tls.Config,
+) (net.Listener, error) {
+	l, err := NewTCPListener(listenAddress, keepAlivePeriod)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, config), nil
+}
+
 // tcpKeepAliveListener sets TCP keep-alive timeouts on accepted
 // connections. It's used by ListenAndServe and ListenAndServeTLS so
 // dead TCP connections (e.g. closing laptop mid-download) eventually